@@ -0,0 +1,230 @@
+package API
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes the fake provider can simulate
+const (
+	FakeOutcomePaid    = "paid"
+	FakeOutcomeFailed  = "failed"
+	FakeOutcomeExpired = "expired"
+)
+
+// fakeSecret signs fake callbacks and webhooks so they go through the same verification as real ones
+const fakeSecret = "fake-payment-provider"
+
+type fakeLink struct {
+	request   PaymentLinkRequest
+	status    string
+	paymentId string
+	refunded  int64
+}
+
+// FakeProvider keeps payment links in memory and settles them on demand.
+// It is meant for local development and tests; state is lost on restart.
+type FakeProvider struct {
+	mutex    sync.Mutex
+	links    map[string]*fakeLink
+	sequence int
+}
+
+var (
+	fakeProvider     *FakeProvider
+	fakeProviderOnce sync.Once
+)
+
+// GetFakeProvider returns the process wide fake provider
+func GetFakeProvider() *FakeProvider {
+	fakeProviderOnce.Do(func() {
+		fakeProvider = &FakeProvider{links: map[string]*fakeLink{}}
+	})
+	return fakeProvider
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) SupportsCurrency(currency string) bool {
+	return currency != ""
+}
+
+func (p *FakeProvider) nextId(prefix string) string {
+	p.sequence++
+	return fmt.Sprintf("%s_%d_%d", prefix, time.Now().Unix(), p.sequence)
+}
+
+func fakeSign(parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(fakeSecret))
+	for _, part := range parts {
+		mac.Write([]byte(part + "|"))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *FakeProvider) CreatePaymentLink(request PaymentLinkRequest) (*PaymentLink, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	id := p.nextId("fake_plink")
+	p.links[id] = &fakeLink{request: request, status: LinkStatusCreated}
+
+	// The checkout page is our own dev route, see routers.HandleFakeCheckout
+	shortURL := os.Getenv("BACKEND_API_DOMAIN") + "/fake-payments/" + id
+
+	return &PaymentLink{Id: id, ShortURL: shortURL, Status: LinkStatusCreated, Raw: map[string]interface{}{
+		"id":           id,
+		"short_url":    shortURL,
+		"amount":       request.Amount,
+		"currency":     request.Currency,
+		"reference_id": request.ReferenceId,
+	}}, nil
+}
+
+func (p *FakeProvider) FetchPaymentLink(linkId string) (*PaymentLinkState, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	link, ok := p.links[linkId]
+	if !ok {
+		return nil, fmt.Errorf("fake payment link %s not found", linkId)
+	}
+
+	state := &PaymentLinkState{Id: linkId, ReferenceId: link.request.ReferenceId, Status: link.status, PaymentId: link.paymentId}
+	if link.status == LinkStatusPaid {
+		state.AmountPaid = link.request.Amount
+	}
+	return state, nil
+}
+
+func (p *FakeProvider) CancelPaymentLink(linkId string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	link, ok := p.links[linkId]
+	if !ok {
+		return fmt.Errorf("fake payment link %s not found", linkId)
+	}
+	if link.status != LinkStatusCreated {
+		return fmt.Errorf("fake payment link %s is already %s", linkId, link.status)
+	}
+	link.status = LinkStatusCancelled
+	return nil
+}
+
+// Simulate settles a link with an outcome and returns the webhook body and
+// signature the provider would have sent, plus the customer's return URL
+func (p *FakeProvider) Simulate(linkId string, outcome string) ([]byte, string, string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	link, ok := p.links[linkId]
+	if !ok {
+		return nil, "", "", fmt.Errorf("fake payment link %s not found", linkId)
+	}
+	if link.status != LinkStatusCreated {
+		return nil, "", "", fmt.Errorf("fake payment link %s is already %s", linkId, link.status)
+	}
+
+	event := PaymentEvent{
+		Id:          p.nextId("fake_evt"),
+		ReferenceId: link.request.ReferenceId,
+		LinkId:      linkId,
+		Amount:      link.request.Amount,
+		Currency:    link.request.Currency,
+	}
+
+	switch outcome {
+	case FakeOutcomePaid:
+		link.status = LinkStatusPaid
+		link.paymentId = p.nextId("fake_pay")
+		event.Type = EventPaymentLinkPaid
+		event.PaymentId = link.paymentId
+		event.Status = LinkStatusPaid
+	case FakeOutcomeFailed:
+		// A failed attempt leaves the link payable, as with real providers
+		event.Type = EventPaymentFailed
+		event.PaymentId = p.nextId("fake_pay")
+		event.Error = "Simulated card decline"
+	case FakeOutcomeExpired:
+		link.status = LinkStatusExpired
+		event.Type = EventPaymentLinkExpired
+		event.Status = LinkStatusExpired
+	default:
+		return nil, "", "", fmt.Errorf("unknown outcome %q", outcome)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	query := url.Values{}
+	query.Set("provider", "fake")
+	query.Set("fake_link_id", linkId)
+	query.Set("fake_status", link.status)
+	query.Set("fake_signature", fakeSign(linkId, link.status))
+	return body, fakeSign(string(body)), appendQuery(link.request.CallbackURL, query.Encode()), nil
+}
+
+func (p *FakeProvider) VerifyCallback(query url.Values) (*PaymentEvent, error) {
+	linkId := query.Get("fake_link_id")
+	status := query.Get("fake_status")
+	if !hmac.Equal([]byte(fakeSign(linkId, status)), []byte(query.Get("fake_signature"))) {
+		return nil, fmt.Errorf("callback signature is invalid")
+	}
+
+	state, err := p.FetchPaymentLink(linkId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentEvent{
+		Type:        "callback",
+		ReferenceId: state.ReferenceId,
+		LinkId:      state.Id,
+		PaymentId:   state.PaymentId,
+		Status:      state.Status,
+		Amount:      state.AmountPaid,
+	}, nil
+}
+
+func (p *FakeProvider) VerifyWebhook(header http.Header, body []byte) (*PaymentEvent, error) {
+	if !hmac.Equal([]byte(fakeSign(string(body))), []byte(header.Get("X-Fake-Signature"))) {
+		return nil, fmt.Errorf("webhook signature is invalid")
+	}
+
+	var event PaymentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (p *FakeProvider) Refund(paymentId string, amount int64, notes map[string]string) (*RefundResult, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, link := range p.links {
+		if link.paymentId != paymentId {
+			continue
+		}
+		if link.refunded+amount > link.request.Amount {
+			return nil, fmt.Errorf("refund exceeds the amount paid")
+		}
+		link.refunded += amount
+		return &RefundResult{Id: p.nextId("fake_rfnd"), Status: "processed", Amount: amount}, nil
+	}
+
+	return nil, fmt.Errorf("fake payment %s not found", paymentId)
+}
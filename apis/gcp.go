@@ -1,14 +1,10 @@
 package API
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"time"
+	"strings"
 )
 
 type PromptRequest struct {
@@ -35,94 +31,63 @@ type OpenAIResponse struct {
 const promptTextTone = "Summary Generation Tone : Generated text should be highly dopamin producing and produce satisfaction"
 const extraPrompt = promptTextTone + ""
 
-func GenerateContentFromTextGCP(prompt string) (string, error) {
-	url := "https://api.openai.com/v1/chat/completions"
+// PromptProfile is what we know about the client beyond their scores
+type PromptProfile struct {
+	Age            int
+	Gender         string
+	EducationLevel string
+	Stream         string
+	Occupation     string
+	Goals          []string
+	City           string
+}
 
-	model := os.Getenv("AI_MODEL")
-	if model == "" {
-		model = "gpt-4o-mini"
-	}
+func CreatePrompt(score []Domain, profile *PromptProfile) string {
+	resultPrompt := CreatePromptResultV2(score, profile)
 
-	// Create the request body
-	requestBody, err := json.Marshal(map[string]interface{}{
-		// "model": "gpt-4o-mini", // Replace with the correct model name
-		"model": model,
-		"messages": []map[string]string{
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
-	})
+	return resultPrompt
+}
 
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %v", err)
+// createProfilePrompt describes the client so recommendations fit their life stage
+func createProfilePrompt(profile *PromptProfile) string {
+	if profile == nil {
+		return ""
 	}
 
-	apiKey := os.Getenv("API_KEY") // Ensure the variable name matches
-	if apiKey == "" {
-		return "", fmt.Errorf("API key is not set")
+	var lines []string
+	if profile.Age > 0 {
+		lines = append(lines, fmt.Sprintf("    Age: %d", profile.Age))
 	}
-
-	client := &http.Client{
-		Timeout: 30 * time.Second, // Increase timeout to 30 seconds
+	if profile.Gender != "" {
+		lines = append(lines, "    Gender: "+profile.Gender)
 	}
-
-	var response OpenAIResponse
-	retries := 3
-
-	// Retry mechanism
-	for i := 0; i < retries; i++ {
-		// Create the HTTP request
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-		if err != nil {
-			return "", fmt.Errorf("failed to create request: %v", err)
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-
-		// Send the request
-		resp, err := client.Do(req)
-		if err != nil {
-			if i == retries-1 {
-				return "", fmt.Errorf("failed to send request after %d attempts: %v", retries, err)
-			}
-			time.Sleep(2 * time.Second) // Wait before retrying
-			continue
-		}
-		defer resp.Body.Close()
-
-		// Check if the response status code is OK
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return "", fmt.Errorf("non-200 status code: %d, response: %s", resp.StatusCode, string(body))
-		}
-
-		// Decode the response body
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return "", fmt.Errorf("failed to decode response: %v", err)
-		}
-
-		// Check if the response contains choices
-		if len(response.Choices) == 0 {
-			return "", fmt.Errorf("no choices in response")
-		}
-
-		// Return the content of the first choice
-		return response.Choices[0].Message.Content, nil
+	if profile.EducationLevel != "" {
+		lines = append(lines, "    Education Level: "+profile.EducationLevel)
+	}
+	if profile.Stream != "" {
+		lines = append(lines, "    Stream: "+profile.Stream)
+	}
+	if profile.Occupation != "" {
+		lines = append(lines, "    Current Occupation: "+profile.Occupation)
+	}
+	if len(profile.Goals) > 0 {
+		lines = append(lines, "    Goals: "+strings.Join(profile.Goals, ", "))
+	}
+	if profile.City != "" {
+		lines = append(lines, "    City: "+profile.City)
 	}
 
-	return "", fmt.Errorf("failed to get a valid response after %d attempts", retries)
-}
-
-func CreatePrompt(score []Domain) string {
-	resultPrompt := CreatePromptResultV2(score)
+	if len(lines) == 0 {
+		return ""
+	}
 
-	return resultPrompt
+	return "Client Profile-\n" + strings.Join(lines, "\n") + "\n" +
+		"Tailor the 'Career Pathways' and 'Academic Pathways' sections to this profile. " +
+		"For a school student focus on streams, subjects and entrance routes; for a college student on specialisations and first jobs; " +
+		"for a working professional on role changes, upskilling and growth in their current field rather than school subjects.\n\n"
 }
 
-func CreatePromptResultV2(score []Domain) string {
+func CreatePromptResultV2(score []Domain, profile *PromptProfile) string {
 	neuroticismDomain := score[0]
 
 	neuroticismScore := neuroticismDomain.Score
@@ -263,7 +228,7 @@ func CreatePromptResultV2(score []Domain) string {
 	}
 
 	prompt := fmt.Sprintf("%s\n\n"+
-		"Domain: Neuroticism Score: %s/60 (%s)\n"+
+		"Domain: Neuroticism Score: %d/60 (%s)\n"+
 		"  Subdomains of Neuroticism-\n"+
 		"    Anxiety Score : %s\n"+
 		"    Anger Score: %s\n"+
@@ -272,7 +237,7 @@ func CreatePromptResultV2(score []Domain) string {
 		"    Immoderation Score: %s\n"+
 		"    Vulnerability Score: %s\n\n"+
 
-		"Domain: Extraversion Score: %s/60 (%s)\n"+
+		"Domain: Extraversion Score: %d/60 (%s)\n"+
 		"  Subdomains of Extraversion-\n"+
 		"    Friendliness Score: %s\n"+
 		"    Gregariousness Score: %s\n"+
@@ -281,7 +246,7 @@ func CreatePromptResultV2(score []Domain) string {
 		"    Excitement Seeking Score: %s\n"+
 		"    Cheerfulness Score: %s\n\n"+
 
-		"Domain: Openness: %s/60 (%s)\n"+
+		"Domain: Openness: %d/60 (%s)\n"+
 		"  Subdomains of Openness-\n"+
 		"    Imagination Score: %s\n"+
 		"    Artistic Interests Score: %s\n"+
@@ -290,7 +255,7 @@ func CreatePromptResultV2(score []Domain) string {
 		"    Intellect Score: %s\n"+
 		"    Liberalism Score: %s\n\n"+
 
-		"Domain: Agreeableness: %s/60 (%s)\n"+
+		"Domain: Agreeableness: %d/60 (%s)\n"+
 		"  Subdomains of Agreeableness-\n"+
 		"    Trust Score: %s\n"+
 		"    Morality Score: %s\n"+
@@ -299,14 +264,15 @@ func CreatePromptResultV2(score []Domain) string {
 		"    Modesty Score: %s\n"+
 		"    Sympathy Score: %s\n\n"+
 
-		"Domain: Conscientiousness: %s/60 (%s)\n"+
+		"Domain: Conscientiousness: %d/60 (%s)\n"+
 		"  Subdomains of Conscientiousness-\n"+
 		"    Self Efficacy Score: %s\n"+
 		"    Orderliness Score: %s\n"+
 		"    Dutifulness Score: %s\n"+
 		"    Achievement Striving Score: %s\n"+
 		"    Self Discipline Score: %s\n"+
-		"    Cautiousness Score: %s\n"+
+		"    Cautiousness Score: %s\n\n"+
+		"%s"+
 		`'OUTPUT JSON FORMAT': %s`,
 		systemPrompt,
 		neuroticismScore, neuroticismIntensity, n1I, n2I, n3I, n4I, n5I, n6I,
@@ -314,6 +280,7 @@ func CreatePromptResultV2(score []Domain) string {
 		opennessScore, opennessIntensity, o1I, o2I, o3I, o4I, o5I, o6I,
 		agreeablenessScore, agreeablenessIntensity, a1I, a2I, a3I, a4I, a5I, a6I,
 		conscientiousnessScore, conscientiousnessIntensity, c1I, c2I, c3I, c4I, c5I, c6I,
+		createProfilePrompt(profile),
 		outputJsonFormat)
 
 	return prompt
@@ -418,7 +385,7 @@ func CreatePromptResult(score []Domain) string {
 
 	prompt := fmt.Sprintf("Using the Big 5 Assessment score given below, create a Summary by combining all domain in around 300-400 words in total. "+extraPrompt+". "+
 
-		"Domain: Neuroticism Score: %s/60 (%s)\n"+
+		"Domain: Neuroticism Score: %d/60 (%s)\n"+
 		"  Subdomains of Neuroticism-\n"+
 		"    Anxiety Score : %s\n"+
 		"    Anger Score: %s\n"+
@@ -427,7 +394,7 @@ func CreatePromptResult(score []Domain) string {
 		"    Immoderation Score: %s\n"+
 		"    Vulnerability Score: %s\n\n"+
 
-		"Domain: Extraversion Score: %s/60 (%s)\n"+
+		"Domain: Extraversion Score: %d/60 (%s)\n"+
 		"  Subdomains of Extraversion-\n"+
 		"    Friendliness Score: %s\n"+
 		"    Gregariousness Score: %s\n"+
@@ -436,7 +403,7 @@ func CreatePromptResult(score []Domain) string {
 		"    Excitement Seeking Score: %s\n"+
 		"    Cheerfulness Score: %s\n\n"+
 
-		"Domain: Openness: %s/60 (%s)\n"+
+		"Domain: Openness: %d/60 (%s)\n"+
 		"  Subdomains of Openness-\n"+
 		"    Imagination Score: %s\n"+
 		"    Artistic Interests Score: %s\n"+
@@ -445,7 +412,7 @@ func CreatePromptResult(score []Domain) string {
 		"    Intellect Score: %s\n"+
 		"    Liberalism Score: %s\n\n"+
 
-		"Domain: Agreeableness: %s/60 (%s)\n"+
+		"Domain: Agreeableness: %d/60 (%s)\n"+
 		"  Subdomains of Agreeableness-\n"+
 		"    Trust Score: %s\n"+
 		"    Morality Score: %s\n"+
@@ -454,7 +421,7 @@ func CreatePromptResult(score []Domain) string {
 		"    Modesty Score: %s\n"+
 		"    Sympathy Score: %s\n\n"+
 
-		"Domain: Conscientiousness: %s/60 (%s)\n"+
+		"Domain: Conscientiousness: %d/60 (%s)\n"+
 		"  Subdomains of Conscientiousness-\n"+
 		"    Self Efficacy Score: %s\n"+
 		"    Orderliness Score: %s\n"+
@@ -571,7 +538,7 @@ func CreatePromptInsights(score []Domain) string {
 
 	prompt := fmt.Sprintf("Using the Big 5 Assessment score given below, create a Insight page with section Relationship, Career & Academia, Strength & Weakness "+extraPrompt+". "+
 
-		"Domain: Neuroticism Score: %s/60 (%s)\n"+
+		"Domain: Neuroticism Score: %d/60 (%s)\n"+
 		"  Subdomains of Neuroticism-\n"+
 		"    Anxiety Score : %s\n"+
 		"    Anger Score: %s\n"+
@@ -580,7 +547,7 @@ func CreatePromptInsights(score []Domain) string {
 		"    Immoderation Score: %s\n"+
 		"    Vulnerability Score: %s\n\n"+
 
-		"Domain: Extraversion Score: %s/60 (%s)\n"+
+		"Domain: Extraversion Score: %d/60 (%s)\n"+
 		"  Subdomains of Extraversion-\n"+
 		"    Friendliness Score: %s\n"+
 		"    Gregariousness Score: %s\n"+
@@ -589,7 +556,7 @@ func CreatePromptInsights(score []Domain) string {
 		"    Excitement Seeking Score: %s\n"+
 		"    Cheerfulness Score: %s\n\n"+
 
-		"Domain: Openness: %s/60 (%s)\n"+
+		"Domain: Openness: %d/60 (%s)\n"+
 		"  Subdomains of Openness-\n"+
 		"    Imagination Score: %s\n"+
 		"    Artistic Interests Score: %s\n"+
@@ -598,7 +565,7 @@ func CreatePromptInsights(score []Domain) string {
 		"    Intellect Score: %s\n"+
 		"    Liberalism Score: %s\n\n"+
 
-		"Domain: Agreeableness: %s/60 (%s)\n"+
+		"Domain: Agreeableness: %d/60 (%s)\n"+
 		"  Subdomains of Agreeableness-\n"+
 		"    Trust Score: %s\n"+
 		"    Morality Score: %s\n"+
@@ -607,7 +574,7 @@ func CreatePromptInsights(score []Domain) string {
 		"    Modesty Score: %s\n"+
 		"    Sympathy Score: %s\n\n"+
 
-		"Domain: Conscientiousness: %s/60 (%s)\n"+
+		"Domain: Conscientiousness: %d/60 (%s)\n"+
 		"  Subdomains of Conscientiousness-\n"+
 		"    Self Efficacy Score: %s\n"+
 		"    Orderliness Score: %s\n"+
@@ -724,7 +691,7 @@ func CreatePromptCareerAcademic(score []Domain) string {
 
 	prompt := fmt.Sprintf("Using the Big 5 Assessment score given below, create Career & Academia Page under 200 words for the Report\n\n"+extraPrompt+". "+
 
-		"Domain: Neuroticism Score: %s/60 (%s)\n"+
+		"Domain: Neuroticism Score: %d/60 (%s)\n"+
 		"  Subdomains of Neuroticism-\n"+
 		"    Anxiety Score : %s\n"+
 		"    Anger Score: %s\n"+
@@ -733,7 +700,7 @@ func CreatePromptCareerAcademic(score []Domain) string {
 		"    Immoderation Score: %s\n"+
 		"    Vulnerability Score: %s\n\n"+
 
-		"Domain: Extraversion Score: %s/60 (%s)\n"+
+		"Domain: Extraversion Score: %d/60 (%s)\n"+
 		"  Subdomains of Extraversion-\n"+
 		"    Friendliness Score: %s\n"+
 		"    Gregariousness Score: %s\n"+
@@ -742,7 +709,7 @@ func CreatePromptCareerAcademic(score []Domain) string {
 		"    Excitement Seeking Score: %s\n"+
 		"    Cheerfulness Score: %s\n\n"+
 
-		"Domain: Openness: %s/60 (%s)\n"+
+		"Domain: Openness: %d/60 (%s)\n"+
 		"  Subdomains of Openness-\n"+
 		"    Imagination Score: %s\n"+
 		"    Artistic Interests Score: %s\n"+
@@ -751,7 +718,7 @@ func CreatePromptCareerAcademic(score []Domain) string {
 		"    Intellect Score: %s\n"+
 		"    Liberalism Score: %s\n\n"+
 
-		"Domain: Agreeableness: %s/60 (%s)\n"+
+		"Domain: Agreeableness: %d/60 (%s)\n"+
 		"  Subdomains of Agreeableness-\n"+
 		"    Trust Score: %s\n"+
 		"    Morality Score: %s\n"+
@@ -760,7 +727,7 @@ func CreatePromptCareerAcademic(score []Domain) string {
 		"    Modesty Score: %s\n"+
 		"    Sympathy Score: %s\n\n"+
 
-		"Domain: Conscientiousness: %s/60 (%s)\n"+
+		"Domain: Conscientiousness: %d/60 (%s)\n"+
 		"  Subdomains of Conscientiousness-\n"+
 		"    Self Efficacy Score: %s\n"+
 		"    Orderliness Score: %s\n"+
@@ -877,7 +844,7 @@ func CreatePromptRelationship(score []Domain) string {
 
 	prompt := fmt.Sprintf("Using the Big 5 Assessment score given below, create Relationship page under 200 words for the Report\n\n"+extraPrompt+". "+
 
-		"Domain: Neuroticism Score: %s/60 (%s)\n"+
+		"Domain: Neuroticism Score: %d/60 (%s)\n"+
 		"  Subdomains of Neuroticism-\n"+
 		"    Anxiety Score : %s\n"+
 		"    Anger Score: %s\n"+
@@ -886,7 +853,7 @@ func CreatePromptRelationship(score []Domain) string {
 		"    Immoderation Score: %s\n"+
 		"    Vulnerability Score: %s\n\n"+
 
-		"Domain: Extraversion Score: %s/60 (%s)\n"+
+		"Domain: Extraversion Score: %d/60 (%s)\n"+
 		"  Subdomains of Extraversion-\n"+
 		"    Friendliness Score: %s\n"+
 		"    Gregariousness Score: %s\n"+
@@ -895,7 +862,7 @@ func CreatePromptRelationship(score []Domain) string {
 		"    Excitement Seeking Score: %s\n"+
 		"    Cheerfulness Score: %s\n\n"+
 
-		"Domain: Openness: %s/60 (%s)\n"+
+		"Domain: Openness: %d/60 (%s)\n"+
 		"  Subdomains of Openness-\n"+
 		"    Imagination Score: %s\n"+
 		"    Artistic Interests Score: %s\n"+
@@ -904,7 +871,7 @@ func CreatePromptRelationship(score []Domain) string {
 		"    Intellect Score: %s\n"+
 		"    Liberalism Score: %s\n\n"+
 
-		"Domain: Agreeableness: %s/60 (%s)\n"+
+		"Domain: Agreeableness: %d/60 (%s)\n"+
 		"  Subdomains of Agreeableness-\n"+
 		"    Trust Score: %s\n"+
 		"    Morality Score: %s\n"+
@@ -913,7 +880,7 @@ func CreatePromptRelationship(score []Domain) string {
 		"    Modesty Score: %s\n"+
 		"    Sympathy Score: %s\n\n"+
 
-		"Domain: Conscientiousness: %s/60 (%s)\n"+
+		"Domain: Conscientiousness: %d/60 (%s)\n"+
 		"  Subdomains of Conscientiousness-\n"+
 		"    Self Efficacy Score: %s\n"+
 		"    Orderliness Score: %s\n"+
@@ -1030,7 +997,7 @@ func CreatePromptStrengthWeakness(score []Domain) string {
 
 	prompt := fmt.Sprintf("Using the Big 5 Assessment score given below, create Strength & Weakness page under 200 words for the Report\n\n"+extraPrompt+". "+
 
-		"Domain: Neuroticism Score: %s/60 (%s)\n"+
+		"Domain: Neuroticism Score: %d/60 (%s)\n"+
 		"  Subdomains of Neuroticism-\n"+
 		"    Anxiety Score : %s\n"+
 		"    Anger Score: %s\n"+
@@ -1039,7 +1006,7 @@ func CreatePromptStrengthWeakness(score []Domain) string {
 		"    Immoderation Score: %s\n"+
 		"    Vulnerability Score: %s\n\n"+
 
-		"Domain: Extraversion Score: %s/60 (%s)\n"+
+		"Domain: Extraversion Score: %d/60 (%s)\n"+
 		"  Subdomains of Extraversion-\n"+
 		"    Friendliness Score: %s\n"+
 		"    Gregariousness Score: %s\n"+
@@ -1048,7 +1015,7 @@ func CreatePromptStrengthWeakness(score []Domain) string {
 		"    Excitement Seeking Score: %s\n"+
 		"    Cheerfulness Score: %s\n\n"+
 
-		"Domain: Openness: %s/60 (%s)\n"+
+		"Domain: Openness: %d/60 (%s)\n"+
 		"  Subdomains of Openness-\n"+
 		"    Imagination Score: %s\n"+
 		"    Artistic Interests Score: %s\n"+
@@ -1057,7 +1024,7 @@ func CreatePromptStrengthWeakness(score []Domain) string {
 		"    Intellect Score: %s\n"+
 		"    Liberalism Score: %s\n\n"+
 
-		"Domain: Agreeableness: %s/60 (%s)\n"+
+		"Domain: Agreeableness: %d/60 (%s)\n"+
 		"  Subdomains of Agreeableness-\n"+
 		"    Trust Score: %s\n"+
 		"    Morality Score: %s\n"+
@@ -1066,7 +1033,7 @@ func CreatePromptStrengthWeakness(score []Domain) string {
 		"    Modesty Score: %s\n"+
 		"    Sympathy Score: %s\n\n"+
 
-		"Domain: Conscientiousness: %s/60 (%s)\n"+
+		"Domain: Conscientiousness: %d/60 (%s)\n"+
 		"  Subdomains of Conscientiousness-\n"+
 		"    Self Efficacy Score: %s\n"+
 		"    Orderliness Score: %s\n"+
@@ -1084,11 +1051,11 @@ func CreatePromptStrengthWeakness(score []Domain) string {
 	return prompt
 }
 
+// WorkerOpenAIGPT writes the section named id, with the provider configured for it
 func WorkerOpenAIGPT(id string, prompt string, channel chan PromptRequest) {
-	// Call the OpenAI API
-	result, err := GenerateContentFromTextGCP(prompt)
+	result, _, err := GenerateSection(strings.ToUpper(id), prompt)
 	if err != nil {
-		log.Printf("Error generating content from OpenAI for ID %s: %v", id, err)
+		log.Printf("Error generating content for ID %s: %v", id, err)
 		channel <- PromptRequest{
 			Id: id,
 			Response: OpenAIResponse{
@@ -0,0 +1,130 @@
+package API
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CountryResolver finds the country a client connects from, to price in its
+// currency when no billing country was given
+type CountryResolver interface {
+	Name() string
+	// CountryForIP returns an ISO 3166 alpha-2 code, or "" when unknown
+	CountryForIP(ip string) (string, error)
+}
+
+var (
+	countryResolver     CountryResolver
+	countryResolverOnce sync.Once
+)
+
+// GetCountryResolver returns the resolver named by GEOIP_RESOLVER: http,
+// static or none, the default
+func GetCountryResolver() CountryResolver {
+	countryResolverOnce.Do(func() {
+		switch strings.ToLower(os.Getenv("GEOIP_RESOLVER")) {
+		case "http":
+			countryResolver = NewHttpCountryResolver()
+		case "static":
+			countryResolver = &StaticCountryResolver{Country: strings.ToUpper(os.Getenv("GEOIP_STATIC_COUNTRY"))}
+		default:
+			countryResolver = &StaticCountryResolver{}
+		}
+	})
+	return countryResolver
+}
+
+// StaticCountryResolver answers the same country for everyone. Without a
+// country it is the none resolver; with one it helps test pricing locally.
+type StaticCountryResolver struct {
+	Country string
+}
+
+func (r *StaticCountryResolver) Name() string {
+	if r.Country == "" {
+		return "none"
+	}
+	return "static"
+}
+
+func (r *StaticCountryResolver) CountryForIP(ip string) (string, error) {
+	return r.Country, nil
+}
+
+// HttpCountryResolver asks a lookup service that answers the bare country
+// code, like ipapi.co/<ip>/country/. Answers are cached for a day.
+type HttpCountryResolver struct {
+	urlFormat string // %s is replaced by the IP address
+	client    *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedCountry
+}
+
+type cachedCountry struct {
+	country string
+	at      time.Time
+}
+
+const countryCacheTTL = 24 * time.Hour
+
+func NewHttpCountryResolver() *HttpCountryResolver {
+	urlFormat := os.Getenv("GEOIP_API_URL")
+	if urlFormat == "" {
+		urlFormat = "https://ipapi.co/%s/country/"
+	}
+	return &HttpCountryResolver{
+		urlFormat: urlFormat,
+		client:    &http.Client{Timeout: 3 * time.Second},
+		cache:     map[string]cachedCountry{},
+	}
+}
+
+func (r *HttpCountryResolver) Name() string {
+	return "http"
+}
+
+func (r *HttpCountryResolver) CountryForIP(ip string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsUnspecified() {
+		return "", nil
+	}
+
+	r.mu.Lock()
+	cached, ok := r.cache[ip]
+	r.mu.Unlock()
+	if ok && time.Since(cached.at) < countryCacheTTL {
+		return cached.country, nil
+	}
+
+	resp, err := r.client.Get(fmt.Sprintf(r.urlFormat, ip))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("country lookup for %s failed with status %d", ip, resp.StatusCode)
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(string(body)))
+	if len(country) != 2 {
+		country = ""
+	}
+
+	r.mu.Lock()
+	r.cache[ip] = cachedCountry{country: country, at: time.Now()}
+	r.mu.Unlock()
+
+	return country, nil
+}
@@ -0,0 +1,120 @@
+package API
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// InvoiceDocumentLine is one printed item. Amounts are already formatted.
+type InvoiceDocumentLine struct {
+	Description   string
+	HsnSac        string
+	Quantity      int64
+	TaxRate       int64
+	TaxableAmount string
+}
+
+// InvoiceDocument holds everything printed on a tax invoice
+type InvoiceDocument struct {
+	Number        string
+	Date          string
+	SellerName    string
+	SellerAddress string
+	SellerGstin   string
+	BuyerName     string
+	BuyerEmail    string
+	BuyerAddress  string
+	BuyerGstin    string
+	PlaceOfSupply string
+	Lines         []InvoiceDocumentLine
+	TaxableAmount string
+	Cgst          string // Empty when not charged
+	Sgst          string
+	Igst          string
+	Total         string
+}
+
+// WriteInvoicePDF renders a one page GST tax invoice
+func WriteInvoicePDF(w io.Writer, invoice InvoiceDocument) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	pdf.SetTextColor(17, 45, 78)
+	pdf.SetFont("Arial", "B", 18)
+	pdf.CellFormat(180, 10, "TAX INVOICE", "", 1, "C", false, 0, "")
+	pdf.Ln(4)
+
+	// Seller on the left, invoice details on the right
+	pdf.SetFont("Arial", "B", 11)
+	pdf.CellFormat(110, 6, invoice.SellerName, "", 0, "L", false, 0, "")
+	pdf.CellFormat(70, 6, "Invoice No: "+invoice.Number, "", 1, "R", false, 0, "")
+	pdf.SetFont("Arial", "", 10)
+	pdf.CellFormat(110, 5, invoice.SellerAddress, "", 0, "L", false, 0, "")
+	pdf.CellFormat(70, 5, "Date: "+invoice.Date, "", 1, "R", false, 0, "")
+	pdf.CellFormat(110, 5, "GSTIN: "+invoice.SellerGstin, "", 0, "L", false, 0, "")
+	pdf.CellFormat(70, 5, "Place of supply: "+invoice.PlaceOfSupply, "", 1, "R", false, 0, "")
+	pdf.Ln(6)
+
+	pdf.SetFont("Arial", "B", 11)
+	pdf.CellFormat(180, 6, "Billed to", "", 1, "L", false, 0, "")
+	pdf.SetFont("Arial", "", 10)
+	pdf.CellFormat(180, 5, invoice.BuyerName, "", 1, "L", false, 0, "")
+	if invoice.BuyerAddress != "" {
+		pdf.MultiCell(180, 5, invoice.BuyerAddress, "", "L", false)
+	}
+	if invoice.BuyerEmail != "" {
+		pdf.CellFormat(180, 5, invoice.BuyerEmail, "", 1, "L", false, 0, "")
+	}
+	if invoice.BuyerGstin != "" {
+		pdf.CellFormat(180, 5, "GSTIN: "+invoice.BuyerGstin, "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// Items
+	widths := []float64{80, 25, 15, 20, 40}
+	headers := []string{"Description", "HSN/SAC", "Qty", "GST %", "Taxable value"}
+	pdf.SetFont("Arial", "B", 10)
+	pdf.SetFillColor(230, 236, 245)
+	for i, header := range headers {
+		pdf.CellFormat(widths[i], 8, header, "1", 0, "C", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Arial", "", 10)
+	for _, line := range invoice.Lines {
+		pdf.CellFormat(widths[0], 8, line.Description, "1", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 8, line.HsnSac, "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[2], 8, fmt.Sprintf("%d", line.Quantity), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[3], 8, fmt.Sprintf("%d", line.TaxRate), "1", 0, "C", false, 0, "")
+		pdf.CellFormat(widths[4], 8, line.TaxableAmount, "1", 1, "R", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	// Totals
+	totals := [][2]string{{"Taxable value", invoice.TaxableAmount}}
+	if invoice.Cgst != "" {
+		totals = append(totals, [2]string{"CGST", invoice.Cgst}, [2]string{"SGST", invoice.Sgst})
+	}
+	if invoice.Igst != "" {
+		totals = append(totals, [2]string{"IGST", invoice.Igst})
+	}
+	for _, total := range totals {
+		pdf.CellFormat(140, 7, total[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 7, total[1], "", 1, "R", false, 0, "")
+	}
+	pdf.SetFont("Arial", "B", 11)
+	pdf.CellFormat(140, 8, "Total", "T", 0, "R", false, 0, "")
+	pdf.CellFormat(40, 8, invoice.Total, "T", 1, "R", false, 0, "")
+
+	pdf.Ln(12)
+	pdf.SetFont("Arial", "I", 9)
+	pdf.MultiCell(180, 5, "This is a computer generated invoice and does not require a signature.", "", "C", false)
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
@@ -0,0 +1,394 @@
+package API
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report sections that can each be sent to their own provider and model.
+// LLMSectionReport is the whole narrative written from CreatePrompt; the
+// others are the older per page prompts.
+const (
+	LLMSectionReport           = "REPORT"
+	LLMSectionResult           = "RESULT"
+	LLMSectionRelationship     = "RELATIONSHIP"
+	LLMSectionCareerAcademic   = "CAREER_ACADEMIC"
+	LLMSectionStrengthWeakness = "STRENGTH_WEAKNESS"
+)
+
+// LLMClient is a text generation backend
+type LLMClient interface {
+	Name() string
+	// DefaultModel is used when a section doesn't name a model
+	DefaultModel() string
+	Generate(model string, prompt string) (string, error)
+}
+
+var (
+	llmClients     = map[string]LLMClient{}
+	llmClientsOnce sync.Once
+)
+
+// loadLLMClients registers the backends. The fake one is only available when
+// LLM_FAKE_ENABLED=true so it can't write real reports.
+func loadLLMClients() {
+	llmClients["openai"] = NewOpenAIClient()
+	llmClients["gemini"] = NewGeminiClient()
+	llmClients["ollama"] = NewOllamaClient()
+	if os.Getenv("LLM_FAKE_ENABLED") == "true" {
+		log.Println(":: Warning : fake LLM client enabled")
+		llmClients["fake"] = &FakeLLMClient{}
+	}
+}
+
+// GetLLMClient returns a registered backend by name
+func GetLLMClient(name string) (LLMClient, error) {
+	llmClientsOnce.Do(loadLLMClients)
+
+	client, ok := llmClients[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("LLM provider %q is not available", name)
+	}
+	return client, nil
+}
+
+// LLMRoute is a provider and model to generate a section with
+type LLMRoute struct {
+	Provider string
+	Model    string
+}
+
+// llmSetting reads LLM_<SECTION>_<NAME>, falling back to LLM_<NAME>
+func llmSetting(section string, name string) string {
+	if value := os.Getenv("LLM_" + section + "_" + name); value != "" {
+		return value
+	}
+	return os.Getenv("LLM_" + name)
+}
+
+// LLMRoutesFor returns the primary route of a section and, when one is set,
+// its fallback. Each is configured by LLM_<SECTION>_PROVIDER and
+// LLM_<SECTION>_MODEL, or LLM_FALLBACK_PROVIDER and LLM_FALLBACK_MODEL with
+// the section prefix, defaulting to the unprefixed LLM_ settings. The
+// primary provider is openai unless set.
+func LLMRoutesFor(section string) []LLMRoute {
+	section = strings.ToUpper(section)
+
+	primary := LLMRoute{Provider: llmSetting(section, "PROVIDER"), Model: llmSetting(section, "MODEL")}
+	if primary.Provider == "" {
+		primary.Provider = "openai"
+	}
+	routes := []LLMRoute{primary}
+
+	fallback := LLMRoute{Provider: llmSetting(section, "FALLBACK_PROVIDER"), Model: llmSetting(section, "FALLBACK_MODEL")}
+	if fallback.Provider != "" && fallback != primary {
+		routes = append(routes, fallback)
+	}
+
+	return routes
+}
+
+// GenerateSection writes a report section with its configured provider,
+// failing over to the fallback when the primary errors. Returns the route
+// that produced the text.
+func GenerateSection(section string, prompt string) (string, LLMRoute, error) {
+	var lastErr error
+
+	for i, route := range LLMRoutesFor(section) {
+		client, err := GetLLMClient(route.Provider)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if route.Model == "" {
+			route.Model = client.DefaultModel()
+		}
+
+		content, err := client.Generate(route.Model, prompt)
+		if err == nil {
+			if i > 0 {
+				log.Printf(":: Warning : %s section generated by fallback %s/%s", section, route.Provider, route.Model)
+			}
+			return content, route, nil
+		}
+
+		log.Printf(":: Error : %s/%s failed for %s section: %v", route.Provider, route.Model, section, err)
+		lastErr = err
+	}
+
+	return "", LLMRoute{}, fmt.Errorf("no LLM provider could generate the %s section: %v", section, lastErr)
+}
+
+// llmRetryDelay is the pause between attempts of a request
+var llmRetryDelay = 2 * time.Second
+
+// postJSON sends body and decodes the answer into out. Network errors, rate
+// limits and server errors are retried; other statuses are returned at once.
+func postJSON(client *http.Client, url string, headers map[string]string, body interface{}, out interface{}) error {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %v", err)
+	}
+
+	retries := 3
+	var lastErr error
+
+	for i := 0; i < retries; i++ {
+		if i > 0 {
+			time.Sleep(llmRetryDelay) // Wait before retrying
+		}
+
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to send request: %v", err)
+			continue
+		}
+
+		responseBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("failed to read response: %v", err)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("non-200 status code: %d, response: %s", resp.StatusCode, string(responseBody))
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("non-200 status code: %d, response: %s", resp.StatusCode, string(responseBody))
+		}
+
+		if err := json.Unmarshal(responseBody, out); err != nil {
+			return fmt.Errorf("failed to decode response: %v", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed after %d attempts: %v", retries, lastErr)
+}
+
+// OpenAIClient calls an OpenAI compatible chat completions API. OPENAI_BASE_URL
+// points it at other compatible services.
+type OpenAIClient struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func NewOpenAIClient() *OpenAIClient {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	// API_KEY and AI_MODEL are the settings from before there was a choice of provider
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("API_KEY")
+	}
+	model := os.Getenv("AI_MODEL")
+	if model == "" {
+		model = "gpt-4o-mini"
+	}
+
+	return &OpenAIClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *OpenAIClient) Name() string {
+	return "openai"
+}
+
+func (c *OpenAIClient) DefaultModel() string {
+	return c.model
+}
+
+func (c *OpenAIClient) Generate(model string, prompt string) (string, error) {
+	if c.apiKey == "" {
+		return "", fmt.Errorf("API key is not set")
+	}
+
+	var response OpenAIResponse
+	err := postJSON(c.client, c.baseURL+"/chat/completions", map[string]string{"Authorization": "Bearer " + c.apiKey}, map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no choices in response")
+	}
+	return response.Choices[0].Message.Content, nil
+}
+
+// GeminiClient calls Google's Gemini generateContent API
+type GeminiClient struct {
+	baseURL string
+	apiKey  string
+	model   string
+	client  *http.Client
+}
+
+func NewGeminiClient() *GeminiClient {
+	model := os.Getenv("GEMINI_MODEL")
+	if model == "" {
+		model = "gemini-1.5-flash"
+	}
+
+	return &GeminiClient{
+		baseURL: "https://generativelanguage.googleapis.com/v1beta",
+		apiKey:  os.Getenv("GEMINI_API_KEY"),
+		model:   model,
+		client:  &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (c *GeminiClient) Name() string {
+	return "gemini"
+}
+
+func (c *GeminiClient) DefaultModel() string {
+	return c.model
+}
+
+func (c *GeminiClient) Generate(model string, prompt string) (string, error) {
+	if c.apiKey == "" {
+		return "", fmt.Errorf("GEMINI_API_KEY is not set")
+	}
+
+	var response struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+			FinishReason string `json:"finishReason"`
+		} `json:"candidates"`
+	}
+
+	endpoint := c.baseURL + "/models/" + url.PathEscape(model) + ":generateContent"
+	err := postJSON(c.client, endpoint, map[string]string{"x-goog-api-key": c.apiKey}, map[string]interface{}{
+		"contents": []map[string]interface{}{
+			{"role": "user", "parts": []map[string]string{{"text": prompt}}},
+		},
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	if len(response.Candidates) == 0 {
+		return "", fmt.Errorf("no candidates in response")
+	}
+	var text strings.Builder
+	for _, part := range response.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("empty response, finish reason %s", response.Candidates[0].FinishReason)
+	}
+	return text.String(), nil
+}
+
+// OllamaClient calls a local Ollama server at OLLAMA_URL
+type OllamaClient struct {
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+func NewOllamaClient() *OllamaClient {
+	baseURL := os.Getenv("OLLAMA_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	model := os.Getenv("OLLAMA_MODEL")
+	if model == "" {
+		model = "llama3.1"
+	}
+
+	return &OllamaClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: 5 * time.Minute}, // Local models on a CPU are slow
+	}
+}
+
+func (c *OllamaClient) Name() string {
+	return "ollama"
+}
+
+func (c *OllamaClient) DefaultModel() string {
+	return c.model
+}
+
+func (c *OllamaClient) Generate(model string, prompt string) (string, error) {
+	var response struct {
+		Response string `json:"response"`
+		Error    string `json:"error"`
+	}
+
+	err := postJSON(c.client, c.baseURL+"/api/generate", nil, map[string]interface{}{
+		"model":  model,
+		"prompt": prompt,
+		"stream": false,
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.Error != "" {
+		return "", fmt.Errorf("ollama: %s", response.Error)
+	}
+	return response.Response, nil
+}
+
+// FakeLLMClient answers without calling anything. The same model and prompt
+// always give the same text, so reports can be compared in tests. A model
+// named "error" fails, to exercise the failover.
+type FakeLLMClient struct{}
+
+func (c *FakeLLMClient) Name() string {
+	return "fake"
+}
+
+func (c *FakeLLMClient) DefaultModel() string {
+	return "fake-1"
+}
+
+func (c *FakeLLMClient) Generate(model string, prompt string) (string, error) {
+	if model == "error" {
+		return "", fmt.Errorf("fake LLM failure")
+	}
+	return fmt.Sprintf("Generated by %s for prompt %x", model, sha256.Sum256([]byte(prompt))), nil
+}
@@ -0,0 +1,124 @@
+package API
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLLMRoutesFor(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []LLMRoute
+	}{
+		{"defaults to openai", nil, []LLMRoute{{Provider: "openai"}}},
+		{
+			"global settings",
+			map[string]string{"LLM_PROVIDER": "gemini", "LLM_MODEL": "gemini-pro"},
+			[]LLMRoute{{Provider: "gemini", Model: "gemini-pro"}},
+		},
+		{
+			"section overrides global",
+			map[string]string{"LLM_PROVIDER": "gemini", "LLM_RESULT_PROVIDER": "ollama", "LLM_RESULT_MODEL": "llama3.1"},
+			[]LLMRoute{{Provider: "ollama", Model: "llama3.1"}},
+		},
+		{
+			"with fallback",
+			map[string]string{"LLM_RESULT_PROVIDER": "openai", "LLM_FALLBACK_PROVIDER": "gemini"},
+			[]LLMRoute{{Provider: "openai"}, {Provider: "gemini"}},
+		},
+		{
+			"fallback same as primary",
+			map[string]string{"LLM_RESULT_PROVIDER": "ollama", "LLM_RESULT_FALLBACK_PROVIDER": "ollama"},
+			[]LLMRoute{{Provider: "ollama"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"LLM_PROVIDER", "LLM_MODEL", "LLM_FALLBACK_PROVIDER", "LLM_FALLBACK_MODEL", "LLM_RESULT_PROVIDER", "LLM_RESULT_MODEL", "LLM_RESULT_FALLBACK_PROVIDER", "LLM_RESULT_FALLBACK_MODEL"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			if got := LLMRoutesFor("result"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LLMRoutesFor(result) = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSectionFailover(t *testing.T) {
+	// Read when the registry loads, which no other test does
+	t.Setenv("LLM_FAKE_ENABLED", "true")
+	t.Setenv("LLM_REPORT_PROVIDER", "fake")
+	t.Setenv("LLM_REPORT_MODEL", "error")
+	t.Setenv("LLM_REPORT_FALLBACK_PROVIDER", "fake")
+	t.Setenv("LLM_REPORT_FALLBACK_MODEL", "fake-2")
+
+	content, route, err := GenerateSection(LLMSectionReport, "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route != (LLMRoute{Provider: "fake", Model: "fake-2"}) {
+		t.Errorf("generated by %+v, want the fallback", route)
+	}
+	if want, _ := (&FakeLLMClient{}).Generate("fake-2", "prompt"); content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+
+	t.Setenv("LLM_REPORT_FALLBACK_MODEL", "error")
+	if _, _, err := GenerateSection(LLMSectionReport, "prompt"); err == nil {
+		t.Error("no error when every route fails")
+	}
+}
+
+func TestPostJSONRetries(t *testing.T) {
+	delay := llmRetryDelay
+	llmRetryDelay = 0
+	t.Cleanup(func() { llmRetryDelay = delay })
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{"ok", []int{http.StatusOK}, false, 1},
+		{"server error then ok", []int{http.StatusBadGateway, http.StatusOK}, false, 2},
+		{"rate limited then ok", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}, false, 3},
+		{"always failing", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}, true, 3},
+		{"client error is not retried", []int{http.StatusBadRequest}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := atomic.AddInt32(&attempts, 1)
+				if r.Header.Get("Authorization") != "Bearer key" {
+					t.Errorf("attempt %d lost its headers", attempt)
+				}
+				w.WriteHeader(tt.statuses[attempt-1])
+				w.Write([]byte(`{"answer":"ok"}`))
+			}))
+			defer server.Close()
+
+			var out struct {
+				Answer string `json:"answer"`
+			}
+			err := postJSON(server.Client(), server.URL, map[string]string{"Authorization": "Bearer key"}, map[string]string{"q": "x"}, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && out.Answer != "ok" {
+				t.Errorf("answer = %q", out.Answer)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package API
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"net"
 	"time"
@@ -130,3 +131,152 @@ func Mail() {
 	body := "Hey, here's your report!"
 	sendEmail(recipientEmail, subject, body, pdfFile)
 }
+
+func SendGuardianConsentRequest(to string, guardianName string, childName string, approveLink string, declineLink string) error {
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        %s has completed the Big 5 Personality Test on Mind Sarthi and listed you as their parent or guardian.
+        As they are under 18, we need your consent before we process their answers and prepare their report.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        <a href="%s" style="color: #007BFF; text-decoration: none;">I consent, continue to payment</a>
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        If you do not agree, <a href="%s" style="color: #007BFF; text-decoration: none;">decline here</a> and we will not generate a report.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, guardianName, childName, approveLink, declineLink)
+
+	err := sendEmail(to, "Your consent is needed for "+childName+"'s personality report", htmlBody, "")
+
+	return err
+}
+
+func SendPaymentLink(to string, name string, paymentLink string) error {
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        Here is a new payment link for your Big 5 Personality Report. Your answers are saved, so there is no need to take the test again.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        <a href="%s" style="color: #1a73e8;">Complete your payment</a>
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        Any earlier payment link for this test no longer works.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, name, paymentLink)
+
+	err := sendEmail(to, "Your new Mind Sarthi payment link", htmlBody, "")
+
+	return err
+}
+
+func SendRefundConfirmation(to string, name string, amount string, reportRevoked bool) error {
+
+	reportNote := "You can continue to view your report at any time."
+	if reportRevoked {
+		reportNote = "As the payment has been returned, the report linked to this test is no longer available."
+	}
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        We have issued a refund of <strong>%s</strong> for your Big 5 Personality Test.
+        It usually reaches your account within 5 to 7 working days, depending on your bank.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">%s</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        If you have any questions, simply reply to this email.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, name, amount, reportNote)
+
+	err := sendEmail(to, "Your refund from Mind Sarthi has been issued", htmlBody, "")
+
+	return err
+}
+
+func SendInvoice(to string, name string, invoiceNumber string, attachmentPath string) error {
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        Thank you for your payment. Please find attached tax invoice <strong>%s</strong> for your Big 5 Personality Report.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        You can also download it any time from your dashboard.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, name, invoiceNumber)
+
+	err := sendEmail(to, "Your Mind Sarthi invoice "+invoiceNumber, htmlBody, attachmentPath)
+
+	return err
+}
+
+func SendGiftInvitation(to string, recipientName string, buyerName string, message string, giftLink string) error {
+
+	note := ""
+	if message != "" {
+		note = fmt.Sprintf(`<p style="color: black; font-family: Arial, sans-serif;"><em>"%s"</em></p>`, html.EscapeString(message))
+	}
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        %s has gifted you a Big 5 Personality Report on Mind Sarthi. It is already paid for, so you only need to take the test.
+      </p>
+      %s
+      <p style="color: black; font-family: Arial, sans-serif;">
+        <a href="%s" style="color: #1a73e8;">Take your test</a>
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        Your report is private to you. %s will only see it if you choose to share it.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, html.EscapeString(recipientName), html.EscapeString(buyerName), note, giftLink, html.EscapeString(buyerName))
+
+	err := sendEmail(to, buyerName+" has gifted you a personality report", htmlBody, "")
+
+	return err
+}
+
+func SendGiftCompleted(to string, buyerName string, recipientName string) error {
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        %s has taken the test you gifted them and their Big 5 Personality Report is ready.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        The report is private to them; you will get a link if they choose to share it with you.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, html.EscapeString(buyerName), html.EscapeString(recipientName))
+
+	err := sendEmail(to, "Your gift has been used", htmlBody, "")
+
+	return err
+}
+
+func SendGiftReportShared(to string, buyerName string, recipientName string, link string) error {
+
+	htmlBody := fmt.Sprintf(`
+      <p style="color: black; font-family: Arial, sans-serif;">Hi %s,</p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        %s has shared the personality report you gifted them.
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">
+        <a href="%s" style="color: #1a73e8;">View the report</a>
+      </p>
+      <p style="color: black; font-family: Arial, sans-serif;">Warm regards,<br><strong>Nitish</strong><br> Mind Sarthi</p>
+    `, html.EscapeString(buyerName), html.EscapeString(recipientName), link)
+
+	err := sendEmail(to, recipientName+" shared their personality report with you", htmlBody, "")
+
+	return err
+}
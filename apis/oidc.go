@@ -0,0 +1,323 @@
+package API
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// IdentityProvider is one configured OpenID Connect issuer
+type IdentityProvider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// LinkByEmail lets a verified email from this issuer sign in to an
+	// existing account that was never linked to it
+	LinkByEmail bool
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// IdentityClaims are the verified fields we use from an ID token
+type IdentityClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+var oidcHttpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Well known issuers so deployments only have to set client credentials
+var defaultIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+}
+
+// Issuers trusted to verify the email of their accounts, unless
+// OIDC_EMAIL_LINK_PROVIDERS names others
+var defaultEmailLinkProviders = "google"
+
+var identityProviders map[string]*IdentityProvider
+var identityProvidersOnce sync.Once
+
+// GetIdentityProvider returns the provider configured under name.
+// OIDC_PROVIDERS lists the enabled providers and each one reads
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and _SCOPES.
+// Pointing _ISSUER at a local mock issuer is enough to exercise the flow.
+// Only providers in OIDC_EMAIL_LINK_PROVIDERS may link to an existing account
+// by email; any issuer can claim email_verified for an address it doesn't own.
+func GetIdentityProvider(name string) (*IdentityProvider, error) {
+	identityProvidersOnce.Do(func() {
+		identityProviders = map[string]*IdentityProvider{}
+
+		emailLinkProviders := os.Getenv("OIDC_EMAIL_LINK_PROVIDERS")
+		if emailLinkProviders == "" {
+			emailLinkProviders = defaultEmailLinkProviders
+		}
+		linkByEmail := map[string]bool{}
+		for _, providerName := range strings.Split(emailLinkProviders, ",") {
+			linkByEmail[strings.ToLower(strings.TrimSpace(providerName))] = true
+		}
+
+		for _, providerName := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			providerName = strings.ToLower(strings.TrimSpace(providerName))
+			if providerName == "" {
+				continue
+			}
+			prefix := "OIDC_" + strings.ToUpper(providerName) + "_"
+
+			issuer := os.Getenv(prefix + "ISSUER")
+			if issuer == "" {
+				issuer = defaultIssuers[providerName]
+			}
+
+			scopes := []string{"openid", "email", "profile"}
+			if configured := os.Getenv(prefix + "SCOPES"); configured != "" {
+				scopes = strings.Split(configured, " ")
+			}
+
+			identityProviders[providerName] = &IdentityProvider{
+				Name:         providerName,
+				Issuer:       strings.TrimSuffix(issuer, "/"),
+				ClientId:     os.Getenv(prefix + "CLIENT_ID"),
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+				Scopes:       scopes,
+				LinkByEmail:  linkByEmail[providerName],
+			}
+		}
+	})
+
+	provider, ok := identityProviders[strings.ToLower(name)]
+	if !ok || provider.Issuer == "" || provider.ClientId == "" {
+		return nil, fmt.Errorf("identity provider %s is not configured", name)
+	}
+	return provider, nil
+}
+
+func (p *IdentityProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	resp, err := oidcHttpClient.Get(p.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery document returned status %d", resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("failed to decode discovery document: %v", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %s does not match %s", discovery.Issuer, p.Issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// AuthCodeURL builds the authorization endpoint URL the browser is sent to
+func (p *IdentityProvider) AuthCodeURL(state string, nonce string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientId)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+
+	return discovery.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+// ExchangeCode redeems an authorization code and returns the raw ID token
+func (p *IdentityProvider) ExchangeCode(code string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientId)
+	form.Set("client_secret", p.ClientSecret)
+
+	resp, err := oidcHttpClient.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResponse struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if tokenResponse.IdToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+
+	return tokenResponse.IdToken, nil
+}
+
+// fetchKey loads the issuer's JWKS. Keys are refetched when an unknown kid
+// shows up, at most once a minute, so provider key rotation is picked up.
+func (p *IdentityProvider) fetchKey(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) > time.Minute
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := oidcHttpClient.Get(discovery.JwksURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode jwks: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	return key, nil
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce of an ID token
+func (p *IdentityProvider) VerifyIDToken(idToken string, nonce string) (*IdentityClaims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.fetchKey(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid id token claims")
+	}
+
+	if !claims.VerifyIssuer(p.Issuer, true) && !claims.VerifyIssuer(strings.TrimPrefix(p.Issuer, "https://"), true) {
+		return nil, fmt.Errorf("id token issuer mismatch")
+	}
+	if !claims.VerifyAudience(p.ClientId, true) {
+		return nil, fmt.Errorf("id token audience mismatch")
+	}
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+
+	identity := &IdentityClaims{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+
+	// Some issuers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	return identity, nil
+}
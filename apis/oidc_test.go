@@ -0,0 +1,164 @@
+package API
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockIssuer is a minimal OpenID Connect issuer. The token endpoint answers
+// every code with idToken.
+type mockIssuer struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JwksURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kid: "k1",
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockIssuer) provider() *IdentityProvider {
+	return &IdentityProvider{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientId:    "client-1",
+		RedirectURL: "http://localhost/auth/oidc/mock/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+}
+
+func TestIdentityProviderLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(authURL)
+	if !strings.HasSuffix(parsed.Path, "/authorize") || parsed.Query().Get("state") != "state-1" || parsed.Query().Get("nonce") != "nonce-1" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	valid := jwt.MapClaims{
+		"iss":            issuer.server.URL,
+		"aud":            "client-1",
+		"sub":            "subject-1",
+		"email":          "taker@example.com",
+		"email_verified": "true",
+		"nonce":          "nonce-1",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+	issuer.idToken = issuer.sign(t, valid)
+
+	idToken, err := provider.ExchangeCode("code-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := provider.VerifyIDToken(idToken, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "subject-1" || identity.Email != "taker@example.com" || !identity.EmailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestIdentityProviderRejectsToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	claims := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":   issuer.server.URL,
+			"aud":   "client-1",
+			"sub":   "subject-1",
+			"nonce": "nonce-1",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+		change(c)
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"other nonce", claims(func(c jwt.MapClaims) { c["nonce"] = "nonce-2" })},
+		{"other audience", claims(func(c jwt.MapClaims) { c["aud"] = "client-2" })},
+		{"other issuer", claims(func(c jwt.MapClaims) { c["iss"] = "https://issuer.example.com" })},
+		{"expired", claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })},
+		{"no subject", claims(func(c jwt.MapClaims) { delete(c, "sub") })},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.VerifyIDToken(issuer.sign(t, tt.claims), "nonce-1"); err == nil {
+				t.Error("token was accepted")
+			}
+		})
+	}
+
+	// A token signed by a key the issuer doesn't publish
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, claims(func(jwt.MapClaims) {}))
+	forged.Header["kid"] = "k1"
+	signed, _ := forged.SignedString(otherKey)
+	if _, err := provider.VerifyIDToken(signed, "nonce-1"); err == nil {
+		t.Error("forged token was accepted")
+	}
+}
@@ -0,0 +1,185 @@
+package API
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Normalised payment link states. They match Razorpay's values, which are
+// also what tests store as their payment status.
+const (
+	LinkStatusCreated   = "created"
+	LinkStatusPaid      = "paid"
+	LinkStatusExpired   = "expired"
+	LinkStatusCancelled = "cancelled"
+)
+
+// Normalised webhook event types, named after Razorpay's events
+const (
+	EventPaymentLinkPaid    = "payment_link.paid"
+	EventPaymentLinkExpired = "payment_link.expired"
+	EventPaymentFailed      = "payment.failed"
+	EventRefundProcessed    = "refund.processed"
+)
+
+// PaymentLinkRequest describes a hosted payment page for one test
+type PaymentLinkRequest struct {
+	ReferenceId   string // Our reference, e.g. big5_<testId>
+	Amount        int64  // Minor units
+	Currency      string
+	Description   string
+	CustomerName  string
+	CustomerEmail string
+	ExpireBy      time.Time
+	CallbackURL   string // Where the customer is sent back to
+	NotifyEmail   bool   // Let the provider email the link too
+}
+
+// PaymentLink is a created payment page
+type PaymentLink struct {
+	Id       string
+	ShortURL string
+	Status   string
+	Raw      map[string]interface{}
+}
+
+// PaymentLinkState is the provider's current view of a link
+type PaymentLinkState struct {
+	Id          string
+	ReferenceId string
+	Status      string // One of the LinkStatus values
+	PaymentId   string // Settling payment, once paid
+	AmountPaid  int64
+	Raw         map[string]interface{}
+}
+
+// PaymentEvent is a verified callback or webhook in provider neutral form
+type PaymentEvent struct {
+	Id          string // Provider event id, used to drop retried deliveries
+	Type        string // One of the Event values, or the provider's own name when unmapped
+	ReferenceId string
+	LinkId      string
+	PaymentId   string
+	RefundId    string
+	Status      string
+	Amount      int64
+	Currency    string
+	Error       string
+}
+
+// RefundResult is a refund accepted by the provider
+type RefundResult struct {
+	Id     string
+	Status string
+	Amount int64
+	Raw    map[string]interface{}
+}
+
+// PaymentProvider is a payment gateway that can sell a test through a hosted link
+type PaymentProvider interface {
+	Name() string
+	// SupportsCurrency reports whether links can be created in a currency
+	SupportsCurrency(currency string) bool
+	CreatePaymentLink(request PaymentLinkRequest) (*PaymentLink, error)
+	FetchPaymentLink(linkId string) (*PaymentLinkState, error)
+	// CancelPaymentLink stops an unpaid link from being paid
+	CancelPaymentLink(linkId string) error
+	// VerifyCallback checks the query the customer is redirected back with
+	VerifyCallback(query url.Values) (*PaymentEvent, error)
+	// VerifyWebhook checks the signature of a server to server notification and parses it
+	VerifyWebhook(header http.Header, body []byte) (*PaymentEvent, error)
+	Refund(paymentId string, amount int64, notes map[string]string) (*RefundResult, error)
+}
+
+var (
+	paymentProviders     = map[string]PaymentProvider{}
+	paymentProvidersOnce sync.Once
+)
+
+// loadPaymentProviders registers the gateways. The fake provider is only
+// available when PAYMENT_FAKE_ENABLED=true so it can't be reached in production.
+func loadPaymentProviders() {
+	paymentProviders["razorpay"] = &RazorpayProvider{}
+	paymentProviders["stripe"] = NewStripeProvider()
+	if os.Getenv("PAYMENT_FAKE_ENABLED") == "true" {
+		log.Println(":: Warning : fake payment provider enabled")
+		paymentProviders["fake"] = GetFakeProvider()
+	}
+}
+
+// GetPaymentProvider returns a registered provider by name
+func GetPaymentProvider(name string) (PaymentProvider, error) {
+	paymentProvidersOnce.Do(loadPaymentProviders)
+
+	provider, ok := paymentProviders[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("payment provider %q is not available", name)
+	}
+	return provider, nil
+}
+
+// DefaultPaymentProvider is PAYMENT_PROVIDER, razorpay unless set
+func DefaultPaymentProvider() (PaymentProvider, error) {
+	name := os.Getenv("PAYMENT_PROVIDER")
+	if name == "" {
+		name = "razorpay"
+	}
+	return GetPaymentProvider(name)
+}
+
+// PaymentProviderFor returns the provider that charges in currency: the
+// default one when it can, otherwise PAYMENT_PROVIDER_INTERNATIONAL, stripe
+// unless set
+func PaymentProviderFor(currency string) (PaymentProvider, error) {
+	provider, err := DefaultPaymentProvider()
+	if err == nil && provider.SupportsCurrency(currency) {
+		return provider, nil
+	}
+
+	name := os.Getenv("PAYMENT_PROVIDER_INTERNATIONAL")
+	if name == "" {
+		name = "stripe"
+	}
+	international, err := GetPaymentProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	if !international.SupportsCurrency(currency) {
+		return nil, fmt.Errorf("no payment provider accepts %s", currency)
+	}
+	return international, nil
+}
+
+// appendQuery adds an encoded query to a URL that may already carry one
+func appendQuery(rawURL string, query string) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + query
+}
+
+// int64Field reads a JSON number decoded into a map
+func int64Field(data map[string]interface{}, key string) int64 {
+	switch value := data[key].(type) {
+	case float64:
+		return int64(value)
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	}
+	return 0
+}
+
+// stringField reads a string decoded into a map
+func stringField(data map[string]interface{}, key string) string {
+	value, _ := data[key].(string)
+	return value
+}
@@ -0,0 +1,238 @@
+package API
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testStripeSecret = "whsec_test"
+
+func stripeSignature(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func stripeWebhook(t *testing.T, eventType string, object map[string]interface{}) []byte {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":   "evt_1",
+		"type": eventType,
+		"data": map[string]interface{}{"object": object},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestStripeWebhookSignature(t *testing.T) {
+	provider := &StripeProvider{webhookSecret: testStripeSecret}
+	body := stripeWebhook(t, "checkout.session.expired", map[string]interface{}{"id": "cs_1"})
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		body      []byte
+		wantErr   bool
+	}{
+		{"valid", testStripeSecret, stripeSignature(testStripeSecret, time.Now(), body), body, false},
+		{"other secret", testStripeSecret, stripeSignature("whsec_other", time.Now(), body), body, true},
+		{"tampered body", testStripeSecret, stripeSignature(testStripeSecret, time.Now(), body), append([]byte(" "), body...), true},
+		{"too old", testStripeSecret, stripeSignature(testStripeSecret, time.Now().Add(-10*time.Minute), body), body, true},
+		{"no timestamp", testStripeSecret, "v1=abc", body, true},
+		{"no secret configured", "", stripeSignature("", time.Now(), body), body, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.webhookSecret = tt.secret
+			header := http.Header{}
+			header.Set("Stripe-Signature", tt.signature)
+
+			_, err := provider.VerifyWebhook(header, tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStripeWebhookParsing(t *testing.T) {
+	provider := &StripeProvider{webhookSecret: testStripeSecret}
+
+	tests := []struct {
+		name      string
+		eventType string
+		object    map[string]interface{}
+		want      PaymentEvent
+	}{
+		{
+			"paid session",
+			"checkout.session.completed",
+			map[string]interface{}{"id": "cs_1", "client_reference_id": "big5_1", "status": "complete", "payment_status": "paid", "payment_intent": "pi_1", "amount_total": 49900, "currency": "usd"},
+			PaymentEvent{Id: "evt_1", Type: EventPaymentLinkPaid, ReferenceId: "big5_1", LinkId: "cs_1", PaymentId: "pi_1", Status: LinkStatusPaid, Amount: 49900, Currency: "USD"},
+		},
+		{
+			"session completed before the money arrived",
+			"checkout.session.completed",
+			map[string]interface{}{"id": "cs_1", "status": "complete", "payment_status": "unpaid"},
+			PaymentEvent{Id: "evt_1", Type: "checkout.session.completed"},
+		},
+		{
+			"expired session",
+			"checkout.session.expired",
+			map[string]interface{}{"id": "cs_1", "client_reference_id": "big5_1"},
+			PaymentEvent{Id: "evt_1", Type: EventPaymentLinkExpired, ReferenceId: "big5_1", LinkId: "cs_1", Status: LinkStatusExpired},
+		},
+		{
+			"failed payment",
+			"payment_intent.payment_failed",
+			map[string]interface{}{"id": "pi_1", "amount": 49900, "metadata": map[string]interface{}{"reference_id": "big5_1"}, "last_payment_error": map[string]interface{}{"message": "Card declined"}},
+			PaymentEvent{Id: "evt_1", Type: EventPaymentFailed, ReferenceId: "big5_1", PaymentId: "pi_1", Amount: 49900, Error: "Card declined"},
+		},
+		{
+			"succeeded refund",
+			"refund.updated",
+			map[string]interface{}{"id": "re_1", "status": "succeeded", "payment_intent": "pi_1", "amount": 10000},
+			PaymentEvent{Id: "evt_1", Type: EventRefundProcessed, RefundId: "re_1", PaymentId: "pi_1", Status: "processed", Amount: 10000},
+		},
+		{
+			"pending refund",
+			"refund.created",
+			map[string]interface{}{"id": "re_1", "status": "pending", "payment_intent": "pi_1", "amount": 10000},
+			PaymentEvent{Id: "evt_1", Type: "refund.created"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := stripeWebhook(t, tt.eventType, tt.object)
+			header := http.Header{}
+			header.Set("Stripe-Signature", stripeSignature(testStripeSecret, time.Now(), body))
+
+			event, err := provider.VerifyWebhook(header, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *event != tt.want {
+				t.Errorf("event = %+v, want %+v", *event, tt.want)
+			}
+		})
+	}
+}
+
+// The Stripe redirect is only a hint, the session is read back from the API
+func TestStripeCallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key != "sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "bad key"}})
+			return
+		}
+		if r.URL.Path != "/checkout/sessions/cs_1" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "no such session"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "cs_1", "client_reference_id": "big5_1", "status": "complete", "payment_status": "paid", "payment_intent": "pi_1", "amount_total": 49900,
+		})
+	}))
+	defer server.Close()
+
+	provider := &StripeProvider{apiKey: "sk_test", baseURL: server.URL, client: server.Client()}
+
+	event, err := provider.VerifyCallback(url.Values{"session_id": {"cs_1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PaymentEvent{Type: "callback", ReferenceId: "big5_1", LinkId: "cs_1", PaymentId: "pi_1", Status: LinkStatusPaid, Amount: 49900}
+	if *event != want {
+		t.Errorf("event = %+v, want %+v", *event, want)
+	}
+
+	if _, err := provider.VerifyCallback(url.Values{"session_id": {"cs_unknown"}}); err == nil {
+		t.Error("callback for an unknown session was accepted")
+	}
+	if _, err := provider.VerifyCallback(url.Values{}); err == nil {
+		t.Error("callback without a session was accepted")
+	}
+}
+
+func TestFakeProviderWebhookAndCallback(t *testing.T) {
+	provider := &FakeProvider{links: map[string]*fakeLink{}}
+
+	link, err := provider.CreatePaymentLink(PaymentLinkRequest{ReferenceId: "big5_1", Amount: 49900, Currency: "INR", CallbackURL: "http://localhost/payment/callback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed attempt leaves the link payable
+	body, signature, _, err := provider.Simulate(link.Id, FakeOutcomeFailed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := provider.VerifyWebhook(http.Header{"X-Fake-Signature": {signature}}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventPaymentFailed || event.ReferenceId != "big5_1" || event.Error == "" {
+		t.Errorf("failed event = %+v", *event)
+	}
+
+	body, signature, returnURL, err := provider.Simulate(link.Id, FakeOutcomePaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.VerifyWebhook(http.Header{"X-Fake-Signature": {signature}}, append(body, ' ')); err == nil {
+		t.Error("tampered webhook was accepted")
+	}
+	event, err = provider.VerifyWebhook(http.Header{"X-Fake-Signature": {signature}}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventPaymentLinkPaid || event.LinkId != link.Id || event.PaymentId == "" || event.Amount != 49900 || event.Currency != "INR" {
+		t.Errorf("paid event = %+v", *event)
+	}
+
+	parsed, err := url.Parse(returnURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := provider.VerifyCallback(parsed.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Status != LinkStatusPaid || callback.ReferenceId != "big5_1" || callback.PaymentId != event.PaymentId || callback.Amount != 49900 {
+		t.Errorf("callback = %+v", *callback)
+	}
+
+	forged := parsed.Query()
+	forged.Set("fake_status", LinkStatusExpired)
+	if _, err := provider.VerifyCallback(forged); err == nil {
+		t.Error("callback with a changed status was accepted")
+	}
+
+	if _, _, _, err := provider.Simulate(link.Id, FakeOutcomePaid); err == nil {
+		t.Error("a paid link was settled again")
+	}
+	if _, err := provider.Refund(event.PaymentId, 50000, nil); err == nil {
+		t.Error("refund above the amount paid was accepted")
+	}
+	if _, err := provider.Refund(event.PaymentId, 49900, nil); err != nil {
+		t.Errorf("full refund failed: %v", err)
+	}
+}
@@ -1,8 +1,16 @@
 package API
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 
 	razorpay "github.com/razorpay/razorpay-go"
@@ -54,7 +62,8 @@ func CreatePaymentLinkData(upiLink bool, amount int, currency string, acceptPart
 		},
 		"reminder_enable": reminderEnable,
 		"notes": map[string]interface{}{
-			"policy_name": policyName,
+			"policy_name":  policyName,
+			"reference_id": referenceID, // Copied onto payments so payment.failed webhooks can be matched to the test
 		},
 		"callback_url":    callbackURL,
 		"callback_method": callbackMethod,
@@ -144,3 +153,258 @@ func VerifyPaymentLink(params map[string]interface{}, signature string) bool {
 
 	return isVerified
 }
+
+// CancelPaymentLink cancels a link that has not been paid yet
+func CancelPaymentLink(paymentLinkId string) (map[string]interface{}, error) {
+
+	client := GetClient()
+
+	body, err := client.PaymentLink.Cancel(paymentLinkId, nil, nil)
+
+	if err != nil && err.Error() != "" {
+		log.Printf("::Razorpay Error : %v", err.Error())
+	}
+
+	return body, err
+}
+
+// RefundPayment refunds amount (in paise) of a captured payment
+func RefundPayment(paymentId string, amount int, notes map[string]interface{}) (map[string]interface{}, error) {
+
+	client := GetClient()
+
+	data := map[string]interface{}{
+		"speed": "normal",
+		"notes": notes,
+	}
+
+	body, err := client.Payment.Refund(paymentId, amount, data, nil)
+
+	if err != nil && err.Error() != "" {
+		log.Printf("::Razorpay Error : %v", err.Error())
+	}
+
+	return body, err
+}
+
+// VerifyWebhookSignature checks the X-Razorpay-Signature header against the raw request body
+func VerifyWebhookSignature(body []byte, signature string) bool {
+
+	webhookSecret := os.Getenv("RAZORPAY_WEBHOOK_SECRET")
+	if webhookSecret == "" || signature == "" {
+		return false
+	}
+
+	// HMAC-SHA256 of the body keyed with the webhook secret, compared in constant time
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	expectedSignature := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expectedSignature), []byte(signature))
+}
+
+// Razorpay webhook delivery. Only the entities listed in the event's "contains" are present.
+type razorpayWebhook struct {
+	Event   string `json:"event"`
+	Payload struct {
+		PaymentLink *struct {
+			Entity razorpayEntity `json:"entity"`
+		} `json:"payment_link"`
+		Payment *struct {
+			Entity razorpayEntity `json:"entity"`
+		} `json:"payment"`
+		Refund *struct {
+			Entity razorpayEntity `json:"entity"`
+		} `json:"refund"`
+	} `json:"payload"`
+}
+
+// Fields we read from payment links, payments and refunds
+type razorpayEntity struct {
+	Id               string          `json:"id"`
+	Status           string          `json:"status"`
+	Amount           int64           `json:"amount"`
+	Currency         string          `json:"currency"`
+	ReferenceId      string          `json:"reference_id"`      // Payment links
+	PaymentId        string          `json:"payment_id"`        // Refunds
+	ErrorDescription string          `json:"error_description"` // Failed payments
+	Notes            json.RawMessage `json:"notes"`             // An object, or [] when empty
+}
+
+// note returns a string note, tolerating Razorpay's empty array form
+func (e razorpayEntity) note(key string) string {
+	notes := map[string]interface{}{}
+	if err := json.Unmarshal(e.Notes, &notes); err != nil {
+		return ""
+	}
+	value, _ := notes[key].(string)
+	return value
+}
+
+// RazorpayProvider sells through Razorpay payment links
+type RazorpayProvider struct{}
+
+func (p *RazorpayProvider) Name() string {
+	return "razorpay"
+}
+
+// SupportsCurrency allows the currencies in RAZORPAY_CURRENCIES, INR unless
+// set, as international payments have to be enabled on the account
+func (p *RazorpayProvider) SupportsCurrency(currency string) bool {
+	currencies := os.Getenv("RAZORPAY_CURRENCIES")
+	if currencies == "" {
+		currencies = "INR"
+	}
+	for _, allowed := range strings.Split(currencies, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), currency) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RazorpayProvider) CreatePaymentLink(request PaymentLinkRequest) (*PaymentLink, error) {
+	data, err := CreatePaymentLinkData(false, int(request.Amount), request.Currency, false, 0, request.ExpireBy.Unix(), request.ReferenceId, request.Description, request.CustomerName, "", request.CustomerEmail, true, request.NotifyEmail, true, "Standard Policy", request.CallbackURL, "get")
+	if err != nil {
+		return nil, err
+	}
+
+	shortURL, ok := data["short_url"].(string)
+	if !ok {
+		return nil, fmt.Errorf("short_url is not a string")
+	}
+	id, ok := data["id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("id is not a string")
+	}
+
+	return &PaymentLink{Id: id, ShortURL: shortURL, Status: stringField(data, "status"), Raw: data}, nil
+}
+
+func (p *RazorpayProvider) FetchPaymentLink(linkId string) (*PaymentLinkState, error) {
+	data, err := GetPaymentLink(linkId)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &PaymentLinkState{
+		Id:          stringField(data, "id"),
+		ReferenceId: stringField(data, "reference_id"),
+		Status:      stringField(data, "status"),
+		AmountPaid:  int64Field(data, "amount_paid"),
+		Raw:         data,
+	}
+
+	// The last captured payment settles the link
+	if payments, ok := data["payments"].([]interface{}); ok {
+		for _, item := range payments {
+			if payment, ok := item.(map[string]interface{}); ok && stringField(payment, "status") == "captured" {
+				state.PaymentId = stringField(payment, "payment_id")
+			}
+		}
+	}
+
+	return state, nil
+}
+
+func (p *RazorpayProvider) CancelPaymentLink(linkId string) error {
+	_, err := CancelPaymentLink(linkId)
+	return err
+}
+
+func (p *RazorpayProvider) VerifyCallback(query url.Values) (*PaymentEvent, error) {
+	referenceId := query.Get("razorpay_payment_link_reference_id")
+	paymentLinkStatus := query.Get("razorpay_payment_link_status")
+	paymentLinkId := query.Get("razorpay_payment_link_id")
+	razorpayPaymentId := query.Get("razorpay_payment_id")
+	signature := query.Get("razorpay_signature")
+
+	// Check if all required parameters are present
+	if referenceId == "" || paymentLinkStatus == "" || paymentLinkId == "" || razorpayPaymentId == "" || signature == "" {
+		return nil, fmt.Errorf("callback is missing parameters")
+	}
+
+	params := map[string]interface{}{
+		"payment_link_id":           paymentLinkId,
+		"razorpay_payment_id":       razorpayPaymentId,
+		"payment_link_reference_id": referenceId,
+		"payment_link_status":       paymentLinkStatus,
+	}
+	if !VerifyPaymentLink(params, signature) {
+		return nil, fmt.Errorf("callback signature is invalid")
+	}
+
+	return &PaymentEvent{
+		Type:        "callback",
+		ReferenceId: referenceId,
+		LinkId:      paymentLinkId,
+		PaymentId:   razorpayPaymentId,
+		Status:      paymentLinkStatus,
+	}, nil
+}
+
+func (p *RazorpayProvider) VerifyWebhook(header http.Header, body []byte) (*PaymentEvent, error) {
+	if !VerifyWebhookSignature(body, header.Get("X-Razorpay-Signature")) {
+		return nil, fmt.Errorf("webhook signature is invalid")
+	}
+
+	var webhook razorpayWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+
+	// Razorpay sends the same event id on every retry of a delivery
+	event := &PaymentEvent{Id: header.Get("X-Razorpay-Event-Id"), Type: webhook.Event}
+	if event.Id == "" {
+		digest := sha256.Sum256(body)
+		event.Id = hex.EncodeToString(digest[:])
+	}
+
+	if webhook.Payload.PaymentLink != nil {
+		link := webhook.Payload.PaymentLink.Entity
+		event.LinkId = link.Id
+		event.ReferenceId = link.ReferenceId
+		event.Status = link.Status
+		event.Amount = link.Amount
+		event.Currency = link.Currency
+	}
+	if webhook.Payload.Payment != nil {
+		payment := webhook.Payload.Payment.Entity
+		event.PaymentId = payment.Id
+		event.Amount = payment.Amount
+		event.Currency = payment.Currency
+		event.Error = payment.ErrorDescription
+		if event.ReferenceId == "" {
+			event.ReferenceId = payment.note("reference_id")
+		}
+	}
+	if webhook.Payload.Refund != nil {
+		refund := webhook.Payload.Refund.Entity
+		event.RefundId = refund.Id
+		event.PaymentId = refund.PaymentId
+		event.Status = refund.Status
+		event.Amount = refund.Amount
+		event.Currency = refund.Currency
+	}
+
+	return event, nil
+}
+
+func (p *RazorpayProvider) Refund(paymentId string, amount int64, notes map[string]string) (*RefundResult, error) {
+	refundNotes := map[string]interface{}{}
+	for key, value := range notes {
+		refundNotes[key] = value
+	}
+
+	data, err := RefundPayment(paymentId, int(amount), refundNotes)
+	if err != nil {
+		return nil, err
+	}
+
+	refundId := stringField(data, "id")
+	if refundId == "" {
+		return nil, fmt.Errorf("refund response had no id")
+	}
+
+	return &RefundResult{Id: refundId, Status: stringField(data, "status"), Amount: int64Field(data, "amount"), Raw: data}, nil
+}
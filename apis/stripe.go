@@ -0,0 +1,298 @@
+package API
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeProvider sells through Stripe Checkout sessions, called over its REST API.
+// Checkout sessions expire after at most 24 hours, so links are capped to that.
+type StripeProvider struct {
+	apiKey        string
+	webhookSecret string
+	baseURL       string
+	client        *http.Client
+}
+
+func NewStripeProvider() *StripeProvider {
+	return &StripeProvider{
+		apiKey:        os.Getenv("STRIPE_SECRET_KEY"),
+		webhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		baseURL:       "https://api.stripe.com/v1",
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+// SupportsCurrency is true for any currency, Stripe presents the checkout in it
+func (p *StripeProvider) SupportsCurrency(currency string) bool {
+	return currency != ""
+}
+
+// call sends a form encoded request and decodes the JSON answer
+func (p *StripeProvider) call(method string, path string, form url.Values) (map[string]interface{}, error) {
+	if p.apiKey == "" {
+		return nil, fmt.Errorf("STRIPE_SECRET_KEY is not set")
+	}
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, p.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.apiKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		if stripeErr, ok := data["error"].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("stripe error %d: %s", resp.StatusCode, stringField(stripeErr, "message"))
+		}
+		return nil, fmt.Errorf("stripe error %d", resp.StatusCode)
+	}
+
+	return data, nil
+}
+
+func (p *StripeProvider) CreatePaymentLink(request PaymentLinkRequest) (*PaymentLink, error) {
+	expireBy := request.ExpireBy
+	if latest := time.Now().Add(23 * time.Hour); expireBy.IsZero() || expireBy.After(latest) {
+		expireBy = latest
+	}
+
+	// Stripe substitutes the session id into the return URL
+	returnURL := appendQuery(request.CallbackURL, "session_id={CHECKOUT_SESSION_ID}")
+
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", request.ReferenceId)
+	form.Set("customer_email", request.CustomerEmail)
+	form.Set("expires_at", strconv.FormatInt(expireBy.Unix(), 10))
+	form.Set("success_url", returnURL)
+	form.Set("cancel_url", returnURL)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(request.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(request.Amount, 10))
+	form.Set("line_items[0][price_data][product_data][name]", request.Description)
+	form.Set("metadata[reference_id]", request.ReferenceId)
+	form.Set("payment_intent_data[metadata][reference_id]", request.ReferenceId)
+
+	data, err := p.call(http.MethodPost, "/checkout/sessions", form)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentLink{Id: stringField(data, "id"), ShortURL: stringField(data, "url"), Status: LinkStatusCreated, Raw: data}, nil
+}
+
+// sessionState maps a checkout session onto the normalised link states
+func sessionState(session map[string]interface{}) *PaymentLinkState {
+	state := &PaymentLinkState{
+		Id:          stringField(session, "id"),
+		ReferenceId: stringField(session, "client_reference_id"),
+		Status:      LinkStatusCreated,
+		PaymentId:   stringField(session, "payment_intent"),
+		Raw:         session,
+	}
+
+	switch {
+	case stringField(session, "status") == "complete" && stringField(session, "payment_status") == "paid":
+		state.Status = LinkStatusPaid
+		state.AmountPaid = int64Field(session, "amount_total")
+	case stringField(session, "status") == "expired":
+		state.Status = LinkStatusExpired
+	}
+
+	return state
+}
+
+func (p *StripeProvider) FetchPaymentLink(linkId string) (*PaymentLinkState, error) {
+	data, err := p.call(http.MethodGet, "/checkout/sessions/"+url.PathEscape(linkId), nil)
+	if err != nil {
+		return nil, err
+	}
+	return sessionState(data), nil
+}
+
+// CancelPaymentLink expires the checkout session, Stripe's way of cancelling it
+func (p *StripeProvider) CancelPaymentLink(linkId string) error {
+	_, err := p.call(http.MethodPost, "/checkout/sessions/"+url.PathEscape(linkId)+"/expire", url.Values{})
+	return err
+}
+
+// VerifyCallback doesn't trust the redirect; the session is fetched from Stripe
+func (p *StripeProvider) VerifyCallback(query url.Values) (*PaymentEvent, error) {
+	sessionId := query.Get("session_id")
+	if sessionId == "" {
+		return nil, fmt.Errorf("callback is missing session_id")
+	}
+
+	state, err := p.FetchPaymentLink(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaymentEvent{
+		Type:        "callback",
+		ReferenceId: state.ReferenceId,
+		LinkId:      state.Id,
+		PaymentId:   state.PaymentId,
+		Status:      state.Status,
+		Amount:      state.AmountPaid,
+	}, nil
+}
+
+// verifySignature checks the Stripe-Signature header: HMAC-SHA256 of "timestamp.body"
+func (p *StripeProvider) verifySignature(signatureHeader string, body []byte) error {
+	if p.webhookSecret == "" {
+		return fmt.Errorf("STRIPE_WEBHOOK_SECRET is not set")
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signatureHeader, ",") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("webhook signature has no timestamp")
+	}
+	if time.Since(time.Unix(seconds, 0)) > 5*time.Minute {
+		return fmt.Errorf("webhook signature is too old")
+	}
+
+	mac := hmac.New(sha256.New, []byte(p.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook signature is invalid")
+}
+
+func (p *StripeProvider) VerifyWebhook(header http.Header, body []byte) (*PaymentEvent, error) {
+	if err := p.verifySignature(header.Get("Stripe-Signature"), body); err != nil {
+		return nil, err
+	}
+
+	var webhook struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object map[string]interface{} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, err
+	}
+
+	object := webhook.Data.Object
+	event := &PaymentEvent{Id: webhook.Id, Type: webhook.Type, Currency: strings.ToUpper(stringField(object, "currency"))}
+
+	metadataReference := ""
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		metadataReference = stringField(metadata, "reference_id")
+	}
+
+	switch webhook.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		state := sessionState(object)
+		if state.Status != LinkStatusPaid {
+			// Delayed payment methods complete the session before the money arrives
+			event.Type = webhook.Type
+			break
+		}
+		event.Type = EventPaymentLinkPaid
+		event.ReferenceId = state.ReferenceId
+		event.LinkId = state.Id
+		event.PaymentId = state.PaymentId
+		event.Status = state.Status
+		event.Amount = state.AmountPaid
+
+	case "checkout.session.expired":
+		event.Type = EventPaymentLinkExpired
+		event.ReferenceId = stringField(object, "client_reference_id")
+		event.LinkId = stringField(object, "id")
+		event.Status = LinkStatusExpired
+
+	case "payment_intent.payment_failed":
+		event.Type = EventPaymentFailed
+		event.ReferenceId = metadataReference
+		event.PaymentId = stringField(object, "id")
+		event.Amount = int64Field(object, "amount")
+		if lastError, ok := object["last_payment_error"].(map[string]interface{}); ok {
+			event.Error = stringField(lastError, "message")
+		}
+
+	case "refund.created", "refund.updated":
+		if stringField(object, "status") != "succeeded" {
+			break
+		}
+		event.Type = EventRefundProcessed
+		event.RefundId = stringField(object, "id")
+		event.PaymentId = stringField(object, "payment_intent")
+		event.Status = "processed"
+		event.Amount = int64Field(object, "amount")
+	}
+
+	return event, nil
+}
+
+func (p *StripeProvider) Refund(paymentId string, amount int64, notes map[string]string) (*RefundResult, error) {
+	form := url.Values{}
+	form.Set("payment_intent", paymentId)
+	form.Set("amount", strconv.FormatInt(amount, 10))
+	for key, value := range notes {
+		form.Set("metadata["+key+"]", value)
+	}
+
+	data, err := p.call(http.MethodPost, "/refunds", form)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{Id: stringField(data, "id"), Status: stringField(data, "status"), Amount: int64Field(data, "amount"), Raw: data}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"myproject/controller"
+	"myproject/models"
+	"os"
+)
+
+// runCommand handles one-off maintenance commands instead of starting the server
+func runCommand(args []string) {
+	switch args[0] {
+	case "export":
+		// export <email> <output.zip>
+		if len(args) != 3 {
+			log.Fatal("usage: export <email> <output.zip>")
+		}
+
+		user, err := models.FetchUserByEmail(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		export, err := controller.BuildUserExport(user.ID)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		file, err := os.Create(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		if err := controller.WriteUserExportZip(file, export); err != nil {
+			log.Fatal(err)
+		}
+
+		models.RecordAudit("cli", "DATA_EXPORTED", user.ID.Hex(), map[string]interface{}{"format": "zip"})
+		fmt.Println("::Export written to " + args[2])
+
+	case "erase-due":
+		// Carry out erasure requests whose grace period is over
+		if err := controller.ProcessDueErasureRequests(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("::Due erasure requests processed")
+
+	default:
+		log.Fatalf("unknown command %s", args[0])
+	}
+}
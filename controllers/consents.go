@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ConsentContext is where and how a set of consents was given
+type ConsentContext struct {
+	Source    string
+	IpAddress string
+	UserAgent string
+}
+
+// ValidateConsents matches accepted versions against the active documents.
+// Every required document must be accepted at its current version; optional
+// ones are recorded only when accepted at the current version.
+func ValidateConsents(accepted []response.ConsentAcceptance) ([]models.ConsentDocument, *MyError) {
+	documents, err := models.FetchActiveConsentDocuments()
+	if err != nil {
+		return nil, &MyError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to fetch consent documents",
+		}
+	}
+
+	acceptedVersions := map[string]string{}
+	for _, acceptance := range accepted {
+		acceptedVersions[acceptance.Type] = acceptance.Version
+	}
+
+	var matched []models.ConsentDocument
+	for _, document := range documents {
+		version, ok := acceptedVersions[document.Type]
+		if ok && version == document.Version {
+			matched = append(matched, document)
+			continue
+		}
+		if document.Required {
+			return nil, &MyError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Consent to %s version %s is required", document.Type, document.Version),
+			}
+		}
+	}
+
+	return matched, nil
+}
+
+// RecordConsents stores one consent per accepted document
+func RecordConsents(userId primitive.ObjectID, testId primitive.ObjectID, documents []models.ConsentDocument, consentContext ConsentContext) error {
+	for _, document := range documents {
+		consent := models.NewConsent(userId, testId, document.Type, document.Version, consentContext.Source, consentContext.IpAddress, consentContext.UserAgent)
+		if err := mgm.Coll(consent).Create(consent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
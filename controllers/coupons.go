@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"myproject/models"
+	"net/http"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CouponQuote is a coupon reserved for one submission
+type CouponQuote struct {
+	Coupon         *models.Coupon
+	OriginalAmount int64
+	Discount       int64
+	FinalAmount    int64
+}
+
+// RedeemCoupon validates a coupon for the user and test and reserves one
+// redemption. Release the reservation with ReleaseCoupon if the submission
+// fails before RecordCouponRedemption.
+func RedeemCoupon(code string, userId primitive.ObjectID, testName string, price int64, currency string) (*CouponQuote, *MyError) {
+	coupon, err := models.FetchCouponByCode(code)
+	if err != nil || !coupon.Active {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid coupon code"}
+	}
+
+	now := time.Now()
+	if now.Before(coupon.ValidFrom) || (!coupon.ValidUntil.IsZero() && now.After(coupon.ValidUntil)) {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Coupon is not valid at this time"}
+	}
+
+	if !coupon.AppliesTo(testName) {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Coupon does not apply to this test"}
+	}
+
+	if !coupon.AppliesToCurrency(currency) {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Coupon can't be used for payments in " + currency}
+	}
+
+	if coupon.PerUserLimit > 0 {
+		used, err := models.CountUserCouponRedemptions(coupon.ID, userId)
+		if err != nil {
+			return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to check coupon usage"}
+		}
+		if used >= coupon.PerUserLimit {
+			return nil, &MyError{Code: http.StatusBadRequest, Message: "You have already used this coupon"}
+		}
+	}
+
+	if err := models.ReserveCouponRedemption(coupon.ID); err != nil {
+		if errors.Is(err, models.ErrCouponExhausted) {
+			return nil, &MyError{Code: http.StatusBadRequest, Message: "Coupon has been fully redeemed"}
+		}
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to redeem coupon"}
+	}
+
+	discount := coupon.Discount(price)
+	return &CouponQuote{
+		Coupon:         coupon,
+		OriginalAmount: price,
+		Discount:       discount,
+		FinalAmount:    price - discount,
+	}, nil
+}
+
+// ReleaseCoupon returns the slot reserved by RedeemCoupon
+func ReleaseCoupon(quote *CouponQuote) {
+	if quote == nil {
+		return
+	}
+	if err := models.ReleaseCouponRedemption(quote.Coupon.ID); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
+
+// RecordCouponRedemption stores the redemption once the test exists
+func RecordCouponRedemption(quote *CouponQuote, userId primitive.ObjectID, testId primitive.ObjectID, testName string, currency string) error {
+	if quote == nil {
+		return nil
+	}
+	redemption := models.NewCouponRedemption(quote.Coupon, userId, testId, testName, quote.OriginalAmount, quote.Discount, currency)
+	return mgm.Coll(redemption).Create(redemption)
+}
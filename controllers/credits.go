@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	apis "myproject/apis"
+	"myproject/models"
+	"net/http"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultCreditValidityDays is how long credits last when a bundle or grant doesn't say
+const DefaultCreditValidityDays = 365
+
+// UserWallet is the credits wallet of a user
+func UserWallet(userId primitive.ObjectID) models.CreditOwner {
+	return models.CreditOwner{Type: models.CreditOwnerUser, Id: userId}
+}
+
+// OrganizationWallet is the credits wallet of an organization
+func OrganizationWallet(organizationId primitive.ObjectID) models.CreditOwner {
+	return models.CreditOwner{Type: models.CreditOwnerOrganization, Id: organizationId}
+}
+
+// PurchaseCredits opens a payment link for a bundle. The credits are added
+// to the owner's wallet by CompleteCreditPurchase once the link is paid.
+func PurchaseCredits(owner models.CreditOwner, buyerId primitive.ObjectID, name string, email string, sku string, currency string, country string) (*models.CreditPurchase, *MyError) {
+	product, err := models.FetchProductBySku(sku)
+	if err != nil || !product.Active || !product.IsBundle() {
+		return nil, &MyError{Code: http.StatusNotFound, Message: "No credit bundle with sku " + sku}
+	}
+
+	currency, amount, err := ChooseCurrency(product, currency, country)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	validityDays := product.CreditValidityDays
+	if validityDays == 0 {
+		validityDays = DefaultCreditValidityDays
+	}
+
+	purchase := models.NewCreditPurchase(owner, buyerId, product.Sku, product.TestName, product.Credits, validityDays, amount, currency)
+	if err := mgm.Coll(purchase).Create(purchase); err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to create purchase"}
+	}
+
+	description := product.Description
+	if description == "" {
+		description = product.Name
+	}
+
+	provider, link, err := GeneratePaymentLink(amount, currency, description, name, email, ReferenceKindCredits+"_"+purchase.ID.Hex())
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to generated payment link"}
+	}
+
+	payload, _ := json.Marshal(link.Raw)
+	payment := models.NewPayment(buyerId, primitive.NilObjectID, provider.Name(), link.Id, amount, currency, string(payload))
+	payment.CreditPurchaseId = purchase.ID
+	if err := mgm.Coll(payment).Create(payment); err != nil {
+		// The link is live already, so the ledger gap is logged rather than failing the purchase
+		log.Println(":: Error : failed to record payment for link " + link.Id + ": " + err.Error())
+	}
+
+	if err := models.UpdateCreditPurchaseLink(purchase.ID, link.ShortURL, link.Id); err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	purchase.PaymentLink = link.ShortURL
+	purchase.ExternalPaymentId = link.Id
+
+	return purchase, nil
+}
+
+// CompleteCreditPurchase adds a paid bundle's credits to its wallet. The
+// callback and the webhook both end up here; the credits are added once.
+func CompleteCreditPurchase(purchaseId primitive.ObjectID, providerPaymentId string) error {
+	purchase, err := models.FetchCreditPurchaseById(purchaseId)
+	if err != nil {
+		return err
+	}
+	if purchase.Status == models.CreditPurchasePaid {
+		return nil
+	}
+
+	lot := models.NewCreditLot(purchase.Owner, purchase.TestName, purchase.Credits, time.Now().AddDate(0, 0, purchase.ValidityDays), models.CreditSourcePurchase)
+	lot.PurchaseId = purchase.ID
+	lot.Note = "Bundle " + purchase.Sku
+
+	lot, added, err := models.AddPurchasedCreditLot(lot)
+	if err != nil {
+		return err
+	}
+
+	if err := models.MarkCreditPurchasePaid(purchase.ID, providerPaymentId, lot.ID); err != nil {
+		return err
+	}
+
+	if added {
+		models.RecordAudit("payment", "CREDITS_PURCHASED", purchase.Owner.Id.Hex(), map[string]interface{}{
+			"purchaseId": purchase.ID.Hex(),
+			"sku":        purchase.Sku,
+			"credits":    purchase.Credits,
+			"expiresAt":  lot.ExpiresAt,
+		})
+	}
+
+	return nil
+}
+
+// handleCreditPurchaseEvent applies a webhook event for a bundle payment link
+func handleCreditPurchaseEvent(purchaseId primitive.ObjectID, event apis.PaymentEvent, payload string) *MyError {
+	purchase, err := models.FetchCreditPurchaseById(purchaseId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil
+	}
+
+	switch event.Type {
+	case apis.EventPaymentLinkPaid:
+		if err := CompleteCreditPurchase(purchaseId, event.PaymentId); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to add purchased credits"}
+		}
+		RecordPaymentEvent(purchase.ExternalPaymentId, event.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+			Source:            "webhook",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Payload:           payload,
+		})
+
+	case apis.EventPaymentLinkExpired:
+		if err := models.ExpireCreditPurchase(purchaseId, event.LinkId); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to update purchase"}
+		}
+		RecordPaymentEvent(event.LinkId, "", models.LedgerStatusExpired, models.PaymentEvent{
+			Source:  "webhook",
+			Payload: payload,
+		})
+
+	case apis.EventPaymentFailed:
+		// History only, the link can still be paid
+		RecordPaymentEvent(purchase.ExternalPaymentId, event.PaymentId, "", models.PaymentEvent{
+			Status:            models.LedgerStatusFailed,
+			Source:            "webhook",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Error:             event.Error,
+			Payload:           payload,
+		})
+	}
+
+	return nil
+}
+
+// RestoreTestCredit gives back the credit taken for a test that could not be
+// created. It is a no-op without a lot, like ReleaseCoupon.
+func RestoreTestCredit(lot *models.CreditLot, testId primitive.ObjectID, reason string) {
+	if lot == nil {
+		return
+	}
+	if err := models.RestoreCredit(lot.ID, testId, "system", reason); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
+
+// GrantCredits adds credits to a wallet without payment, e.g. for a pilot or a goodwill gesture
+func GrantCredits(owner models.CreditOwner, testName string, credits int, validityDays int, note string, actor string) (*models.CreditLot, *MyError) {
+	if credits <= 0 {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Credits must be positive"}
+	}
+	if validityDays <= 0 {
+		validityDays = DefaultCreditValidityDays
+	}
+
+	switch owner.Type {
+	case models.CreditOwnerUser:
+		if user := models.FetchUserUsingId(owner.Id); user.ID.IsZero() {
+			return nil, &MyError{Code: http.StatusNotFound, Message: "user with ID " + owner.Id.Hex() + " not found"}
+		}
+	case models.CreditOwnerOrganization:
+		if _, err := models.FetchOrganizationById(owner.Id); err != nil {
+			return nil, &MyError{Code: http.StatusNotFound, Message: err.Error()}
+		}
+	default:
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Owner type must be USER or ORGANIZATION"}
+	}
+
+	lot := models.NewCreditLot(owner, testName, credits, time.Now().AddDate(0, 0, validityDays), models.CreditSourceGrant)
+	lot.Note = note
+	if err := models.GrantCreditLot(lot, actor); err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to grant credits"}
+	}
+
+	models.RecordAudit(actor, "CREDITS_GRANTED", owner.Id.Hex(), map[string]interface{}{
+		"ownerType": owner.Type,
+		"lotId":     lot.ID.Hex(),
+		"testName":  testName,
+		"credits":   credits,
+		"expiresAt": lot.ExpiresAt,
+		"note":      note,
+	})
+
+	return lot, nil
+}
+
+// ExpireCredits zeroes lapsed credit lots; run as a background job
+func ExpireCredits() error {
+	expired, err := models.ExpireCreditLots()
+	if expired > 0 {
+		log.Printf(":: Credits : %d credits expired", expired)
+	}
+	return err
+}
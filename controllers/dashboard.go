@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"myproject/models"
+	"os"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lifecycle states shown on the user dashboard
+const (
+	TestStatusAwaitingGuardian = "AWAITING_GUARDIAN_CONSENT"
+	TestStatusGuardianDeclined = "GUARDIAN_DECLINED"
+	TestStatusAwaitingPayment  = "AWAITING_PAYMENT"
+	TestStatusPaymentExpired   = "PAYMENT_EXPIRED"
+	TestStatusProcessing       = "PROCESSING"
+	TestStatusReportReady      = "REPORT_READY"
+	TestStatusRefunded         = "REFUNDED"
+	TestStatusReportRevoked    = "REPORT_REVOKED"
+)
+
+type TestSummary struct {
+	TestId          string    `json:"testId"`
+	TestName        string    `json:"testName"`
+	TestGiver       string    `json:"testGiver"`
+	Status          string    `json:"status"`
+	PaymentStatus   string    `json:"paymentStatus"`
+	PaymentLink     string    `json:"paymentLink,omitempty"` // Only while the link can still be paid
+	ReportAvailable bool      `json:"reportAvailable"`       // The full report, with its narrative
+	ReportTier      string    `json:"reportTier,omitempty"`
+	ReportLink      string    `json:"reportLink,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// isAwaitingPayment reports whether the test still needs a payment before a report is generated
+func isAwaitingPayment(test models.Test) bool {
+	switch test.PaymentStatus {
+	case models.PaymentStatusPaid, models.PaymentStatusBypass, models.PaymentStatusGranted, models.PaymentStatusPartnerBilled, models.PaymentStatusPartRefunded, models.PaymentStatusCouponFree, models.PaymentStatusCredits, models.PaymentStatusVoucher, models.PaymentStatusGift:
+		return false
+	}
+	return true
+}
+
+// SummarizeTest derives the dashboard view of a test
+func SummarizeTest(test models.Test, reportAvailable bool) TestSummary {
+	summary := TestSummary{
+		TestId:          test.ID.Hex(),
+		TestName:        test.TestName,
+		TestGiver:       test.TestGiver,
+		PaymentStatus:   test.PaymentStatus,
+		ReportAvailable: reportAvailable,
+		CreatedAt:       test.CreatedAt,
+	}
+
+	reportLink := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + test.ID.Hex()
+
+	switch {
+	case test.ReportRevoked:
+		summary.Status = TestStatusReportRevoked
+		summary.ReportAvailable = false
+	case reportAvailable:
+		summary.Status = TestStatusReportReady
+		summary.ReportTier = ReportAccessFull
+		summary.ReportLink = reportLink
+	case test.PaymentStatus == models.PaymentStatusRefunded:
+		summary.Status = TestStatusRefunded
+	case !isAwaitingPayment(test):
+		summary.Status = TestStatusProcessing
+	case test.PaymentStatus == models.PaymentStatusGuardianHold:
+		summary.Status = TestStatusAwaitingGuardian
+	case test.PaymentStatus == models.PaymentStatusDeclined:
+		summary.Status = TestStatusGuardianDeclined
+	case test.PaymentLink != "" && test.PaymentStatus == models.PaymentStatusPending && time.Since(test.PaymentLinkIssuedAt()) < PaymentLinkValidity:
+		summary.Status = TestStatusAwaitingPayment
+		summary.PaymentLink = test.PaymentLink
+		summary.ReportTier = ReportAccessSummary
+		summary.ReportLink = reportLink
+	default:
+		// The free summary stays readable, and can still be upgraded
+		summary.Status = TestStatusPaymentExpired
+		summary.ReportTier = ReportAccessSummary
+		summary.ReportLink = reportLink
+	}
+
+	return summary
+}
+
+// ListUserTests returns one page of a user's tests, newest first, and the total count
+func ListUserTests(userId primitive.ObjectID, testName string, page int, limit int) ([]TestSummary, int64, error) {
+	filter := bson.M{"userId": userId}
+	if testName != "" {
+		filter["testName"] = testName
+	}
+
+	total, err := mgm.Coll(&models.Test{}).CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var tests []models.Test
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	if err := mgm.Coll(&models.Test{}).SimpleFind(&tests, filter, findOptions); err != nil {
+		return nil, 0, err
+	}
+
+	testIds := []primitive.ObjectID{}
+	for _, test := range tests {
+		testIds = append(testIds, test.ID)
+	}
+
+	// One query for report availability of the whole page. Domain scores
+	// exist for the free summary too, so the narrative is what counts.
+	reported := map[primitive.ObjectID]bool{}
+	if len(testIds) > 0 {
+		reportedIds, err := mgm.Coll(&models.FinalReport{}).Distinct(context.TODO(), "testId", bson.M{"testId": bson.M{"$in": testIds}})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, id := range reportedIds {
+			if oid, ok := id.(primitive.ObjectID); ok {
+				reported[oid] = true
+			}
+		}
+	}
+
+	summaries := []TestSummary{}
+	for _, test := range tests {
+		summaries = append(summaries, SummarizeTest(test, reported[test.ID]))
+	}
+
+	return summaries, total, nil
+}
@@ -0,0 +1,268 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	apis "myproject/apis"
+	"myproject/libs"
+	"myproject/models"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GiftValidity is how long a recipient has to take a gifted test once it is paid
+const GiftValidity = 365 * 24 * time.Hour
+
+// GiftInvitationLink is the link emailed to a gift's recipient. Its signed
+// token pre-authorises payment when they submit the test.
+func GiftInvitationLink(gift *models.Gift) (string, error) {
+	token, err := libs.SignPurposeToken("gift_invitation", map[string]interface{}{
+		"giftId": gift.ID.Hex(),
+	}, time.Until(gift.ExpiresAt))
+	if err != nil {
+		return "", err
+	}
+	return os.Getenv("WEBAPP_DOMAIN") + "/gift?token=" + url.QueryEscape(token), nil
+}
+
+// ParseGiftToken returns the gift carried by an invitation link
+func ParseGiftToken(token string) (primitive.ObjectID, error) {
+	claims, err := libs.ParsePurposeToken("gift_invitation", token)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	giftId, _ := claims["giftId"].(string)
+	return primitive.ObjectIDFromHex(giftId)
+}
+
+// PurchaseGift opens a payment link for a test the buyer gives to someone else.
+// The recipient is invited by CompleteGiftPurchase once the link is paid.
+func PurchaseGift(buyer models.User, recipientName string, recipientEmail string, message string, tier string, currency string, country string) (*models.Gift, *MyError) {
+	recipientEmail = strings.ToLower(strings.TrimSpace(recipientEmail))
+	if strings.EqualFold(recipientEmail, buyer.Email) {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "A gift must be for someone else"}
+	}
+
+	testName := "BIG_5"
+	quote, err := QuoteTest(testName, tier, currency, country)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "This report is not available for purchase"}
+	}
+
+	gift := models.NewGift(buyer.ID, recipientName, recipientEmail, message, quote.Sku, testName, quote.Amount, quote.Currency)
+	if err := mgm.Coll(gift).Create(gift); err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to create gift"}
+	}
+
+	description := quote.Description
+	if description == "" {
+		description = "BIG 5 Personality Report"
+	}
+
+	provider, link, err := GeneratePaymentLink(quote.Amount, quote.Currency, description+" (gift)", buyer.Name, buyer.Email, ReferenceKindGift+"_"+gift.ID.Hex())
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to generated payment link"}
+	}
+
+	payload, _ := json.Marshal(link.Raw)
+	payment := models.NewPayment(buyer.ID, primitive.NilObjectID, provider.Name(), link.Id, quote.Amount, quote.Currency, string(payload))
+	payment.GiftId = gift.ID
+	if err := mgm.Coll(payment).Create(payment); err != nil {
+		// The link is live already, so the ledger gap is logged rather than failing the purchase
+		log.Println(":: Error : failed to record payment for link " + link.Id + ": " + err.Error())
+	}
+
+	if err := models.UpdateGiftPaymentLink(gift.ID, link.ShortURL, link.Id); err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	gift.PaymentLink = link.ShortURL
+	gift.ExternalPaymentId = link.Id
+
+	return gift, nil
+}
+
+// CompleteGiftPurchase settles a paid gift and invites its recipient. The
+// callback and the webhook both end up here; the invitation is sent once.
+func CompleteGiftPurchase(giftId primitive.ObjectID, providerPaymentId string) error {
+	gift, changed, err := models.MarkGiftPaid(giftId, providerPaymentId, time.Now().Add(GiftValidity))
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	buyer := models.FetchUserUsingId(gift.BuyerId)
+	models.RecordAudit(gift.BuyerId.Hex(), "GIFT_PURCHASED", gift.ID.Hex(), map[string]interface{}{
+		"sku":       gift.Sku,
+		"amount":    gift.Amount,
+		"currency":  gift.Currency,
+		"expiresAt": gift.ExpiresAt,
+	})
+
+	link, err := GiftInvitationLink(gift)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := apis.SendGiftInvitation(gift.RecipientEmail, gift.RecipientName, buyer.Name, gift.Message, link); err != nil {
+			log.Println(":: Error : failed to send gift invitation: " + err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// handleGiftPurchaseEvent applies a webhook event for a gift payment link
+func handleGiftPurchaseEvent(giftId primitive.ObjectID, event apis.PaymentEvent, payload string) *MyError {
+	gift, err := models.FetchGiftById(giftId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil
+	}
+
+	switch event.Type {
+	case apis.EventPaymentLinkPaid:
+		if err := CompleteGiftPurchase(giftId, event.PaymentId); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to complete gift"}
+		}
+		RecordPaymentEvent(gift.ExternalPaymentId, event.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+			Source:            "webhook",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Payload:           payload,
+		})
+
+	case apis.EventPaymentLinkExpired:
+		if err := models.ExpireGiftPurchase(giftId, event.LinkId); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to update gift"}
+		}
+		RecordPaymentEvent(event.LinkId, "", models.LedgerStatusExpired, models.PaymentEvent{
+			Source:  "webhook",
+			Payload: payload,
+		})
+
+	case apis.EventPaymentFailed:
+		// History only, the link can still be paid
+		RecordPaymentEvent(gift.ExternalPaymentId, event.PaymentId, "", models.PaymentEvent{
+			Status:            models.LedgerStatusFailed,
+			Source:            "webhook",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Error:             event.Error,
+			Payload:           payload,
+		})
+	}
+
+	return nil
+}
+
+// RedeemGift checks an invitation token and ties the gift to the recipient's
+// test. Release it with ReleaseGift if the submission fails afterwards.
+func RedeemGift(token string, testName string, userId primitive.ObjectID, testId primitive.ObjectID) (*models.Gift, *MyError) {
+	giftId, err := ParseGiftToken(token)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid or expired gift link"}
+	}
+
+	gift, err := models.FetchGiftById(giftId)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid or expired gift link"}
+	}
+	if gift.TestName != testName {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Gift does not apply to this test"}
+	}
+	if gift.BuyerId == userId {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "A gift must be used by its recipient"}
+	}
+
+	redeemed, err := models.RedeemGift(giftId, userId, testId)
+	if err != nil {
+		if errors.Is(err, models.ErrGiftUnavailable) {
+			return nil, &MyError{Code: http.StatusBadRequest, Message: "This gift has already been used or is no longer available"}
+		}
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to redeem gift"}
+	}
+
+	return redeemed, nil
+}
+
+// ReleaseGift gives back a gift redeemed by a submission that failed
+func ReleaseGift(gift *models.Gift, testId primitive.ObjectID) {
+	if gift == nil {
+		return
+	}
+	if err := models.ReleaseGift(gift.ID, testId); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
+
+// NotifyGiftBuyer tells the buyer once that the gifted report is ready,
+// without a link to it
+func NotifyGiftBuyer(test models.Test) {
+	if test.GiftId.IsZero() {
+		return
+	}
+
+	first, err := models.ClaimGiftBuyerNotification(test.GiftId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return
+	}
+	if !first {
+		return
+	}
+
+	gift, err := models.FetchGiftById(test.GiftId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return
+	}
+	buyer := models.FetchUserUsingId(gift.BuyerId)
+	if buyer.Email == "" {
+		return
+	}
+	if err := apis.SendGiftCompleted(buyer.Email, buyer.Name, gift.RecipientName); err != nil {
+		log.Println(":: Error : failed to notify gift buyer: " + err.Error())
+	}
+}
+
+// ShareGiftReport is the recipient's choice to show their report to the
+// person who gifted it
+func ShareGiftReport(testId primitive.ObjectID, userId primitive.ObjectID) *MyError {
+	gift, changed, err := models.ShareGiftReport(testId, userId)
+	if err != nil {
+		return &MyError{Code: http.StatusNotFound, Message: "This test was not a gift"}
+	}
+	if !changed {
+		return nil
+	}
+
+	models.RecordAudit(userId.Hex(), "GIFT_REPORT_SHARED", testId.Hex(), map[string]interface{}{"giftId": gift.ID.Hex()})
+
+	buyer := models.FetchUserUsingId(gift.BuyerId)
+	if buyer.Email != "" {
+		link := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + testId.Hex()
+		go func() {
+			if err := apis.SendGiftReportShared(buyer.Email, buyer.Name, gift.RecipientName, link); err != nil {
+				log.Println(":: Error : failed to send shared report: " + err.Error())
+			}
+		}()
+	}
+
+	return nil
+}
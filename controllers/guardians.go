@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"fmt"
+	apis "myproject/apis"
+	"myproject/libs"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GuardianConsentAge is the age below which a guardian must approve the test
+func GuardianConsentAge() int {
+	age, err := strconv.Atoi(os.Getenv("GUARDIAN_CONSENT_AGE"))
+	if err != nil || age <= 0 {
+		age = 18
+	}
+	return age
+}
+
+// RequiresGuardianConsent reports whether the stated age is below the consent
+// age. A missing age, 0, can't show the test taker is an adult, so it needs
+// consent too.
+func RequiresGuardianConsent(age int) bool {
+	return age < GuardianConsentAge()
+}
+
+// RequestGuardianConsent stores the guardian's details and emails them approve and decline links
+func RequestGuardianConsent(test models.Test, guardian response.Guardian, paymentRequired bool) error {
+	consent := models.NewGuardianConsent(test.ID, test.UserId, guardian.Name, guardian.Email, guardian.Relationship, paymentRequired)
+	if err := mgm.Coll(consent).Create(consent); err != nil {
+		return err
+	}
+
+	token, err := libs.SignPurposeToken("guardian_consent", map[string]interface{}{
+		"consentId": consent.ID.Hex(),
+	}, PaymentLinkValidity)
+	if err != nil {
+		return err
+	}
+
+	baseURL := os.Getenv("BACKEND_API_DOMAIN") + "/guardian/consent/"
+	approveLink := baseURL + "approve?token=" + url.QueryEscape(token)
+	declineLink := baseURL + "decline?token=" + url.QueryEscape(token)
+
+	go apis.SendGuardianConsentRequest(guardian.Email, guardian.Name, test.TestGiver, approveLink, declineLink)
+
+	return nil
+}
+
+// ParseGuardianConsentToken returns the consent id carried by an emailed link
+func ParseGuardianConsentToken(token string) (primitive.ObjectID, error) {
+	claims, err := libs.ParsePurposeToken("guardian_consent", token)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	consentId, _ := claims["consentId"].(string)
+	return primitive.ObjectIDFromHex(consentId)
+}
+
+// ApproveGuardianConsent releases a held test. The payment link is created in
+// the guardian's name and returned so they can be sent straight to it; tests
+// that need no payment go to report generation and the report link is returned.
+// The consent is only marked approved once the link exists, so a failing
+// payment provider leaves it pending and the guardian can simply retry.
+func ApproveGuardianConsent(c *gin.Context, consentId primitive.ObjectID) (string, *MyError) {
+	pending, err := models.FetchGuardianConsentById(consentId)
+	if err != nil {
+		return "", &MyError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if pending.Status != "PENDING" {
+		return "", &MyError{Code: http.StatusConflict, Message: "guardian consent was already decided"}
+	}
+
+	var shortURL string
+	if pending.PaymentRequired {
+		heldTest, err := models.FetchTestById(pending.TestId)
+		if err != nil {
+			return "", &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+		}
+
+		// The price was fixed at submission, including any coupon
+		quote, err := QuoteForTest(*heldTest)
+		if err != nil {
+			return "", &MyError{Code: http.StatusInternalServerError, Message: "Failed to generated payment link"}
+		}
+
+		var linkId string
+		shortURL, linkId, err = CreateTestPaymentLink(pending.TestId, pending.UserId, pending.GuardianName, pending.GuardianEmail, *quote)
+		if err != nil {
+			return "", &MyError{Code: http.StatusInternalServerError, Message: "Failed to generated payment link"}
+		}
+		if _, err := models.UpdateTestPaymentLink(pending.TestId, shortURL, linkId); err != nil {
+			return "", &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+		}
+	}
+
+	consent, err := models.DecideGuardianConsent(consentId, "APPROVED", c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		return "", &MyError{Code: http.StatusConflict, Message: err.Error()}
+	}
+
+	models.RecordAudit(consent.GuardianEmail, "GUARDIAN_CONSENT_APPROVED", consent.TestId.Hex(), map[string]interface{}{
+		"consentId": consent.ID.Hex(),
+		"ipAddress": consent.IpAddress,
+	})
+
+	if consent.PaymentRequired {
+		return shortURL, nil
+	}
+
+	// Nothing to pay, by a gift, a payment grant, a coupon covering the price,
+	// a prepaid credit, a voucher or a partner contract. Tests held under the
+	// old pMode pass have none.
+	status := models.PaymentStatusBypass
+	if heldTest, err := models.FetchTestById(consent.TestId); err == nil {
+		if !heldTest.GiftId.IsZero() {
+			status = models.PaymentStatusGift
+		} else if !heldTest.GrantId.IsZero() {
+			status = models.PaymentStatusGranted
+		} else if heldTest.VoucherCode != "" {
+			status = models.PaymentStatusVoucher
+		} else if !heldTest.CreditLotId.IsZero() {
+			status = models.PaymentStatusCredits
+		} else if heldTest.CouponCode != "" {
+			status = models.PaymentStatusCouponFree
+		} else if !heldTest.OrganizationId.IsZero() {
+			status = models.PaymentStatusPartnerBilled
+		}
+	}
+
+	test, err := models.UpdateTestPaymentStatus(consent.TestId, status)
+	if err != nil {
+		return "", &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	user := models.FetchUserUsingId(test.UserId)
+	go GenerateNewReport(c, *test, user)
+
+	return os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + test.ID.Hex(), nil
+}
+
+// DeclineGuardianConsent closes a held test without generating a report
+func DeclineGuardianConsent(c *gin.Context, consentId primitive.ObjectID) *MyError {
+	consent, err := models.DecideGuardianConsent(consentId, "DECLINED", c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		return &MyError{Code: http.StatusConflict, Message: err.Error()}
+	}
+
+	if _, err := models.UpdateTestPaymentStatus(consent.TestId, models.PaymentStatusDeclined); err != nil {
+		return &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	models.RecordAudit(consent.GuardianEmail, "GUARDIAN_CONSENT_DECLINED", consent.TestId.Hex(), map[string]interface{}{
+		"consentId": consent.ID.Hex(),
+		"ipAddress": consent.IpAddress,
+	})
+
+	return nil
+}
+
+// guardianEmailFor returns the approving guardian's address for a test, if any
+func guardianEmailFor(testId primitive.ObjectID) (string, string, error) {
+	consent, err := models.FetchGuardianConsentByTestId(testId)
+	if err != nil {
+		return "", "", err
+	}
+	if consent.Status != "APPROVED" {
+		return "", "", fmt.Errorf("guardian consent is %s", consent.Status)
+	}
+	return consent.GuardianEmail, consent.GuardianName, nil
+}
@@ -0,0 +1,22 @@
+package controller
+
+import "testing"
+
+func TestRequiresGuardianConsent(t *testing.T) {
+	t.Setenv("GUARDIAN_CONSENT_AGE", "18")
+
+	cases := map[int]bool{
+		-1: true,
+		0:  true, // Not given
+		12: true,
+		17: true,
+		18: false,
+		40: false,
+	}
+
+	for age, want := range cases {
+		if got := RequiresGuardianConsent(age); got != want {
+			t.Errorf("RequiresGuardianConsent(%d) = %v, want %v", age, got, want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"log"
+	apis "myproject/apis"
+	"myproject/models"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultGstRate applies to products without a tax class
+const DefaultGstRate = 18
+
+var indiaTime = time.FixedZone("IST", 5*60*60+30*60)
+
+// sellerParty is our own registration, configured through SELLER_* env vars
+func sellerParty() models.InvoiceParty {
+	name := os.Getenv("SELLER_LEGAL_NAME")
+	if name == "" {
+		name = "Mind Sarthi"
+	}
+	return models.InvoiceParty{
+		Name:    name,
+		Address: os.Getenv("SELLER_ADDRESS"),
+		Gstin:   os.Getenv("SELLER_GSTIN"),
+		State:   os.Getenv("SELLER_STATE"),
+	}
+}
+
+// gstRate reads the percentage out of a tax class such as GST_18
+func gstRate(taxClass string) int64 {
+	if taxClass == "" {
+		return DefaultGstRate
+	}
+	rate, err := strconv.ParseInt(strings.TrimPrefix(taxClass, "GST_"), 10, 64)
+	if err != nil {
+		log.Println(":: Error : unknown tax class " + taxClass)
+		return DefaultGstRate
+	}
+	return rate
+}
+
+// financialYear is the Indian April to March year of t, e.g. 2026-27
+func financialYear(t time.Time) string {
+	t = t.In(indiaTime)
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
+
+// splitGst works out the taxable value and tax of a tax inclusive total.
+// Sales within the seller's state pay CGST and SGST halves, others pay IGST.
+func splitGst(total int64, rate int64, intraState bool) (taxable int64, cgst int64, sgst int64, igst int64) {
+	taxable = (total*100 + (100+rate)/2) / (100 + rate)
+	tax := total - taxable
+	if intraState {
+		cgst = tax / 2
+		sgst = tax - cgst
+		return
+	}
+	igst = tax
+	return
+}
+
+// IssueInvoice creates the tax invoice of a paid test. Calling it again
+// returns the invoice already issued.
+func IssueInvoice(testId primitive.ObjectID) (*models.Invoice, error) {
+	if existing, err := models.FetchInvoiceByTestId(testId); err == nil {
+		return existing, nil
+	}
+
+	test, err := models.FetchTestById(testId)
+	if err != nil {
+		return nil, err
+	}
+	user := models.FetchUserUsingId(test.UserId)
+
+	total, currency := test.Amount, test.Currency
+	var paymentId primitive.ObjectID
+	if test.ExternalPaymentId != "" {
+		if payment, err := models.FetchPaymentByLinkId(test.ExternalPaymentId); err == nil {
+			total, currency, paymentId = payment.Amount, payment.Currency, payment.ID
+		}
+	}
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("nothing was charged for test %s", testId.Hex())
+	}
+
+	description, hsnSac, rate := "BIG 5 Personality Report", os.Getenv("INVOICE_SAC"), int64(DefaultGstRate)
+	if product, err := models.FetchProductBySku(test.ProductSku); err == nil {
+		description = product.Name
+		rate = gstRate(product.TaxClass)
+		if product.HsnSac != "" {
+			hsnSac = product.HsnSac
+		}
+	}
+
+	seller := sellerParty()
+	buyer := models.InvoiceParty{Name: test.TestGiver, Email: user.Email}
+	if test.Billing != nil {
+		buyer.Name = test.Billing.Name
+		buyer.Gstin = test.Billing.Gstin
+		buyer.Address = test.Billing.Address
+		buyer.State = test.Billing.State
+	}
+	if buyer.Name == "" {
+		buyer.Name = user.Name
+	}
+
+	// Place of supply is the buyer's state, or ours when they gave none
+	placeOfSupply := buyer.State
+	if placeOfSupply == "" {
+		placeOfSupply = seller.State
+	}
+	intraState := placeOfSupply == seller.State
+
+	// Sales outside INR are exports of services and zero rated
+	if currency != DefaultCurrency {
+		rate = 0
+		intraState = false
+		placeOfSupply = "96" // Other countries
+	}
+
+	taxable, cgst, sgst, igst := splitGst(total, rate, intraState)
+
+	issuedAt := time.Now().UTC()
+	year := financialYear(issuedAt)
+	sequence, err := models.NextSequence("invoice-" + year)
+	if err != nil {
+		return nil, err
+	}
+	prefix := os.Getenv("INVOICE_PREFIX")
+	if prefix == "" {
+		prefix = "MS"
+	}
+
+	invoice := &models.Invoice{
+		Number:        fmt.Sprintf("%s/%s/%06d", prefix, year, sequence),
+		FinancialYear: year,
+		Sequence:      sequence,
+		UserId:        test.UserId,
+		TestId:        test.ID,
+		PaymentId:     paymentId,
+		Seller:        seller,
+		Buyer:         buyer,
+		PlaceOfSupply: placeOfSupply,
+		Lines: []models.InvoiceLine{
+			{Description: description, HsnSac: hsnSac, Quantity: 1, TaxableAmount: taxable, TaxRate: rate},
+		},
+		Currency:      currency,
+		TaxableAmount: taxable,
+		Cgst:          cgst,
+		Sgst:          sgst,
+		Igst:          igst,
+		Total:         total,
+		IssuedAt:      issuedAt,
+	}
+	if err := mgm.Coll(invoice).Create(invoice); err != nil {
+		return nil, err
+	}
+
+	return invoice, nil
+}
+
+// WriteInvoice renders an invoice as PDF
+func WriteInvoice(w io.Writer, invoice *models.Invoice) error {
+	document := apis.InvoiceDocument{
+		Number:        invoice.Number,
+		Date:          invoice.IssuedAt.In(indiaTime).Format("02 Jan 2006"),
+		SellerName:    invoice.Seller.Name,
+		SellerAddress: invoice.Seller.Address,
+		SellerGstin:   invoice.Seller.Gstin,
+		BuyerName:     invoice.Buyer.Name,
+		BuyerEmail:    invoice.Buyer.Email,
+		BuyerAddress:  invoice.Buyer.Address,
+		BuyerGstin:    invoice.Buyer.Gstin,
+		PlaceOfSupply: invoice.PlaceOfSupply,
+		TaxableAmount: FormatAmount(invoice.TaxableAmount, invoice.Currency),
+		Total:         FormatAmount(invoice.Total, invoice.Currency),
+	}
+	for _, line := range invoice.Lines {
+		document.Lines = append(document.Lines, apis.InvoiceDocumentLine{
+			Description:   line.Description,
+			HsnSac:        line.HsnSac,
+			Quantity:      line.Quantity,
+			TaxRate:       line.TaxRate,
+			TaxableAmount: FormatAmount(line.TaxableAmount, invoice.Currency),
+		})
+	}
+	if invoice.Igst > 0 || (invoice.Cgst == 0 && invoice.Sgst == 0) {
+		document.Igst = FormatAmount(invoice.Igst, invoice.Currency)
+	} else {
+		document.Cgst = FormatAmount(invoice.Cgst, invoice.Currency)
+		document.Sgst = FormatAmount(invoice.Sgst, invoice.Currency)
+	}
+
+	return apis.WriteInvoicePDF(w, document)
+}
+
+// IssueAndEmailInvoice issues a paid test's invoice and mails it to the buyer
+func IssueAndEmailInvoice(testId primitive.ObjectID) {
+	invoice, err := IssueInvoice(testId)
+	if err != nil {
+		log.Println(":: Error : failed to issue invoice: " + err.Error())
+		return
+	}
+	if !invoice.EmailedAt.IsZero() || invoice.Buyer.Email == "" {
+		return
+	}
+
+	file, err := os.CreateTemp("", "invoice-*.pdf")
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return
+	}
+	defer os.Remove(file.Name())
+
+	if err := WriteInvoice(file, invoice); err != nil {
+		file.Close()
+		log.Println(":: Error : failed to render invoice: " + err.Error())
+		return
+	}
+	file.Close()
+
+	if err := apis.SendInvoice(invoice.Buyer.Email, invoice.Buyer.Name, invoice.Number, file.Name()); err != nil {
+		log.Println(":: Error : failed to email invoice: " + err.Error())
+		return
+	}
+
+	if err := models.MarkInvoiceEmailed(invoice.ID); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
@@ -0,0 +1,23 @@
+package controller
+
+import (
+	"log"
+	"time"
+)
+
+// StartJob runs fn every interval in the background until the process exits
+func StartJob(name string, interval time.Duration, fn func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			startTime := time.Now()
+			if err := fn(); err != nil {
+				log.Printf(":: Job %s failed : %v", name, err)
+				continue
+			}
+			log.Printf(":: Job %s finished in %v", name, time.Since(startTime))
+		}
+	}()
+}
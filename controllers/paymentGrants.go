@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"myproject/libs"
+	"myproject/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A payment grant token is valid for DefaultPaymentGrantValidity unless the
+// admin asks otherwise, and never longer than MaxPaymentGrantValidity
+const (
+	DefaultPaymentGrantValidity = 72 * time.Hour
+	MaxPaymentGrantValidity     = 30 * 24 * time.Hour
+)
+
+// IssuePaymentGrant records a grant and returns it with its signed token.
+// The token is only handed out here.
+func IssuePaymentGrant(actor string, reason string, testName string, email string, validity time.Duration) (*models.PaymentGrant, string, *MyError) {
+	if strings.TrimSpace(reason) == "" {
+		return nil, "", &MyError{Code: http.StatusBadRequest, Message: "A reason is required"}
+	}
+	if validity <= 0 {
+		validity = DefaultPaymentGrantValidity
+	}
+	if validity > MaxPaymentGrantValidity {
+		return nil, "", &MyError{Code: http.StatusBadRequest, Message: "A grant can be valid for at most 30 days"}
+	}
+
+	grant := models.NewPaymentGrant(actor, reason, testName, strings.ToLower(strings.TrimSpace(email)), time.Now().Add(validity).UTC())
+	if err := mgm.Coll(grant).Create(grant); err != nil {
+		return nil, "", &MyError{Code: http.StatusInternalServerError, Message: "Failed to create payment grant"}
+	}
+
+	token, err := libs.SignPurposeToken("payment_grant", map[string]interface{}{
+		"grantId": grant.ID.Hex(),
+	}, validity)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, "", &MyError{Code: http.StatusInternalServerError, Message: "Failed to sign payment grant"}
+	}
+
+	models.RecordAudit(actor, "PAYMENT_GRANT_ISSUED", grant.ID.Hex(), map[string]interface{}{
+		"reason":    reason,
+		"testName":  testName,
+		"email":     grant.Email,
+		"expiresAt": grant.ExpiresAt,
+	})
+
+	return grant, token, nil
+}
+
+// UsePaymentGrant checks a grant token and spends it on the user's test.
+// Release it with ReleasePaymentGrant if the submission fails afterwards.
+func UsePaymentGrant(token string, testName string, user models.User, testId primitive.ObjectID) (*models.PaymentGrant, *MyError) {
+	claims, err := libs.ParsePurposeToken("payment_grant", token)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid or expired payment grant"}
+	}
+	grantIdHex, _ := claims["grantId"].(string)
+	grantId, err := primitive.ObjectIDFromHex(grantIdHex)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid or expired payment grant"}
+	}
+
+	grant, err := models.FetchPaymentGrantById(grantId)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid or expired payment grant"}
+	}
+	if grant.TestName != testName {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Payment grant does not apply to this test"}
+	}
+	if grant.Email != "" && !strings.EqualFold(grant.Email, user.Email) {
+		return nil, &MyError{Code: http.StatusForbidden, Message: "Payment grant was issued to someone else"}
+	}
+
+	used, err := models.UsePaymentGrant(grantId, user.ID, testId)
+	if err != nil {
+		if errors.Is(err, models.ErrPaymentGrantUnavailable) {
+			return nil, &MyError{Code: http.StatusBadRequest, Message: "Payment grant has already been used or revoked"}
+		}
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to use payment grant"}
+	}
+
+	models.RecordAudit(user.ID.Hex(), "PAYMENT_GRANT_USED", testId.Hex(), map[string]interface{}{
+		"grantId":  used.ID.Hex(),
+		"issuedBy": used.IssuedBy,
+		"reason":   used.Reason,
+	})
+
+	return used, nil
+}
+
+// ReleasePaymentGrant gives back a grant spent on a submission that failed
+func ReleasePaymentGrant(grant *models.PaymentGrant, testId primitive.ObjectID) {
+	if grant == nil {
+		return
+	}
+	if err := models.ReleasePaymentGrant(grant.ID, testId); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
+
+// RevokePaymentGrant withdraws an unused grant
+func RevokePaymentGrant(grantId primitive.ObjectID, actor string) (*models.PaymentGrant, *MyError) {
+	grant, err := models.RevokePaymentGrant(grantId, actor)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	models.RecordAudit(actor, "PAYMENT_GRANT_REVOKED", grant.ID.Hex(), map[string]interface{}{"reason": grant.Reason})
+
+	return grant, nil
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	apis "myproject/apis"
+	"myproject/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A test can get a new payment link once every PaymentLinkRegenerateCooldown,
+// and at most MaxPaymentLinkRegenerations times
+const (
+	PaymentLinkRegenerateCooldown = 10 * time.Minute
+	MaxPaymentLinkRegenerations   = 5
+)
+
+// cancelOldPaymentLink makes sure the test's current link can no longer be
+// paid before a new one is handed out. A link that turns out to be paid
+// settles the test instead, and is reported with a conflict.
+func cancelOldPaymentLink(c *gin.Context, test models.Test) *MyError {
+	if test.ExternalPaymentId == "" {
+		return nil
+	}
+
+	providerName := "razorpay" // Links created before the ledger are all Razorpay's
+	if payment, err := models.FetchPaymentByLinkId(test.ExternalPaymentId); err == nil {
+		providerName = payment.Provider
+	}
+
+	provider, err := apis.GetPaymentProvider(providerName)
+	if err != nil {
+		return &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	state, err := provider.FetchPaymentLink(test.ExternalPaymentId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return &MyError{Code: http.StatusBadGateway, Message: "Failed to check the current payment link"}
+	}
+
+	payload, _ := json.Marshal(state.Raw)
+
+	switch state.Status {
+	case apis.LinkStatusPaid:
+		if _, myErr := CompleteTestPayment(c.Copy(), test.ID, state.PaymentId); myErr != nil {
+			return myErr
+		}
+		RecordPaymentEvent(test.ExternalPaymentId, state.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+			Source:            "regenerate",
+			ProviderPaymentId: state.PaymentId,
+			Amount:            state.AmountPaid,
+			Payload:           string(payload),
+		})
+		return &MyError{Code: http.StatusConflict, Message: "This test has already been paid"}
+
+	case apis.LinkStatusCreated:
+		if err := provider.CancelPaymentLink(test.ExternalPaymentId); err != nil {
+			// Never leave two payable links for one test
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusBadGateway, Message: "Failed to cancel the current payment link"}
+		}
+		RecordPaymentEvent(test.ExternalPaymentId, "", models.LedgerStatusCancelled, models.PaymentEvent{
+			Source:  "regenerate",
+			Payload: string(payload),
+		})
+	}
+
+	return nil
+}
+
+// RegeneratePaymentLink replaces the payment link of a user's unpaid test
+// with a fresh one and emails it, so an expired or lost link doesn't mean
+// taking the test again. Returns the new link.
+func RegeneratePaymentLink(c *gin.Context, testId primitive.ObjectID, userId primitive.ObjectID) (string, *MyError) {
+	test, err := models.FetchTestById(testId)
+	if err != nil || test.UserId != userId {
+		return "", &MyError{Code: http.StatusNotFound, Message: "test with ID " + testId.Hex() + " not found"}
+	}
+
+	if !canUpgradeReport(*test) {
+		return "", &MyError{Code: http.StatusConflict, Message: "This test is not awaiting payment"}
+	}
+
+	return reissuePaymentLink(c, *test, userId.Hex())
+}
+
+// canUpgradeReport reports whether a test can still be paid for. Tests held
+// for a guardian, declined or refunded can't.
+func canUpgradeReport(test models.Test) bool {
+	switch test.PaymentStatus {
+	case models.PaymentStatusPending, models.PaymentStatusExpired, models.PaymentStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// UpgradeReport returns the payment link that unlocks the full report of a
+// test on the free summary. Anyone holding the report link can call it, so it
+// only hands back a link that can still be paid; replacing an expired or
+// cancelled one is left to the owner through RegeneratePaymentLink.
+func UpgradeReport(testId primitive.ObjectID) (string, *MyError) {
+	test, err := models.FetchTestById(testId)
+	if err != nil {
+		return "", &MyError{Code: http.StatusNotFound, Message: "test with ID " + testId.Hex() + " not found"}
+	}
+	if ReportAccessFor(*test) == ReportAccessFull {
+		return "", &MyError{Code: http.StatusConflict, Message: "This test already has its full report"}
+	}
+	if !canUpgradeReport(*test) {
+		return "", &MyError{Code: http.StatusConflict, Message: "This test can't be upgraded"}
+	}
+
+	if test.PaymentStatus == models.PaymentStatusPending && test.PaymentLink != "" && time.Since(test.PaymentLinkIssuedAt()) < PaymentLinkValidity {
+		return test.PaymentLink, nil
+	}
+
+	return "", &MyError{Code: http.StatusConflict, Message: "This payment link has expired, sign in to request a new one"}
+}
+
+// reissuePaymentLink cancels a test's payment link and emails a new one,
+// within the regeneration limits
+func reissuePaymentLink(c *gin.Context, test models.Test, actor string) (string, *MyError) {
+	testId := test.ID
+
+	// Counted before the provider is called, so failed attempts count too
+	claimed, err := models.ClaimPaymentLinkRegeneration(testId, time.Now().Add(-PaymentLinkRegenerateCooldown), MaxPaymentLinkRegenerations)
+	if err != nil {
+		return "", &MyError{Code: http.StatusTooManyRequests, Message: "A new payment link can be requested once every 10 minutes, up to 5 times per test"}
+	}
+
+	test = *claimed
+
+	if myErr := cancelOldPaymentLink(c, test); myErr != nil {
+		return "", myErr
+	}
+
+	// The price was fixed at submission, including any coupon
+	quote, err := QuoteForTest(test)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return "", &MyError{Code: http.StatusInternalServerError, Message: "Failed to generated payment link"}
+	}
+
+	// Links of approved minors are in their guardian's name, as on approval
+	user := models.FetchUserUsingId(test.UserId)
+	name, email := user.Name, user.Email
+	if consent, err := models.FetchGuardianConsentByTestId(testId); err == nil && consent.Status == "APPROVED" && consent.PaymentRequired {
+		name, email = consent.GuardianName, consent.GuardianEmail
+	}
+
+	shortURL, linkId, err := CreateTestPaymentLink(testId, test.UserId, name, email, *quote)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return "", &MyError{Code: http.StatusInternalServerError, Message: "Failed to generated payment link"}
+	}
+	if _, err := models.UpdateTestPaymentLink(testId, shortURL, linkId); err != nil {
+		return "", &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	models.RecordAudit(actor, "PAYMENT_LINK_REGENERATED", testId.Hex(), map[string]interface{}{
+		"previousLinkId": test.ExternalPaymentId,
+		"linkId":         linkId,
+	})
+
+	if email != "" {
+		go func() {
+			if err := apis.SendPaymentLink(email, name, shortURL); err != nil {
+				log.Println(":: Error : failed to send payment link: " + err.Error())
+			}
+		}()
+	}
+
+	return shortURL, nil
+}
@@ -0,0 +1,382 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	apis "myproject/apis"
+	"myproject/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of payment link references, "<kind>_<id>"
+const (
+	ReferenceKindTest    = "big5"
+	ReferenceKindCredits = "credits"
+	ReferenceKindGift    = "gift"
+)
+
+// ParsePaymentReference splits a payment link reference into its kind and id
+func ParsePaymentReference(referenceId string) (string, primitive.ObjectID, error) {
+	parts := strings.Split(referenceId, "_")
+	if len(parts) != 2 {
+		return "", primitive.NilObjectID, fmt.Errorf("reference %q is not in the expected format", referenceId)
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	return parts[0], id, err
+}
+
+// TestIdFromReference parses the "big5_<testId>" reference put on test payment links
+func TestIdFromReference(referenceId string) (primitive.ObjectID, error) {
+	kind, id, err := ParsePaymentReference(referenceId)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if kind != ReferenceKindTest {
+		return primitive.NilObjectID, fmt.Errorf("reference %q is not for a test", referenceId)
+	}
+	return id, nil
+}
+
+// CompleteTestPayment marks a test paid and starts its report. The redirect
+// callback, the webhook and the reconciler all end up here; only the first
+// one to arrive generates the report. ctx must outlive the request, so pass
+// a copy of a gin context.
+func CompleteTestPayment(ctx context.Context, testId primitive.ObjectID, providerPaymentId string) (*models.Test, *MyError) {
+	test, changed, err := models.MarkTestPaid(testId, providerPaymentId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to update payment status"}
+	}
+
+	if changed {
+		user := models.FetchUserUsingId(test.UserId)
+		go GenerateNewReport(ctx, *test, user)
+		go IssueAndEmailInvoice(test.ID)
+		go RewardReferral(test.ID)
+	}
+
+	return test, nil
+}
+
+// LedgerStatusFor maps a payment link status to a ledger status.
+// Unknown statuses are only added to the history.
+func LedgerStatusFor(paymentLinkStatus string) string {
+	switch paymentLinkStatus {
+	case models.PaymentStatusPaid:
+		return models.LedgerStatusPaid
+	case models.PaymentStatusExpired:
+		return models.LedgerStatusExpired
+	case models.PaymentStatusCancelled:
+		return models.LedgerStatusCancelled
+	}
+	return ""
+}
+
+// RecordPaymentEvent appends an event to the ledger entry of a payment link,
+// falling back to the settling payment id when the link is not known.
+// Ledger failures are logged so they never block the payment itself.
+func RecordPaymentEvent(providerLinkId string, providerPaymentId string, status string, event models.PaymentEvent) {
+	var payment *models.Payment
+	var err error
+
+	if providerLinkId != "" {
+		payment, err = models.FetchPaymentByLinkId(providerLinkId)
+	} else {
+		payment, err = models.FetchPaymentByProviderPaymentId(providerPaymentId)
+	}
+	if err != nil {
+		log.Printf(":: Error : no ledger entry for link %q payment %q: %v", providerLinkId, providerPaymentId, err)
+		return
+	}
+
+	if event.Status == "" {
+		event.Status = status
+	}
+	// A refund is final; a late or replayed payment is only added to the history
+	if status == models.LedgerStatusPaid && (payment.Status == models.LedgerStatusRefunded || payment.Status == models.LedgerStatusPartRefunded) {
+		status = ""
+	}
+	// Every entry carries its currency; a provider reporting another one than the link's is worth a look
+	if event.Currency == "" {
+		event.Currency = payment.Currency
+	} else if event.Amount != 0 && !strings.EqualFold(event.Currency, payment.Currency) {
+		log.Printf(":: Warning : payment %s is in %s but the provider reported %s", payment.ID.Hex(), payment.Currency, event.Currency)
+	}
+	if _, err := models.AppendPaymentEvent(payment.ID, status, event); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
+
+// HandlePaymentEvent applies a verified webhook event to the matching test.
+// Events for tests we don't know are ignored rather than failed, so the
+// provider stops retrying them.
+func HandlePaymentEvent(c *gin.Context, providerName string, event apis.PaymentEvent, payload string) *MyError {
+	if kind, id, err := ParsePaymentReference(event.ReferenceId); err == nil {
+		switch kind {
+		case ReferenceKindCredits:
+			return handleCreditPurchaseEvent(id, event, payload)
+		case ReferenceKindGift:
+			return handleGiftPurchaseEvent(id, event, payload)
+		}
+	}
+
+	switch event.Type {
+	case apis.EventPaymentLinkPaid:
+		testId, err := TestIdFromReference(event.ReferenceId)
+		if err != nil {
+			log.Println(":: Error : " + err.Error())
+			return nil
+		}
+
+		test, myErr := CompleteTestPayment(c.Copy(), testId, event.PaymentId)
+		if myErr != nil {
+			return myErr
+		}
+		if models.IsRefundedStatus(test.PaymentStatus) {
+			log.Println(":: Warning : paid event for refunded test " + testId.Hex() + " ignored")
+		}
+
+		RecordPaymentEvent(event.LinkId, event.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+			Source:            "webhook",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Payload:           payload,
+		})
+		return nil
+
+	case apis.EventPaymentLinkExpired:
+		testId, err := TestIdFromReference(event.ReferenceId)
+		if err != nil {
+			log.Println(":: Error : " + err.Error())
+			return nil
+		}
+
+		// A link paid just before it expired stays paid, and a replaced link doesn't touch the test
+		if _, err := models.ClosePendingTestPayment(testId, event.LinkId, models.PaymentStatusExpired); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to update payment status"}
+		}
+
+		RecordPaymentEvent(event.LinkId, "", models.LedgerStatusExpired, models.PaymentEvent{
+			Source:  "webhook",
+			Payload: payload,
+		})
+		return nil
+
+	case apis.EventPaymentFailed:
+		testId, err := TestIdFromReference(event.ReferenceId)
+		if err != nil {
+			// Not a payment link payment of ours
+			return nil
+		}
+
+		test, err := models.FetchTestById(testId)
+		if err != nil {
+			log.Println(":: Error : " + err.Error())
+			return nil
+		}
+
+		if err := models.UpdateTestPaymentError(testId, event.Error); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to record payment failure"}
+		}
+
+		// History only, the link can still be paid
+		RecordPaymentEvent(test.ExternalPaymentId, event.PaymentId, "", models.PaymentEvent{
+			Status:            models.LedgerStatusFailed,
+			Source:            "webhook",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Error:             event.Error,
+			Payload:           payload,
+		})
+		return nil
+
+	case apis.EventRefundProcessed:
+		// Refunds we issued ourselves are already recorded and are skipped by id
+		payment, err := models.FetchPaymentByProviderPaymentId(event.PaymentId)
+		if err != nil {
+			log.Println(":: Error : " + err.Error())
+			return nil
+		}
+
+		if err := ApplyRefund(payment, models.PaymentRefund{
+			ProviderRefundId: event.RefundId,
+			Amount:           event.Amount,
+			Currency:         event.Currency,
+			Status:           event.Status,
+			RequestedBy:      providerName,
+		}, payload, false); err != nil {
+			log.Println(":: Error : " + err.Error())
+			return &MyError{Code: http.StatusInternalServerError, Message: "Failed to record refund"}
+		}
+		return nil
+	}
+
+	// Events we are subscribed to but don't act on
+	return nil
+}
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit is not a
+// hundredth of the major one; every other currency has two decimals
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// currencyExponent returns how many decimals a currency's amounts have
+func currencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// FormatAmount renders minor units for people, e.g. "INR 210.00" or "JPY 2100"
+func FormatAmount(amount int64, currency string) string {
+	exponent := currencyExponent(currency)
+	if exponent == 0 {
+		return fmt.Sprintf("%s %d", currency, amount)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	unit := int64(1)
+	for i := 0; i < exponent; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s %s%d.%0*d", currency, sign, amount/unit, exponent, amount%unit)
+}
+
+// ApplyRefund records a refund on the ledger and, the first time it is
+// seen, moves the test along and emails the user
+func ApplyRefund(payment *models.Payment, refund models.PaymentRefund, payload string, revokeReport bool) error {
+	updatedPayment, added, err := models.AddPaymentRefund(payment.ID, refund, payload)
+	if err != nil {
+		return err
+	}
+
+	// Bundles and gifts have no test of their own; credits and redeemed gifts are left as they are
+	hasTest := !payment.TestId.IsZero()
+
+	// Revoke even when the webhook recorded the refund first
+	if revokeReport && hasTest {
+		if err := models.UpdateTestReportRevoked(payment.TestId, true); err != nil {
+			return err
+		}
+	}
+
+	if !added {
+		return nil
+	}
+
+	subject := payment.CreditPurchaseId.Hex()
+	if !payment.GiftId.IsZero() {
+		subject = payment.GiftId.Hex()
+		// A gift refunded in full before it was used can no longer be redeemed
+		if updatedPayment.Status == models.LedgerStatusRefunded {
+			if err := models.RefundGift(payment.GiftId); err != nil {
+				return err
+			}
+		}
+	}
+	if hasTest {
+		testStatus := models.PaymentStatusPartRefunded
+		if updatedPayment.Status == models.LedgerStatusRefunded {
+			testStatus = models.PaymentStatusRefunded
+		}
+		if _, err := models.UpdateTestPaymentStatus(payment.TestId, testStatus); err != nil {
+			return err
+		}
+		subject = payment.TestId.Hex()
+	}
+
+	models.RecordAudit(refund.RequestedBy, "PAYMENT_REFUNDED", subject, map[string]interface{}{
+		"paymentId":     payment.ID.Hex(),
+		"refundId":      refund.ProviderRefundId,
+		"amount":        refund.Amount,
+		"reason":        refund.Reason,
+		"reportRevoked": revokeReport,
+	})
+
+	user := models.FetchUserUsingId(payment.UserId)
+	if user.Email != "" {
+		if err := apis.SendRefundConfirmation(user.Email, user.Name, FormatAmount(refund.Amount, payment.Currency), revokeReport); err != nil {
+			log.Println(":: Error : failed to send refund confirmation: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// RefundTest refunds the settling payment of a test through the provider.
+// amount is in minor units; 0 refunds whatever is left.
+func RefundTest(testId primitive.ObjectID, amount int64, reason string, revokeReport bool, actor string) (*models.Payment, *MyError) {
+	test, err := models.FetchTestById(testId)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	if test.ExternalPaymentId == "" {
+		return nil, &MyError{Code: http.StatusConflict, Message: "Test was not paid through a payment link"}
+	}
+
+	payment, err := models.FetchPaymentByLinkId(test.ExternalPaymentId)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusConflict, Message: "No ledger entry for this test's payment"}
+	}
+
+	refundable := payment.RefundableAmount()
+	if refundable <= 0 || payment.ProviderPaymentId == "" {
+		return nil, &MyError{Code: http.StatusConflict, Message: "Nothing left to refund for this test"}
+	}
+	if amount == 0 {
+		amount = refundable
+	}
+	if amount < 0 || amount > refundable {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: fmt.Sprintf("Amount must be between 1 and %d", refundable)}
+	}
+
+	provider, err := apis.GetPaymentProvider(payment.Provider)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusConflict, Message: err.Error()}
+	}
+
+	refund, err := provider.Refund(payment.ProviderPaymentId, amount, map[string]string{
+		"testId": testId.Hex(),
+		"reason": reason,
+	})
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadGateway, Message: "Refund failed: " + err.Error()}
+	}
+
+	payload, _ := json.Marshal(refund.Raw)
+	if err := ApplyRefund(payment, models.PaymentRefund{
+		ProviderRefundId: refund.Id,
+		Amount:           amount,
+		Status:           refund.Status,
+		Reason:           reason,
+		RequestedBy:      actor,
+	}, string(payload), revokeReport); err != nil {
+		// The money has moved; the webhook will record the refund if this failed
+		log.Println(":: Error : " + err.Error())
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Refund issued but failed to record it"}
+	}
+
+	updatedPayment, err := models.FetchPaymentById(payment.ID)
+	if err != nil {
+		return payment, nil
+	}
+	return updatedPayment, nil
+}
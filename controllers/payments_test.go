@@ -0,0 +1,27 @@
+package controller
+
+import "testing"
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		currency string
+		want     string
+	}{
+		{"two decimals", 21000, "INR", "INR 210.00"},
+		{"cents", 4999, "USD", "USD 49.99"},
+		{"zero decimals", 2100, "JPY", "JPY 2100"},
+		{"zero decimals lower case", 15000, "krw", "krw 15000"},
+		{"three decimals", 1500, "KWD", "KWD 1.500"},
+		{"negative", -250, "EUR", "EUR -2.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatAmount(tt.amount, tt.currency); got != tt.want {
+				t.Errorf("FormatAmount(%d, %s) = %s, want %s", tt.amount, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
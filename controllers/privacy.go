@@ -0,0 +1,395 @@
+package controller
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"myproject/models"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// personalDataSource describes one collection holding data tied to a user.
+// Add an entry here when a new collection stores personal data so export
+// and erasure stay complete.
+type personalDataSource struct {
+	Name       string
+	Model      mgm.Model
+	Filter     func(userId primitive.ObjectID) bson.M
+	SkipExport bool
+	// Anonymise is the $set applied in ANONYMISE mode. nil means the
+	// documents are deleted in both modes.
+	Anonymise func(userId primitive.ObjectID) bson.M
+	// Retain marks records we must keep by law; they are anonymised in DELETE mode too
+	Retain bool
+}
+
+func byUserId(userId primitive.ObjectID) bson.M {
+	return bson.M{"userId": userId}
+}
+
+// byRecipientEmail matches gifts sent to the user's address, claimed or not
+func byRecipientEmail(userId primitive.ObjectID) bson.M {
+	user := models.FetchUserUsingId(userId)
+	if user.Email == "" {
+		// Match nothing rather than every gift without an address
+		return bson.M{"_id": primitive.NilObjectID}
+	}
+	return bson.M{"recipientEmail": strings.ToLower(user.Email)}
+}
+
+func userWallet(userId primitive.ObjectID) bson.M {
+	return bson.M{"owner.type": models.CreditOwnerUser, "owner.id": userId}
+}
+
+var personalDataSources = []personalDataSource{
+	{
+		Name:   "scores",
+		Model:  &models.Score{},
+		Filter: byUserId,
+	},
+	{
+		Name:   "reports",
+		Model:  &models.Report{},
+		Filter: byUserId,
+	},
+	{
+		Name:   "finalreports",
+		Model:  &models.FinalReport{},
+		Filter: byUserId,
+	},
+	{
+		Name:   "useridentities",
+		Model:  &models.UserIdentity{},
+		Filter: byUserId,
+	},
+	{
+		Name:   "consents",
+		Model:  &models.Consent{},
+		Filter: byUserId,
+	},
+	{
+		Name:   "guardianconsents",
+		Model:  &models.GuardianConsent{},
+		Filter: byUserId,
+	},
+	{
+		Name:       "refreshtokens",
+		Model:      &models.RefreshToken{},
+		Filter:     func(userId primitive.ObjectID) bson.M { return bson.M{"subject": userId.Hex()} },
+		SkipExport: true,
+	},
+	{
+		// The ledger is kept for accounting; raw provider payloads carry contact details
+		Name:   "payments",
+		Model:  &models.Payment{},
+		Filter: byUserId,
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"history.$[].payload": ""}
+		},
+	},
+	{
+		// Tax invoices are kept for the statutory period, without the buyer's contact details
+		Name:   "invoices",
+		Model:  &models.Invoice{},
+		Filter: byUserId,
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"buyer.email": "", "buyer.address": ""}
+		},
+		Retain: true,
+	},
+	{
+		// Redemptions stay for coupon analytics without the user
+		Name:   "couponredemptions",
+		Model:  &models.CouponRedemption{},
+		Filter: byUserId,
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"userId": primitive.NilObjectID}
+		},
+	},
+	{
+		// Gifts stay for the ledger without the buyer's note
+		Name:   "gifts",
+		Model:  &models.Gift{},
+		Filter: func(userId primitive.ObjectID) bson.M { return bson.M{"buyerId": userId} },
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"buyerId": primitive.NilObjectID, "message": ""}
+		},
+	},
+	{
+		// and without the recipient's details
+		Name:   "giftsreceived",
+		Model:  &models.Gift{},
+		Filter: func(userId primitive.ObjectID) bson.M { return bson.M{"recipientUserId": userId} },
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"recipientUserId": primitive.NilObjectID, "recipientName": "", "recipientEmail": ""}
+		},
+	},
+	{
+		// Gifts addressed to the user that were never claimed only know their email
+		Name:   "giftsaddressed",
+		Model:  &models.Gift{},
+		Filter: byRecipientEmail,
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"recipientName": "", "recipientEmail": ""}
+		},
+	},
+	{
+		Name:   "referralcodes",
+		Model:  &models.ReferralCode{},
+		Filter: byUserId,
+	},
+	{
+		// Referrals stay for the referrer's stats without the referee
+		Name:   "referrals",
+		Model:  &models.Referral{},
+		Filter: func(userId primitive.ObjectID) bson.M { return bson.M{"refereeId": userId} },
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"refereeId": primitive.NilObjectID, "ipAddress": ""}
+		},
+	},
+	{
+		// and for the referee's discount without the referrer
+		Name:   "referralsmade",
+		Model:  &models.Referral{},
+		Filter: func(userId primitive.ObjectID) bson.M { return bson.M{"referrerId": userId} },
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"referrerId": primitive.NilObjectID, "code": ""}
+		},
+	},
+	{
+		// Used grants stay for the audit trail without the user
+		Name:   "paymentgrants",
+		Model:  &models.PaymentGrant{},
+		Filter: func(userId primitive.ObjectID) bson.M { return bson.M{"usedBy": userId} },
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"usedBy": primitive.NilObjectID, "email": ""}
+		},
+	},
+	{
+		// Redeemed vouchers stay in their batch's usage without the user
+		Name:   "vouchers",
+		Model:  &models.Voucher{},
+		Filter: byUserId,
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{"userId": primitive.NilObjectID}
+		},
+	},
+	{
+		// A user's wallet goes with the account
+		Name:   "creditlots",
+		Model:  &models.CreditLot{},
+		Filter: userWallet,
+	},
+	{
+		Name:   "credittransactions",
+		Model:  &models.CreditTransaction{},
+		Filter: userWallet,
+	},
+	{
+		Name:   "creditpurchases",
+		Model:  &models.CreditPurchase{},
+		Filter: byUserId,
+	},
+	{
+		// Tests hold the payment state, so they are kept without the test giver's
+		// details. Billing keeps its state and country for tax reports.
+		Name:   "tests",
+		Model:  &models.Test{},
+		Filter: byUserId,
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{
+				"testGiven":       "",
+				"testGiverAge":    0,
+				"testGiverGender": "",
+				"billing.name":    "",
+				"billing.address": "",
+				"billing.gstin":   "",
+			}
+		},
+	},
+	{
+		// Users last, so a failure part way leaves the account findable for a retry
+		Name:   "users",
+		Model:  &models.User{},
+		Filter: func(userId primitive.ObjectID) bson.M { return bson.M{"_id": userId} },
+		Anonymise: func(userId primitive.ObjectID) bson.M {
+			return bson.M{
+				"name":    "",
+				"email":   "erased-" + userId.Hex() + "@erased.invalid",
+				"gender":  "",
+				"age":     0,
+				"profile": nil,
+				"status":  "ERASED",
+			}
+		},
+	},
+}
+
+// webhookPersonalFields are blanked in the payloads kept in webhookevents.
+// Those events can't be traced back to a user at erasure, so they are stored
+// without contact details in the first place.
+var webhookPersonalFields = map[string]bool{
+	"name":     true,
+	"email":    true,
+	"contact":  true,
+	"phone":    true,
+	"address":  true,
+	"customer": true,
+	"notes":    true,
+}
+
+// RedactWebhookPayload returns a provider payload with its personal fields
+// blanked, at any depth. A payload that isn't JSON is not kept at all.
+func RedactWebhookPayload(payload []byte) string {
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return ""
+	}
+
+	redacted, err := json.Marshal(redactPersonalFields(document))
+	if err != nil {
+		return ""
+	}
+	return string(redacted)
+}
+
+func redactPersonalFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if webhookPersonalFields[strings.ToLower(key)] {
+				v[key] = ""
+				continue
+			}
+			v[key] = redactPersonalFields(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactPersonalFields(item)
+		}
+	}
+	return value
+}
+
+// BuildUserExport collects every document tied to a user, keyed by collection
+func BuildUserExport(userId primitive.ObjectID) (map[string][]bson.M, error) {
+	export := map[string][]bson.M{}
+
+	for _, source := range personalDataSources {
+		if source.SkipExport {
+			continue
+		}
+
+		documents := []bson.M{}
+		if err := mgm.Coll(source.Model).SimpleFind(&documents, source.Filter(userId)); err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", source.Name, err)
+		}
+		export[source.Name] = documents
+	}
+
+	return export, nil
+}
+
+// WriteUserExportZip writes the export as one JSON file per collection
+func WriteUserExportZip(w io.Writer, export map[string][]bson.M) error {
+	archive := zip.NewWriter(w)
+
+	for name, documents := range export {
+		file, err := archive.Create(name + ".json")
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(documents); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// ErasureGracePeriod is how long a scheduled erasure can still be cancelled
+func ErasureGracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ERASURE_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ExecuteErasure removes or anonymises every document tied to the request's user
+func ExecuteErasure(request models.ErasureRequest) error {
+	counts := map[string]interface{}{}
+
+	for _, source := range personalDataSources {
+		filter := source.Filter(request.UserId)
+
+		if (request.Mode == models.ErasureModeAnonymise || source.Retain) && source.Anonymise != nil {
+			result, err := mgm.Coll(source.Model).UpdateMany(context.TODO(), filter, bson.M{"$set": source.Anonymise(request.UserId)})
+			if err != nil {
+				return fmt.Errorf("failed to anonymise %s: %v", source.Name, err)
+			}
+			counts[source.Name] = result.ModifiedCount
+			continue
+		}
+
+		result, err := mgm.Coll(source.Model).DeleteMany(context.TODO(), filter)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %v", source.Name, err)
+		}
+		counts[source.Name] = result.DeletedCount
+	}
+
+	if err := models.RevokeAllTokensForSubject(request.UserId.Hex()); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+
+	models.RecordAudit("system", "ERASURE_COMPLETED", request.UserId.Hex(), map[string]interface{}{
+		"requestId": request.ID.Hex(),
+		"mode":      request.Mode,
+		"counts":    counts,
+	})
+
+	return nil
+}
+
+// ProcessDueErasureRequests carries out every request past its grace period
+func ProcessDueErasureRequests() error {
+	requests, err := models.FetchDueErasureRequests()
+	if err != nil {
+		return err
+	}
+
+	for _, request := range requests {
+		if err := ExecuteErasure(request); err != nil {
+			log.Printf(":: Error : erasure %s failed: %v", request.ID.Hex(), err)
+			models.UpdateErasureRequestStatus(request.ID, "FAILED")
+			models.RecordAudit("system", "ERASURE_FAILED", request.UserId.Hex(), map[string]interface{}{
+				"requestId": request.ID.Hex(),
+				"error":     err.Error(),
+			})
+			continue
+		}
+
+		if err := models.UpdateErasureRequestStatus(request.ID, "COMPLETED"); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Erasure must clear the personal fields that are kept on retained documents
+func TestPersonalDataSourcesAnonymise(t *testing.T) {
+	want := map[string][]string{
+		"users":          {"name", "email", "gender", "age", "profile"},
+		"giftsaddressed": {"recipientName", "recipientEmail"},
+		"tests":          {"testGiven", "testGiverAge", "testGiverGender", "billing.name", "billing.address", "billing.gstin"},
+	}
+
+	userId := primitive.NewObjectID()
+	for _, source := range personalDataSources {
+		fields, ok := want[source.Name]
+		if !ok {
+			continue
+		}
+		delete(want, source.Name)
+
+		if source.SkipExport {
+			t.Errorf("%s is left out of the export", source.Name)
+		}
+		if source.Anonymise == nil {
+			t.Errorf("%s has no anonymisation", source.Name)
+			continue
+		}
+		set := source.Anonymise(userId)
+		for _, field := range fields {
+			if _, ok := set[field]; !ok {
+				t.Errorf("%s anonymisation keeps %s", source.Name, field)
+			}
+		}
+	}
+
+	for name := range want {
+		t.Errorf("no personal data source for %s", name)
+	}
+}
+
+// Stored webhook payloads keep the event but not the customer's details
+func TestRedactWebhookPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{
+			name:    "nested customer",
+			payload: `{"event":"payment_link.paid","payload":{"payment_link":{"entity":{"id":"plink_1","amount":49900,"customer":{"email":"a@b.c","contact":"+910000000000"}}}}}`,
+			want:    `{"event":"payment_link.paid","payload":{"payment_link":{"entity":{"amount":49900,"customer":"","id":"plink_1"}}}}`,
+		},
+		{
+			name:    "fields in a list",
+			payload: `{"items":[{"Email":"a@b.c","status":"paid"}]}`,
+			want:    `{"items":[{"Email":"","status":"paid"}]}`,
+		},
+		{
+			name:    "not json",
+			payload: `email=a@b.c`,
+			want:    ``,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactWebhookPayload([]byte(tt.payload)); got != tt.want {
+				t.Errorf("RedactWebhookPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"myproject/models"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is charged when a submission does not ask for another one
+const DefaultCurrency = "INR"
+
+// InternationalCurrency is charged abroad where a product has no local price
+const InternationalCurrency = "USD"
+
+// countryCurrencies is the currency each country is priced in. Countries not
+// listed pay InternationalCurrency.
+var countryCurrencies = map[string]string{
+	"IN": "INR",
+	"US": "USD",
+	"GB": "GBP",
+	"AE": "AED",
+	"SG": "SGD",
+	"AU": "AUD",
+	"CA": "CAD",
+	"AT": "EUR", "BE": "EUR", "DE": "EUR", "ES": "EUR", "FI": "EUR", "FR": "EUR",
+	"IE": "EUR", "IT": "EUR", "LU": "EUR", "NL": "EUR", "PT": "EUR", "GR": "EUR",
+}
+
+// NormalizeCountry returns an ISO 3166 alpha-2 code in upper case, or "" when
+// country isn't one
+func NormalizeCountry(country string) string {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+		return ""
+	}
+	return country
+}
+
+// CurrencyForCountry returns the currency a country is priced in, DefaultCurrency when unknown
+func CurrencyForCountry(country string) string {
+	country = NormalizeCountry(country)
+	if country == "" {
+		return DefaultCurrency
+	}
+	if currency, ok := countryCurrencies[country]; ok {
+		return currency
+	}
+	return InternationalCurrency
+}
+
+// ChooseCurrency picks what a product is charged in, with its amount. A
+// currency asked for explicitly must be sold; otherwise the country's own
+// currency is used, then InternationalCurrency abroad, then DefaultCurrency.
+func ChooseCurrency(product *models.Product, currency string, country string) (string, int64, error) {
+	if currency != "" {
+		currency = strings.ToUpper(currency)
+		amount, ok := product.Price(currency)
+		if !ok {
+			return "", 0, fmt.Errorf("%s is not sold in %s", product.Sku, currency)
+		}
+		return currency, amount, nil
+	}
+
+	candidates := []string{CurrencyForCountry(country)}
+	if country := NormalizeCountry(country); country != "" && country != "IN" {
+		candidates = append(candidates, InternationalCurrency)
+	}
+	candidates = append(candidates, DefaultCurrency)
+
+	for _, candidate := range candidates {
+		if amount, ok := product.Price(candidate); ok {
+			return candidate, amount, nil
+		}
+	}
+	return "", 0, fmt.Errorf("%s has no price for %s", product.Sku, country)
+}
+
+// PriceQuote is what a test will be charged, taken from the product catalog
+type PriceQuote struct {
+	Sku         string
+	Description string
+	TaxClass    string
+	Currency    string
+	Amount      int64 // Minor units
+}
+
+// legacyProduct prices BIG_5 from BIG_5_REPORT_PRICE until the catalog has been seeded
+func legacyProduct(testName string, tier string) (*models.Product, error) {
+	if testName != "BIG_5" || tier != models.TierStandard {
+		return nil, fmt.Errorf("no product for %s %s", testName, tier)
+	}
+
+	amount, err := strconv.ParseInt(os.Getenv("BIG_5_REPORT_PRICE"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println(":: Warning : no BIG_5 product in the catalog, using BIG_5_REPORT_PRICE")
+	return models.NewProduct("BIG_5_STANDARD", "BIG 5 Personality Report", "For BIG 5 report generator", testName, tier, map[string]int64{DefaultCurrency: amount}, "", os.Getenv("INVOICE_SAC")), nil
+}
+
+// QuoteTest looks up the active product for a test and tier and its price,
+// in currency when given, otherwise in the currency of the billing country
+func QuoteTest(testName string, tier string, currency string, country string) (*PriceQuote, error) {
+	if tier == "" {
+		tier = models.TierStandard
+	}
+
+	product, err := models.FetchActiveProduct(testName, tier)
+	if err != nil {
+		if product, err = legacyProduct(testName, tier); err != nil {
+			return nil, err
+		}
+	}
+
+	currency, amount, err := ChooseCurrency(product, currency, country)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PriceQuote{
+		Sku:         product.Sku,
+		Description: product.Description,
+		TaxClass:    product.TaxClass,
+		Currency:    currency,
+		Amount:      amount,
+	}, nil
+}
+
+// QuoteForTest rebuilds the quote fixed at submission, e.g. for a held test
+// whose payment link is created later
+func QuoteForTest(test models.Test) (*PriceQuote, error) {
+	if test.ProductSku == "" || test.Amount == 0 {
+		// Tests submitted before the catalog existed
+		return QuoteTest(test.TestName, models.TierStandard, DefaultCurrency, "")
+	}
+
+	quote := &PriceQuote{Sku: test.ProductSku, Currency: test.Currency, Amount: test.Amount}
+	if product, err := models.FetchProductBySku(test.ProductSku); err == nil {
+		quote.Description = product.Description
+		quote.TaxClass = product.TaxClass
+	}
+	if quote.Currency == "" {
+		quote.Currency = DefaultCurrency
+	}
+	return quote, nil
+}
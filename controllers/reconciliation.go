@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	apis "myproject/apis"
+	"myproject/models"
+	"strings"
+	"time"
+)
+
+// ReconcileInterval is how often open payment links are checked with their provider
+const ReconcileInterval = 15 * time.Minute
+
+// staleLinkGrace allows for providers expiring links a little after expire_by
+const staleLinkGrace = time.Hour
+
+// ReconcilePayments asks the provider about every test awaiting payment and
+// settles or expires it when a callback or webhook was missed. Everything it
+// corrects or can't explain goes into the day's reconciliation report.
+func ReconcilePayments() error {
+	tests, err := models.FetchTestsAwaitingPayment()
+	if err != nil {
+		return err
+	}
+
+	run := models.ReconciliationRun{}
+	for _, test := range tests {
+		run.Checked++
+		reconcileTest(test, &run)
+	}
+
+	report, err := models.RecordReconciliationRun(ReconciliationDate(time.Now()), run)
+	if err != nil {
+		return err
+	}
+
+	if len(run.Mismatches) > 0 {
+		log.Printf(":: Reconciliation : %d mismatches in this run, %d today", len(run.Mismatches), len(report.Mismatches))
+	}
+	return nil
+}
+
+// ReconciliationDate is the report a moment belongs to; days follow IST
+func ReconciliationDate(at time.Time) string {
+	return at.In(indiaTime).Format("2006-01-02")
+}
+
+func reconcileTest(test models.Test, run *models.ReconciliationRun) {
+	mismatch := models.ReconciliationMismatch{
+		TestId:   test.ID,
+		Provider: "razorpay", // Links created before the ledger are all Razorpay's
+		LinkId:   test.ExternalPaymentId,
+		Ours:     test.PaymentStatus,
+		Action:   "NONE",
+		At:       time.Now(),
+	}
+
+	payment, err := models.FetchPaymentByLinkId(test.ExternalPaymentId)
+	if err == nil {
+		mismatch.PaymentId = payment.ID
+		mismatch.Provider = payment.Provider
+	}
+
+	provider, err := apis.GetPaymentProvider(mismatch.Provider)
+	if err != nil {
+		mismatch.Kind = models.MismatchProviderError
+		mismatch.Theirs = err.Error()
+		run.Mismatches = append(run.Mismatches, mismatch)
+		return
+	}
+
+	state, err := provider.FetchPaymentLink(test.ExternalPaymentId)
+	if err != nil {
+		mismatch.Kind = models.MismatchProviderError
+		mismatch.Theirs = err.Error()
+		run.Mismatches = append(run.Mismatches, mismatch)
+		return
+	}
+	mismatch.Theirs = state.Status
+
+	payload, _ := json.Marshal(state.Raw)
+
+	switch state.Status {
+	case apis.LinkStatusPaid:
+		if payment != nil && state.AmountPaid != 0 && state.AmountPaid != payment.Amount {
+			amountMismatch := mismatch
+			amountMismatch.Kind = models.MismatchAmount
+			amountMismatch.Ours = FormatAmount(payment.Amount, payment.Currency)
+			amountMismatch.Theirs = FormatAmount(state.AmountPaid, payment.Currency)
+			run.Mismatches = append(run.Mismatches, amountMismatch)
+		}
+
+		mismatch.Kind = models.MismatchPaidNotRecorded
+		if _, myErr := CompleteTestPayment(context.Background(), test.ID, state.PaymentId); myErr != nil {
+			mismatch.Action = "UPDATE_FAILED"
+			run.Mismatches = append(run.Mismatches, mismatch)
+			return
+		}
+
+		RecordPaymentEvent(test.ExternalPaymentId, state.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+			Source:            "reconciliation",
+			ProviderPaymentId: state.PaymentId,
+			Amount:            state.AmountPaid,
+			Payload:           string(payload),
+		})
+		mismatch.Action = "MARKED_PAID"
+		run.Settled++
+
+	case apis.LinkStatusExpired, apis.LinkStatusCancelled:
+		mismatch.Kind = models.MismatchExpiredNotRecorded
+		if expireTestPayment(test, state.Status, string(payload), &mismatch) {
+			run.Expired++
+		}
+
+	default:
+		if time.Since(test.PaymentLinkIssuedAt()) < PaymentLinkValidity+staleLinkGrace {
+			// Still open and within its validity, nothing to reconcile
+			return
+		}
+
+		// A late payment still settles the test through the webhook
+		mismatch.Kind = models.MismatchStaleLink
+		if expireTestPayment(test, apis.LinkStatusExpired, string(payload), &mismatch) {
+			run.Expired++
+		}
+	}
+
+	run.Mismatches = append(run.Mismatches, mismatch)
+}
+
+// expireTestPayment closes a test's payment with status and notes the action
+// on mismatch. It returns false when the test could not be updated.
+func expireTestPayment(test models.Test, status string, payload string, mismatch *models.ReconciliationMismatch) bool {
+	closed, err := models.ClosePendingTestPayment(test.ID, test.ExternalPaymentId, status)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		mismatch.Action = "UPDATE_FAILED"
+		return false
+	}
+	if !closed {
+		// Settled by a webhook or given a new link while we were checking
+		mismatch.Action = "NONE"
+		return false
+	}
+
+	RecordPaymentEvent(test.ExternalPaymentId, "", LedgerStatusFor(status), models.PaymentEvent{
+		Source:  "reconciliation",
+		Payload: payload,
+	})
+	mismatch.Action = "MARKED_" + strings.ToUpper(status)
+	return true
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"myproject/models"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// envInt reads a positive integer setting, falling back to def
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// ReferralDiscountPercent is the referee's discount on their first report
+func ReferralDiscountPercent() int64 {
+	percent := int64(envInt("REFERRAL_DISCOUNT_PERCENT", 10))
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// ReferralRewardCredits is what a referrer earns once a referee's payment is captured
+func ReferralRewardCredits() int {
+	return envInt("REFERRAL_REWARD_CREDITS", 1)
+}
+
+// MyReferralCode returns the user's referral code, creating it on first use
+func MyReferralCode(userId primitive.ObjectID) (*models.ReferralCode, error) {
+	code, err := randomCode(8)
+	if err != nil {
+		return nil, err
+	}
+	// A clash with another user's code is unlikely but would make both ambiguous
+	if _, err := models.FetchReferralCodeByCode(code); err == nil {
+		if code, err = randomCode(8); err != nil {
+			return nil, err
+		}
+	}
+	return models.FetchOrCreateReferralCode(userId, code)
+}
+
+// ReferralLink is the sign up link carrying a referral code
+func ReferralLink(code string) string {
+	return os.Getenv("WEBAPP_DOMAIN") + "/?ref=" + url.QueryEscape(code)
+}
+
+// ReferralQuote is a referral code checked for one submission
+type ReferralQuote struct {
+	Code     *models.ReferralCode
+	Discount int64
+	Flags    []string
+}
+
+// ApplyReferral checks a referral code for the referee's submission and
+// works out their discount. Self referrals and users who already took a test
+// are refused; patterns that look like abuse are flagged so the referrer's
+// reward waits for review.
+func ApplyReferral(code string, referee models.User, price int64, ipAddress string) (*ReferralQuote, *MyError) {
+	referralCode, err := models.FetchReferralCodeByCode(code)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid referral code"}
+	}
+
+	if referralCode.UserId == referee.ID {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "You can't use your own referral code"}
+	}
+
+	referred, err := models.CountReferralsByReferee(referee.ID)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to check referral"}
+	}
+	tests, err := models.CountTestsByUser(referee.ID)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to check referral"}
+	}
+	if referred > 0 || tests > 0 {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Referral discounts are for a first report"}
+	}
+
+	flags := []string{}
+	since := time.Now().Add(-24 * time.Hour)
+	if count, err := models.CountReferralsSince(referralCode.UserId, since, ""); err == nil && count >= int64(envInt("REFERRAL_DAILY_LIMIT", 10)) {
+		flags = append(flags, models.ReferralFlagVelocity)
+	}
+	if ipAddress != "" {
+		if count, err := models.CountReferralsSince(referralCode.UserId, since, ipAddress); err == nil && count >= int64(envInt("REFERRAL_IP_LIMIT", 3)) {
+			flags = append(flags, models.ReferralFlagSharedIp)
+		}
+	}
+
+	return &ReferralQuote{
+		Code:     referralCode,
+		Discount: price * ReferralDiscountPercent() / 100,
+		Flags:    flags,
+	}, nil
+}
+
+// RecordReferral stores the referral once the referee's test exists
+func RecordReferral(quote *ReferralQuote, refereeId primitive.ObjectID, testId primitive.ObjectID, currency string, ipAddress string) error {
+	if quote == nil {
+		return nil
+	}
+
+	referral := models.NewReferral(quote.Code, refereeId, testId, quote.Discount, currency, ipAddress, quote.Flags)
+	if err := mgm.Coll(referral).Create(referral); err != nil {
+		return err
+	}
+
+	if referral.Status == models.ReferralFlagged {
+		models.RecordAudit("system", "REFERRAL_FLAGGED", referral.ID.Hex(), map[string]interface{}{
+			"referrerId": referral.ReferrerId.Hex(),
+			"refereeId":  refereeId.Hex(),
+			"flags":      quote.Flags,
+		})
+	}
+	return nil
+}
+
+// RewardReferral credits the referrer once the referred test is paid. Tests
+// without a pending referral are left alone, so it is safe to call on every
+// capture.
+func RewardReferral(testId primitive.ObjectID) {
+	referral, err := models.FetchReferralByTestId(testId)
+	if err != nil || referral.Status != models.ReferralPending {
+		return
+	}
+	rewardReferral(referral)
+}
+
+func rewardReferral(referral *models.Referral) {
+	credits := ReferralRewardCredits()
+	claimed, err := models.ClaimReferralReward(referral.ID, credits)
+	if err != nil {
+		if !errors.Is(err, models.ErrReferralNotDue) {
+			log.Println(":: Error : " + err.Error())
+		}
+		return
+	}
+
+	testName := "BIG_5"
+	if test, err := models.FetchTestById(claimed.TestId); err == nil {
+		testName = test.TestName
+	}
+
+	lot := models.NewCreditLot(UserWallet(claimed.ReferrerId), testName, credits, time.Now().AddDate(0, 0, DefaultCreditValidityDays), models.CreditSourceReferral)
+	lot.Note = "Referral reward for test " + claimed.TestId.Hex()
+	if err := models.GrantCreditLot(lot, "referral"); err != nil {
+		log.Println(":: Error : failed to add referral reward for " + claimed.ID.Hex() + ": " + err.Error())
+		return
+	}
+	if err := models.UpdateReferralRewardLot(claimed.ID, lot.ID); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+
+	models.RecordAudit("referral", "REFERRAL_REWARDED", claimed.ID.Hex(), map[string]interface{}{
+		"referrerId": claimed.ReferrerId.Hex(),
+		"testId":     claimed.TestId.Hex(),
+		"credits":    credits,
+		"lotId":      lot.ID.Hex(),
+	})
+}
+
+// ApproveReferral clears a flagged referral. Its reward is paid straight
+// away when the referred test was paid already.
+func ApproveReferral(id primitive.ObjectID, actor string) (*models.Referral, *MyError) {
+	referral, err := models.UpdateReferralStatus(id, []string{models.ReferralFlagged}, models.ReferralPending, actor)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusConflict, Message: "Only flagged referrals can be approved"}
+	}
+	models.RecordAudit(actor, "REFERRAL_APPROVED", referral.ID.Hex(), nil)
+
+	if test, err := models.FetchTestById(referral.TestId); err == nil && test.PaymentStatus == models.PaymentStatusPaid {
+		rewardReferral(referral)
+		if rewarded, err := models.FetchReferralById(id); err == nil {
+			referral = rewarded
+		}
+	}
+
+	return referral, nil
+}
+
+// RejectReferral refuses the reward of a referral that was not paid out yet
+func RejectReferral(id primitive.ObjectID, actor string) (*models.Referral, *MyError) {
+	referral, err := models.UpdateReferralStatus(id, []string{models.ReferralFlagged, models.ReferralPending}, models.ReferralRejected, actor)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusConflict, Message: "Only flagged or pending referrals can be rejected"}
+	}
+	models.RecordAudit(actor, "REFERRAL_REJECTED", referral.ID.Hex(), nil)
+
+	return referral, nil
+}
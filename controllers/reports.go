@@ -1,14 +1,16 @@
 package controller
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"myproject/models"
 	"net/http"
 
-	"github.com/gin-gonic/gin"
 	"github.com/kamva/mgm/v3"
 
-	// "strconv"
 	apis "myproject/apis"
 	"os"
 	"time"
@@ -18,9 +20,11 @@ import (
 )
 
 type ReportResponse struct {
-	Report   []models.Report      `json:"report"`
-	AiReport []models.FinalReport `json:"aiReport"`
-	Name     string               `json:"name"`
+	Report           []models.Report      `json:"report"`
+	AiReport         []models.FinalReport `json:"aiReport"`
+	Name             string               `json:"name"`
+	Tier             string               `json:"tier"`
+	UpgradeAvailable bool                 `json:"upgradeAvailable,omitempty"` // The summary can be upgraded by paying
 }
 
 type MyError struct {
@@ -28,31 +32,59 @@ type MyError struct {
 	Message string
 }
 
+// PaymentLinkValidity is how long a generated payment link can be paid
+const PaymentLinkValidity = 7 * 24 * time.Hour
+
 // var OutputPageMap = []string{"result", "relationsh`ip", "career_academic", "strength_weakness"}
 
-func GenerateNewReport(c *gin.Context, test models.Test, user models.User) *MyError {
+// Report access tiers. A test awaiting payment gets the free summary: its
+// domain scores and intensities, without the AI narrative.
+const (
+	ReportAccessSummary = "SUMMARY"
+	ReportAccessFull    = "FULL"
+)
+
+// ReportAccessFor returns the tier of report a test's payment state unlocks.
+// Refunded tests keep their full report unless it was revoked.
+func ReportAccessFor(test models.Test) string {
+	if isAwaitingPayment(test) && test.PaymentStatus != models.PaymentStatusRefunded {
+		return ReportAccessSummary
+	}
+	return ReportAccessFull
+}
+
+// processTestScores scores a test's answers by domain
+func processTestScores(testId primitive.ObjectID) ([]apis.Domain, *MyError) {
 	startTime := time.Now()
 
 	// Fetch Scores and Questions
-	scoresAndQuestions, err := FetchScoresWithQuestions(test.ID)
+	scoresAndQuestions, err := FetchScoresWithQuestions(testId)
 	if err != nil {
-		return &MyError{
+		return nil, &MyError{
 			Code:    http.StatusInternalServerError,
 			Message: "Failed to fetch scores and questions",
 		}
 	}
 	fmt.Println("Time taken by Fetch Scores with Questions apis calls:", time.Since(startTime))
 
-	// Process Scores
-	processedScores := CalculateProcessedScore(scoresAndQuestions)
+	return CalculateProcessedScore(scoresAndQuestions), nil
+}
 
-	newDbReports := map[string]models.Report{}
+// storeDomainReports saves the per domain scores of a test. A test that has
+// them already, from its free summary, is left as it is.
+func storeDomainReports(c context.Context, testId primitive.ObjectID, processedScores []apis.Domain) *MyError {
+	count, err := mgm.Coll(&models.Report{}).CountDocuments(c, bson.M{"testId": testId})
+	if err != nil {
+		return &MyError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if count > 0 {
+		return nil
+	}
 
 	// Generate Reports for Each Domain
+	var docs []interface{}
 	for _, value := range processedScores {
 		newSubdomainReports := []models.Subdomain{}
-		_domainName := value.Name
-		_domainIntensity := value.Intensity
 
 		// Create Subdomain Reports
 		for _, subdomain := range value.Subdomain {
@@ -61,26 +93,80 @@ func GenerateNewReport(c *gin.Context, test models.Test, user models.User) *MyEr
 		}
 
 		// Create Report for Domain
-		newDbReport := models.NewReport(value.Name, value.Score, newSubdomainReports, value.UserId, value.TestId, _domainIntensity, "")
-		newDbReports[_domainName] = *newDbReport
+		docs = append(docs, *models.NewReport(value.Name, value.Score, newSubdomainReports, value.UserId, value.TestId, value.Intensity, ""))
+	}
+
+	// Save Report to Database
+	startTime := time.Now()
+	_, err = mgm.Coll(&models.Report{}).InsertMany(c, docs)
+	fmt.Println("Time taken to save Report in db:", time.Since(startTime))
+	if err != nil {
+		return &MyError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to insert questions",
+		}
+	}
+
+	return nil
+}
+
+// GenerateSummaryReport stores the free summary of a test that is awaiting
+// payment. The AI narrative is added by GenerateNewReport once it is paid.
+func GenerateSummaryReport(c context.Context, test models.Test) *MyError {
+	processedScores, myErr := processTestScores(test.ID)
+	if myErr != nil {
+		return myErr
+	}
+	return storeDomainReports(c, test.ID, processedScores)
+}
+
+func GenerateNewReport(c context.Context, test models.Test, user models.User) *MyError {
+	// Process Scores
+	processedScores, myErr := processTestScores(test.ID)
+	if myErr != nil {
+		return myErr
+	}
+
+	// A test upgraded from the free summary has its domain scores already
+	if myErr := storeDomainReports(c, test.ID, processedScores); myErr != nil {
+		return myErr
 	}
 
-	finalPrompt := apis.CreatePrompt(processedScores)
+	finalPrompt := apis.CreatePrompt(processedScores, promptProfileFor(test, user))
 	finalReport := models.NewFinalReport(test.UserId, test.ID, "")
 	// Concurrent apis Calls for AI Responses
-	startTime = time.Now()
+	startTime := time.Now()
 
-	content, err := apis.GenerateContentFromTextGCP(finalPrompt)
+	content, route, err := apis.GenerateSection(apis.LLMSectionReport, finalPrompt)
 
-	fmt.Println("Time taken by GCP Worker to generate response from gemini:", time.Since(startTime))
+	fmt.Println("Time taken by "+apis.LLMSectionReport+" section generation:", time.Since(startTime))
+
+	// Nothing is sent or saved without a narrative; RetryFailedReports tries again
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		if err := models.RecordReportFailure(test.ID, err.Error()); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+		return &MyError{
+			Code:    http.StatusBadGateway,
+			Message: "Failed to generate report",
+		}
+	}
 
 	link := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + test.ID.Hex()
 
 	go apis.SendBIG5ReportWithLink(user.Email, test.TestGiver, link)
+
+	// Minors' reports also go to the guardian who approved the test
+	if guardianEmail, guardianName, err := guardianEmailFor(test.ID); err == nil {
+		go apis.SendBIG5ReportWithLink(guardianEmail, guardianName, link)
+	}
 	fmt.Println("Time taken to send email:", time.Since(startTime))
 
 	// Save to db
 	finalReport.GeneratedContent = content
+	finalReport.Provider = route.Provider
+	finalReport.Model = route.Model
 	_, err = mgm.Coll(&models.FinalReport{}).InsertOne(c, finalReport)
 	if err != nil {
 		return &MyError{
@@ -90,60 +176,143 @@ func GenerateNewReport(c *gin.Context, test models.Test, user models.User) *MyEr
 	}
 	fmt.Println("Time taken to save final report in db:", time.Since(startTime))
 
-	// Save Report to Database
-	startTime = time.Now()
-	var docs []interface{}
-
-	for _, q := range newDbReports {
-		docs = append(docs, q)
+	if _, err := models.UpdateTestReportSent(test.ID, models.ReportSentDone); err != nil {
+		log.Println(":: Error : " + err.Error())
 	}
-	_, err = mgm.Coll(&models.Report{}).InsertMany(c, docs)
-	fmt.Println("Time taken to save Report in db:", time.Since(startTime))
+
+	// Whoever gifted the test hears it is done, but doesn't get the report
+	go NotifyGiftBuyer(test)
+
+	return nil
+}
+
+// MaxReportAttempts is how often a report is generated before it is left to support
+const MaxReportAttempts = 5
+
+// RetryFailedReports generates again the reports whose LLM call failed. Tests
+// no longer entitled to the full report, or that got one meanwhile, are skipped.
+func RetryFailedReports() error {
+	tests, err := models.FetchTestsWithFailedReports(MaxReportAttempts)
 	if err != nil {
-		return &MyError{
-			Code:    http.StatusInternalServerError,
-			Message: "Failed to insert questions",
+		return err
+	}
+
+	for _, test := range tests {
+		if ReportAccessFor(test) != ReportAccessFull {
+			continue
+		}
+		count, err := mgm.Coll(&models.FinalReport{}).CountDocuments(context.TODO(), bson.M{"testId": test.ID})
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			if _, err := models.UpdateTestReportSent(test.ID, models.ReportSentDone); err != nil {
+				log.Println(":: Error : " + err.Error())
+			}
+			continue
+		}
+
+		user := models.FetchUserUsingId(test.UserId)
+		if user.ID.IsZero() {
+			continue
+		}
+		if myErr := GenerateNewReport(context.TODO(), test, user); myErr != nil {
+			log.Printf(":: Warning : report of test %s failed again: %s", test.ID.Hex(), myErr.Message)
 		}
 	}
 
 	return nil
 }
 
+// promptProfileFor combines the test giver's details with the user's onboarding profile
+func promptProfileFor(test models.Test, user models.User) *apis.PromptProfile {
+	profile := &apis.PromptProfile{
+		Age:    test.TestGiverAge,
+		Gender: test.TestGiverGender,
+	}
+
+	if user.Profile != nil {
+		profile.EducationLevel = user.Profile.EducationLevel
+		profile.Stream = user.Profile.Stream
+		profile.Occupation = user.Profile.Occupation
+		profile.Goals = user.Profile.Goals
+		profile.City = user.Profile.City
+	}
+
+	return profile
+}
+
+// GeneratePaymentLink creates a hosted payment page with a provider that accepts currency
 func GeneratePaymentLink(
-	amount int,
+	amount int64,
+	currency string,
 	description string,
 	name string,
 	email string,
 	referenceID string,
-) (map[string]interface{}, error) {
+) (apis.PaymentProvider, *apis.PaymentLink, error) {
 
-	backendAapiDomain := os.Getenv("BACKEND_API_DOMAIN")
-	callbackPath := os.Getenv("CALLBACK_PATH")
+	// Razorpay only takes rupees by default; other currencies go to a provider that can charge them
+	provider, err := apis.PaymentProviderFor(currency)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	disableEmailSending := os.Getenv("DISABLE_EMAIL_SENDING")
+	// The callback route serves every provider; razorpay is assumed when none is named
+	callbackURL := os.Getenv("BACKEND_API_DOMAIN") + os.Getenv("CALLBACK_PATH")
+	if provider.Name() != "razorpay" {
+		callbackURL += "?provider=" + provider.Name()
+	}
 
-	currency := "INR"
-	acceptPartial := false
-	minPartialAmount := 0
-	expireBy := time.Now().AddDate(0, 0, 7).Unix() // Expire in 7 days
-	customerName := name
-	customerContact := ""
-	customerEmail := email
-	notifySMS := true
-	notifyEmail := true
-	if disableEmailSending == "true" {
-		notifyEmail = false
+	link, err := provider.CreatePaymentLink(apis.PaymentLinkRequest{
+		ReferenceId:   referenceID,
+		Amount:        amount,
+		Currency:      currency,
+		Description:   description,
+		CustomerName:  name,
+		CustomerEmail: email,
+		ExpireBy:      time.Now().Add(PaymentLinkValidity), // Expire in 7 days
+		CallbackURL:   callbackURL,
+		NotifyEmail:   os.Getenv("DISABLE_EMAIL_SENDING") != "true",
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-	reminderEnable := true
-	policyName := "Standard Policy"
-	callbackURL := backendAapiDomain + callbackPath
-	callbackMethod := "get"
-	upiLink := false
 
-	return apis.CreatePaymentLinkData(upiLink, amount, currency, acceptPartial, minPartialAmount, expireBy, referenceID, description, customerName, customerContact, customerEmail, notifySMS, notifyEmail, reminderEnable, policyName, callbackURL, callbackMethod)
+	return provider, link, nil
+}
+
+// CreateTestPaymentLink creates the payment link for a quote, opens its ledger entry and returns its short URL and link id
+func CreateTestPaymentLink(testId primitive.ObjectID, userId primitive.ObjectID, name string, email string, quote PriceQuote) (string, string, error) {
+	referenceId := ReferenceKindTest + "_" + testId.Hex()
+
+	description := quote.Description
+	if description == "" {
+		description = "For BIG 5 report generator"
+	}
 
+	provider, link, err := GeneratePaymentLink(quote.Amount, quote.Currency, description, name, email, referenceId)
+	if err != nil {
+		return "", "", err
+	}
+
+	payload, _ := json.Marshal(link.Raw)
+	payment := models.NewPayment(userId, testId, provider.Name(), link.Id, quote.Amount, quote.Currency, string(payload))
+	if err := mgm.Coll(payment).Create(payment); err != nil {
+		// The link is live already, so the ledger gap is logged rather than failing the submission
+		log.Println(":: Error : failed to record payment for link " + link.Id + ": " + err.Error())
+	}
+
+	return link.ShortURL, link.Id, nil
 }
 
+// ErrReportRevoked is returned for reports withdrawn after a refund
+var ErrReportRevoked = errors.New("access to this report has been revoked")
+
+// ErrReportPending is returned for paid reports whose narrative isn't written
+// yet, including ones waiting for RetryFailedReports
+var ErrReportPending = errors.New("the report is still being generated, try again later")
+
 // Start Generation Here
 func GetCompleteReportByTestId(testId string) (ReportResponse, error) {
 	oid, err := primitive.ObjectIDFromHex(testId)
@@ -165,10 +334,33 @@ func GetCompleteReportByTestId(testId string) (ReportResponse, error) {
 	if err != nil {
 		return ReportResponse{}, err
 	}
+	if test.ReportRevoked {
+		return ReportResponse{}, ErrReportRevoked
+	}
+
+	// The summary tier never reads the narrative, so it can't leak through the response
+	if ReportAccessFor(*test) == ReportAccessSummary {
+		for i := range reports {
+			reports[i].DomainSummary = ""
+			reports[i].GeneratedContent = map[string]interface{}{}
+		}
+		return ReportResponse{
+			Report:           reports,
+			AiReport:         []models.FinalReport{},
+			Name:             test.TestGiver,
+			Tier:             ReportAccessSummary,
+			UpgradeAvailable: canUpgradeReport(*test),
+		}, nil
+	}
+
 	var finalReports []models.FinalReport
 	if err := mgm.Coll(&models.FinalReport{}).SimpleFind(&finalReports, bson.M{"testId": oid}); err != nil {
 		return ReportResponse{}, err
 	}
+	// Paid tests are ready once their narrative is written
+	if len(finalReports) == 0 {
+		return ReportResponse{}, ErrReportPending
+	}
 
-	return ReportResponse{Report: reports, AiReport: finalReports, Name: test.TestGiver}, nil
+	return ReportResponse{Report: reports, AiReport: finalReports, Name: test.TestGiver, Tier: ReportAccessFull}, nil
 }
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FindOrCreateUser returns the user registered under email, creating one on first submission
+func FindOrCreateUser(name string, email string, gender string, age int) (*models.User, *MyError) {
+	var existingUsers []models.User
+	mgm.Coll(&models.User{}).SimpleFind(&existingUsers, bson.M{"email": email})
+
+	if len(existingUsers) == 0 {
+		newUser := models.NewUser(name, email, gender, age, "", "USER", "ACTIVE", "PENDING")
+		if err := mgm.Coll(newUser).Create(newUser); err != nil {
+			return nil, &MyError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to create user",
+			}
+		}
+		existingUsers = append(existingUsers, *newUser)
+	}
+
+	return &existingUsers[0], nil
+}
+
+// StoreScores saves the raw answers of a test
+func StoreScores(ctx context.Context, userId primitive.ObjectID, testId primitive.ObjectID, answers []response.Answers) *MyError {
+	var docs []interface{}
+	for _, answer := range answers {
+		questionId, err := primitive.ObjectIDFromHex(answer.Id)
+		if err != nil {
+			return &MyError{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid question ID",
+			}
+		}
+		docs = append(docs, *models.NewScore(userId, questionId, answer.Answer, testId))
+	}
+
+	if _, err := mgm.Coll(&models.Score{}).InsertMany(ctx, docs); err != nil {
+		return &MyError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to store scores",
+		}
+	}
+
+	return nil
+}
+
+// ValidateAnswers checks the answers of a submission before anything is
+// redeemed for it, so a bad question ID can't spend a coupon or credit
+func ValidateAnswers(answers []response.Answers) *MyError {
+	if len(answers) == 0 {
+		return &MyError{Code: http.StatusBadRequest, Message: "No answers submitted"}
+	}
+
+	seen := map[primitive.ObjectID]bool{}
+	var questionIds []primitive.ObjectID
+	for _, answer := range answers {
+		questionId, err := primitive.ObjectIDFromHex(answer.Id)
+		if err != nil {
+			return &MyError{Code: http.StatusBadRequest, Message: "Invalid question ID"}
+		}
+		if seen[questionId] {
+			return &MyError{Code: http.StatusBadRequest, Message: "Question " + answer.Id + " was answered twice"}
+		}
+		seen[questionId] = true
+		questionIds = append(questionIds, questionId)
+	}
+
+	count, err := mgm.Coll(&models.Question{}).CountDocuments(context.TODO(), bson.M{"_id": bson.M{"$in": questionIds}})
+	if err != nil {
+		return &MyError{Code: http.StatusInternalServerError, Message: "Failed to check answers"}
+	}
+	if count != int64(len(questionIds)) {
+		return &MyError{Code: http.StatusBadRequest, Message: "Invalid question ID"}
+	}
+
+	return nil
+}
+
+// DiscardSubmission removes what a failed submission already saved: its
+// payment link, scores, consents, coupon redemption, referral, guardian
+// request and the test itself. Whatever was redeemed for it is released by
+// the caller.
+func DiscardSubmission(c *gin.Context, testId primitive.ObjectID, paymentLinkId string) {
+	test := models.Test{ExternalPaymentId: paymentLinkId}
+	test.ID = testId
+	if myErr := cancelOldPaymentLink(c, test); myErr != nil {
+		log.Println(":: Error : failed to cancel payment link " + paymentLinkId + ": " + myErr.Message)
+	}
+
+	filter := bson.M{"testId": testId}
+	for _, model := range []mgm.Model{&models.Score{}, &models.Consent{}, &models.CouponRedemption{}, &models.Referral{}, &models.GuardianConsent{}} {
+		if _, err := mgm.Coll(model).DeleteMany(context.TODO(), filter); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+	}
+	if _, err := mgm.Coll(&models.Test{}).DeleteOne(context.TODO(), bson.M{"_id": testId}); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
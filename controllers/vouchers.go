@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"myproject/models"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxVoucherBatchSize caps how many codes one batch can hold
+const MaxVoucherBatchSize = 5000
+
+// voucherAlphabet leaves out characters that are easy to misread on paper, like 0/O and 1/I
+const voucherAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// randomCode returns length characters of voucherAlphabet, in groups of four
+// joined by dashes
+func randomCode(length int) (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(voucherAlphabet)))
+	for i := 0; i < length; i++ {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(voucherAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// generateVoucherCode returns a random code like K7QM-2XRP-D9HA
+func generateVoucherCode() (string, error) {
+	return randomCode(12)
+}
+
+// CreateVoucherBatch generates quantity single use codes for an organization
+func CreateVoucherBatch(organizationId primitive.ObjectID, name string, testName string, quantity int, validUntil time.Time, actor string) (*models.VoucherBatch, *MyError) {
+	if quantity < 1 || quantity > MaxVoucherBatchSize {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Quantity must be between 1 and 5000"}
+	}
+	if !validUntil.IsZero() && validUntil.Before(time.Now()) {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "validUntil must be in the future"}
+	}
+	if _, err := models.FetchOrganizationById(organizationId); err != nil {
+		return nil, &MyError{Code: http.StatusNotFound, Message: err.Error()}
+	}
+
+	// 60 random bits per code; duplicates within the batch are drawn again
+	codes := make([]string, 0, quantity)
+	seen := make(map[string]bool, quantity)
+	for len(codes) < quantity {
+		code, err := generateVoucherCode()
+		if err != nil {
+			return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to generate voucher codes"}
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+
+	batch := models.NewVoucherBatch(organizationId, name, testName, quantity, validUntil, actor)
+	if err := models.CreateVoucherBatch(batch, codes); err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to create voucher batch"}
+	}
+
+	models.RecordAudit(actor, "VOUCHER_BATCH_CREATED", batch.ID.Hex(), map[string]interface{}{
+		"organizationId": organizationId.Hex(),
+		"testName":       testName,
+		"quantity":       quantity,
+		"validUntil":     validUntil,
+	})
+
+	return batch, nil
+}
+
+// RedeemVoucher checks a voucher against its batch and uses it for the test.
+// Release it with ReleaseVoucher if the submission fails afterwards.
+func RedeemVoucher(code string, testName string, testId primitive.ObjectID, userId primitive.ObjectID) (*models.Voucher, *MyError) {
+	voucher, err := models.FetchVoucherByCode(code)
+	if err != nil {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid voucher code"}
+	}
+
+	batch, err := models.FetchVoucherBatchById(voucher.BatchId)
+	if err != nil || !batch.Active {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Invalid voucher code"}
+	}
+	if !batch.ValidUntil.IsZero() && time.Now().After(batch.ValidUntil) {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Voucher has expired"}
+	}
+	if voucher.TestName != testName {
+		return nil, &MyError{Code: http.StatusBadRequest, Message: "Voucher does not apply to this test"}
+	}
+
+	redeemed, err := models.RedeemVoucher(voucher.ID, testId, userId)
+	if err != nil {
+		if errors.Is(err, models.ErrVoucherUsed) {
+			return nil, &MyError{Code: http.StatusBadRequest, Message: "Voucher has already been used"}
+		}
+		return nil, &MyError{Code: http.StatusInternalServerError, Message: "Failed to redeem voucher"}
+	}
+
+	return redeemed, nil
+}
+
+// ReleaseVoucher gives back a voucher taken by a submission that failed
+func ReleaseVoucher(voucher *models.Voucher, testId primitive.ObjectID) {
+	if voucher == nil {
+		return
+	}
+	if err := models.ReleaseVoucher(voucher.ID, testId); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+}
+
+// WriteVoucherCSV writes a batch's codes and their state as CSV, for the
+// organization to hand out
+func WriteVoucherCSV(w io.Writer, vouchers []models.Voucher) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"code", "testName", "status", "redeemedAt"}); err != nil {
+		return err
+	}
+
+	for _, voucher := range vouchers {
+		redeemedAt := ""
+		if !voucher.RedeemedAt.IsZero() {
+			redeemedAt = voucher.RedeemedAt.In(indiaTime).Format(time.RFC3339)
+		}
+		if err := writer.Write([]string{voucher.Code, voucher.TestName, voucher.Status, redeemedAt}); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
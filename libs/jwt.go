@@ -0,0 +1,209 @@
+package libs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// KeyRing holds every HS256 secret we accept, identified by kid.
+// Only the active key signs new tokens; the others keep verifying
+// tokens issued before a rotation until they expire.
+type KeyRing struct {
+	ActiveKid string
+	Keys      map[string][]byte
+}
+
+var keyRing *KeyRing
+var keyRingOnce sync.Once
+
+// GetKeyRing loads the signing keys from the environment on first use.
+// JWT_SIGNING_KEYS is a comma separated list of kid:secret pairs and
+// JWT_ACTIVE_KID picks the signer. JWT_SECRET_KEY is still honoured as
+// the "default" kid so existing deployments keep working.
+func GetKeyRing() *KeyRing {
+	keyRingOnce.Do(func() {
+		keyRing = &KeyRing{Keys: map[string][]byte{}}
+
+		for _, pair := range strings.Split(os.Getenv("JWT_SIGNING_KEYS"), ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				continue
+			}
+			keyRing.Keys[parts[0]] = []byte(parts[1])
+		}
+
+		if legacySecret := os.Getenv("JWT_SECRET_KEY"); legacySecret != "" {
+			if _, ok := keyRing.Keys["default"]; !ok {
+				keyRing.Keys["default"] = []byte(legacySecret)
+			}
+		}
+
+		keyRing.ActiveKid = os.Getenv("JWT_ACTIVE_KID")
+		if _, ok := keyRing.Keys[keyRing.ActiveKid]; !ok {
+			keyRing.ActiveKid = "default"
+		}
+	})
+	return keyRing
+}
+
+// AccessClaims are the claims carried by an access token
+type AccessClaims struct {
+	Subject  string
+	Role     string
+	IssuedAt time.Time
+}
+
+// SignAccessToken creates a short lived access token signed with the active key
+func SignAccessToken(subject string, role string, duration time.Duration) (string, error) {
+	ring := GetKeyRing()
+	secret, ok := ring.Keys[ring.ActiveKid]
+	if !ok {
+		return "", fmt.Errorf("no signing key configured")
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":      subject,
+		"username": subject,
+		"role":     role,
+		"typ":      "access",
+		"iat":      now.Unix(),
+		"exp":      now.Add(duration).Unix(),
+	})
+	token.Header["kid"] = ring.ActiveKid
+
+	return token.SignedString(secret)
+}
+
+// ParseAccessToken verifies the signature against the key named by the
+// token's kid and returns its claims
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	ring := GetKeyRing()
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Ensure the token signing method is correct
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		// Tokens issued before key rotation was introduced carry no kid
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = "default"
+		}
+
+		secret, ok := ring.Keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	if typ, ok := claims["typ"].(string); ok && typ != "access" {
+		return nil, fmt.Errorf("not an access token")
+	}
+
+	accessClaims := &AccessClaims{}
+	accessClaims.Subject, _ = claims["sub"].(string)
+	if accessClaims.Subject == "" {
+		accessClaims.Subject, _ = claims["username"].(string)
+	}
+	accessClaims.Role, _ = claims["role"].(string)
+	if accessClaims.Role == "" {
+		// Legacy tokens were only ever issued to the admin
+		accessClaims.Role = "ADMIN"
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		accessClaims.IssuedAt = time.Unix(int64(iat), 0)
+	}
+
+	return accessClaims, nil
+}
+
+// GenerateOpaqueToken returns a random url safe token of n bytes, hex encoded
+func GenerateOpaqueToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// HashToken returns the sha256 hex digest used to store secrets at rest
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SignPurposeToken signs a short lived token for a single flow such as an
+// OAuth state or an emailed link. typ keeps tokens of one flow from being
+// replayed against another.
+func SignPurposeToken(typ string, claims map[string]interface{}, duration time.Duration) (string, error) {
+	ring := GetKeyRing()
+	secret, ok := ring.Keys[ring.ActiveKid]
+	if !ok {
+		return "", fmt.Errorf("no signing key configured")
+	}
+
+	mapClaims := jwt.MapClaims{}
+	for key, value := range claims {
+		mapClaims[key] = value
+	}
+	mapClaims["typ"] = typ
+	mapClaims["iat"] = time.Now().Unix()
+	mapClaims["exp"] = time.Now().Add(duration).Unix()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, mapClaims)
+	token.Header["kid"] = ring.ActiveKid
+
+	return token.SignedString(secret)
+}
+
+// ParsePurposeToken verifies a token created by SignPurposeToken for the same typ
+func ParsePurposeToken(typ string, tokenString string) (map[string]interface{}, error) {
+	ring := GetKeyRing()
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := ring.Keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if claims["typ"] != typ {
+		return nil, fmt.Errorf("unexpected token type")
+	}
+
+	return claims, nil
+}
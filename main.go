@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"myproject/controller"
 	"myproject/middlewares"
+	"myproject/models"
 	"myproject/routers"
 	"net/http"
 	"os"
@@ -35,9 +37,19 @@ func init() {
 	}
 
 	fmt.Println("::DB Connection Status : Successfully connected to MongoDB!")
+
+	if err := models.EnsureWebhookEventIndexes(); err != nil {
+		log.Println(":: Error : failed to create webhook event index: " + err.Error())
+	}
 }
 
 func main() {
+	// Maintenance commands, e.g. ./main export <email> <file.zip>
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	router := gin.Default()
 
 	// Apply Middlewares
@@ -50,12 +62,101 @@ func main() {
 
 	// Routes
 	router.POST("/auth", routers.Authenticate)
+	router.POST("/auth/refresh", routers.RefreshAccessToken)
+	router.POST("/auth/logout", routers.Logout)
+	router.POST("/auth/revoke-all", middlewares.RequireAuth(), routers.RevokeAllTokens)
+	router.GET("/auth/oidc/:provider/login", routers.HandleOIDCLogin)
+	router.GET("/auth/oidc/:provider/callback", routers.HandleOIDCCallback)
 	router.POST("/questions", routers.SubmitQuestions)
 	router.GET("/questions", routers.FetchAllQuestions)
-	router.POST("/submit", routers.HandleSubmission)
+	router.POST("/submit", middlewares.OptionalAuth(), routers.HandleSubmission)
+	router.GET("/gifts/invitation", routers.HandleGetGiftInvitation)
 	router.GET("/report", routers.HandleReportGeneration)
 	router.GET("/paymentCallback", routers.HandlePaymentCallback)
+	router.POST("/webhooks/:provider", routers.HandlePaymentWebhook)
+
+	// Checkout page of the fake payment provider, for local development
+	if os.Getenv("PAYMENT_FAKE_ENABLED") == "true" {
+		router.GET("/fake-payments/:id", routers.HandleFakeCheckout)
+	}
+	router.GET("/products", routers.HandleListProducts)
+	router.GET("/price", routers.HandleGetPrice)
 	router.GET("/report/:testId", routers.HandleBig5Report)
+	router.POST("/report/:testId/upgrade", routers.HandleUpgradeReport)
+	router.GET("/consents/documents", routers.FetchConsentDocuments)
+	router.GET("/guardian/consent/approve", routers.HandleGuardianApprove)
+	router.GET("/guardian/consent/decline", routers.HandleGuardianDecline)
+
+	// Partner routes authenticate with an X-API-Key header
+	partner := router.Group("/partner", middlewares.RequireAuth("PARTNER", "ADMIN"))
+	partner.POST("/tests", middlewares.RequireScope(models.ScopeTestsCreate), routers.HandlePartnerSubmission)
+	partner.GET("/reports/:testId", middlewares.RequireScope(models.ScopeReportsRead), routers.HandlePartnerReport)
+	partner.GET("/credits", middlewares.RequireScope(models.ScopeCreditsManage), routers.HandleGetPartnerCredits)
+	partner.GET("/credits/transactions", middlewares.RequireScope(models.ScopeCreditsManage), routers.HandleListPartnerCreditTransactions)
+	partner.POST("/credits/purchase", middlewares.RequireScope(models.ScopeCreditsManage), routers.HandlePurchasePartnerCredits)
+	partner.GET("/vouchers", middlewares.RequireScope(models.ScopeVouchersRead), routers.HandleListPartnerVoucherBatches)
+	partner.GET("/vouchers/:id/export", middlewares.RequireScope(models.ScopeVouchersRead), routers.HandleExportPartnerVoucherBatch)
+	partner.GET("/vouchers/:id/usage", middlewares.RequireScope(models.ScopeVouchersRead), routers.HandlePartnerVoucherBatchUsage)
+
+	// Dashboard routes for signed in users
+	me := router.Group("/me", middlewares.RequireAuth("USER"))
+	me.GET("/tests", routers.HandleListMyTests)
+	me.GET("/tests/:testId", routers.HandleGetMyTest)
+	me.GET("/tests/:testId/invoice", routers.HandleDownloadMyInvoice)
+	me.POST("/tests/:testId/payment-link", routers.HandleRegeneratePaymentLink)
+	me.POST("/tests/:testId/share-with-gifter", routers.HandleShareGiftReport)
+	me.GET("/gifts", routers.HandleListMyGifts)
+	me.POST("/gifts", routers.HandlePurchaseGift)
+	me.GET("/credits", routers.HandleGetMyCredits)
+	me.GET("/credits/transactions", routers.HandleListMyCreditTransactions)
+	me.POST("/credits/purchase", routers.HandlePurchaseMyCredits)
+	me.GET("/referrals", routers.HandleGetMyReferrals)
+	me.GET("/export", routers.HandleExportMyData)
+	me.POST("/erasure", routers.HandleRequestMyErasure)
+	me.POST("/erasure/cancel", routers.HandleCancelMyErasure)
+	me.GET("/profile", routers.HandleGetMyProfile)
+	me.PUT("/profile", routers.HandleUpdateMyProfile)
+	me.GET("/consents", routers.HandleListMyConsents)
+	me.POST("/consents", routers.HandleGrantMyConsents)
+	me.POST("/consents/withdraw", routers.HandleWithdrawMyConsents)
+
+	// Admin routes
+	admin := router.Group("/admin", middlewares.RequireAuth("ADMIN"))
+	admin.POST("/organizations", routers.CreateOrganization)
+	admin.GET("/organizations", routers.ListOrganizations)
+	admin.POST("/apikeys", routers.IssueApiKey)
+	admin.GET("/apikeys", routers.ListApiKeys)
+	admin.POST("/apikeys/:id/rotate", routers.RotateApiKey)
+	admin.POST("/apikeys/:id/revoke", routers.RevokeApiKey)
+	admin.GET("/users/:userId/export", routers.HandleAdminExportUser)
+	admin.POST("/consents/documents", routers.PublishConsentDocument)
+	admin.POST("/users/:userId/erasure", routers.HandleAdminErasure)
+	admin.GET("/payments", routers.HandleListPayments)
+	admin.GET("/payments/:id", routers.HandleGetPayment)
+	admin.POST("/tests/:testId/refund", routers.HandleAdminRefund)
+	admin.GET("/tests/:testId/invoice", routers.HandleAdminDownloadInvoice)
+	admin.GET("/reconciliation", routers.HandleGetReconciliationReport)
+	admin.POST("/reconciliation/run", routers.HandleRunReconciliation)
+	admin.POST("/coupons", routers.CreateCoupon)
+	admin.GET("/coupons", routers.ListCoupons)
+	admin.POST("/coupons/:id/deactivate", routers.DeactivateCoupon)
+	admin.GET("/coupons/:id/stats", routers.HandleCouponStats)
+	admin.POST("/products", routers.UpsertProduct)
+	admin.GET("/products", routers.ListAllProducts)
+	admin.POST("/products/:sku/active", routers.SetProductActive)
+	admin.POST("/credits/grant", routers.HandleGrantCredits)
+	admin.GET("/credits/:ownerType/:ownerId", routers.HandleGetWallet)
+	admin.POST("/payment-grants", routers.IssuePaymentGrant)
+	admin.GET("/payment-grants", routers.ListPaymentGrants)
+	admin.POST("/payment-grants/:id/revoke", routers.RevokePaymentGrant)
+	admin.GET("/referrals", routers.ListReferrals)
+	admin.POST("/referrals/:id/approve", routers.ApproveReferral)
+	admin.POST("/referrals/:id/reject", routers.RejectReferral)
+	admin.POST("/vouchers", routers.CreateVoucherBatch)
+	admin.GET("/vouchers", routers.ListVoucherBatches)
+	admin.GET("/vouchers/:id/export", routers.ExportVoucherBatch)
+	admin.GET("/vouchers/:id/usage", routers.HandleVoucherBatchUsage)
+	admin.POST("/vouchers/:id/deactivate", routers.DeactivateVoucherBatch)
 
 	// Health check route
 	router.GET("/health", func(c *gin.Context) {
@@ -72,6 +173,12 @@ func main() {
 		router.POST("/paymentLinkFetch", routers.PaymentLinkFetch)
 	}
 
+	// Background jobs
+	controller.StartJob("erasure", time.Hour, controller.ProcessDueErasureRequests)
+	controller.StartJob("reconciliation", controller.ReconcileInterval, controller.ReconcilePayments)
+	controller.StartJob("credit-expiry", time.Hour, controller.ExpireCredits)
+	controller.StartJob("report-retry", 15*time.Minute, controller.RetryFailedReports)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080" // fallback to port 8080 if not set
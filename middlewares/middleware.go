@@ -3,20 +3,18 @@ package middlewares
 import (
 	"fmt"
 	"log"
+	"myproject/libs"
+	"myproject/models"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/time/rate"
 )
 
-// JWT secret key
-var jwtSecret = []byte(os.Getenv("JWT_SECRET_KEY"))
-
 // Rate Limiting Middleware
 func RateLimitingMiddleware() gin.HandlerFunc {
 	limiter := rate.NewLimiter(1, 5) // 1 request per second with a burst of 5
@@ -50,7 +48,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	return cors.New(cors.Config{
 		AllowOrigins:     allowOriginsSlice,
 		AllowMethods:     []string{"PUT", "PATCH", "GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -98,47 +96,188 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 		}
 
 		if !excludedPath {
-			// Retrieve Authorization header
-			authHeader := c.GetHeader("Authorization")
-
-			if authHeader == "" {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
+			principal, status, err := authenticateRequest(c)
+			if err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
 				c.Abort()
 				return
 			}
+			c.Set(principalKey, principal)
 
-			log.Println("AUTH HEADER:" + authHeader)
+			// Proceed with the request
+			c.Next()
+		}
+	}
 
-			// Check if the token is in the correct format
-			if !strings.HasPrefix(authHeader, "Bearer ") {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
-				c.Abort()
+}
+
+// Principal is the authenticated caller attached to the request context
+type Principal struct {
+	Subject        string
+	Role           string
+	Scopes         []string // Only set for API keys
+	OrganizationId string   // Only set for API keys
+}
+
+// HasScope reports whether the caller may use scope. Admins hold every scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p.Role == "ADMIN" {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+const principalKey = "principal"
+
+// authenticateBearer validates an "Authorization: Bearer" header and
+// rejects tokens issued before the subject's last revoke-all
+func authenticateBearer(authHeader string) (*Principal, error) {
+	// Check if the token is in the correct format
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, fmt.Errorf("Invalid token format")
+	}
+
+	// Extract the token from the "Bearer " prefix
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := libs.ParseAccessToken(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token")
+	}
+
+	revokedBefore, err := models.FetchRevokedBefore(claims.Subject)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return nil, fmt.Errorf("Invalid token")
+	}
+	if issuedBeforeRevocation(claims.IssuedAt, revokedBefore) {
+		return nil, fmt.Errorf("Token has been revoked")
+	}
+
+	return &Principal{Subject: claims.Subject, Role: claims.Role}, nil
+}
+
+// issuedBeforeRevocation reports whether a token predates a revoke-all. The
+// revocation is stored rounded up to the next second, so every token signed
+// up to it is rejected despite iat's one second precision.
+func issuedBeforeRevocation(issuedAt time.Time, revokedBefore time.Time) bool {
+	if revokedBefore.IsZero() {
+		return false
+	}
+	return issuedAt.Before(revokedBefore)
+}
+
+// authenticateApiKey validates an "X-API-Key" header and counts the request against the key's quota
+func authenticateApiKey(apiKey string) (*Principal, int, error) {
+	key, err := models.FetchActiveApiKeyByHash(libs.HashToken(apiKey))
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Invalid API key")
+	}
+
+	if _, err := models.ConsumeApiKeyQuota(key); err != nil {
+		if err == models.ErrQuotaExceeded {
+			return nil, http.StatusTooManyRequests, fmt.Errorf("API key monthly quota exceeded")
+		}
+		log.Println(":: Error : " + err.Error())
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to validate API key")
+	}
+
+	return &Principal{
+		Subject:        key.ID.Hex(),
+		Role:           "PARTNER",
+		Scopes:         key.Scopes,
+		OrganizationId: key.OrganizationId.Hex(),
+	}, http.StatusOK, nil
+}
+
+// authenticateRequest accepts either a partner API key or a bearer token
+func authenticateRequest(c *gin.Context) (*Principal, int, error) {
+	if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+		return authenticateApiKey(apiKey)
+	}
+
+	// Retrieve Authorization header
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		return nil, http.StatusUnauthorized, fmt.Errorf("No token provided")
+	}
+
+	principal, err := authenticateBearer(authHeader)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	return principal, http.StatusOK, nil
+}
+
+// RequireAuth protects a single route or group. When roles are given the
+// caller must hold one of them.
+func RequireAuth(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, status, err := authenticateRequest(c)
+		if err != nil {
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(roles) > 0 {
+			allowed := false
+			for _, role := range roles {
+				if strings.EqualFold(principal.Role, role) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 				return
 			}
+		}
 
-			// Extract the token from the "Bearer " prefix
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			log.Println("TOKEN STRING:" + tokenString)
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				// Ensure the token signing method is correct
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-				}
-				return jwtSecret, nil
-			})
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
 
-			// Handle errors or invalid token
-			if err != nil || !token.Valid {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-				c.Abort()
+// OptionalAuth attaches the caller when the request carries credentials and
+// lets anonymous requests through. Invalid credentials are still rejected.
+func OptionalAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("X-API-Key") != "" || c.GetHeader("Authorization") != "" {
+			principal, status, err := authenticateRequest(c)
+			if err != nil {
+				c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
 				return
 			}
+			c.Set(principalKey, principal)
+		}
+		c.Next()
+	}
+}
 
-			// Proceed with the request
-			c.Next()
+// RequireScope must run after RequireAuth and rejects callers without scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := CurrentPrincipal(c)
+		if principal == nil || !principal.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing scope " + scope})
+			return
 		}
+		c.Next()
 	}
+}
 
+// CurrentPrincipal returns the caller set by RequireAuth or JWTAuthMiddleware
+func CurrentPrincipal(c *gin.Context) *Principal {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	principal, _ := value.(*Principal)
+	return principal
 }
 
 // Error Handling Middleware
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssuedBeforeRevocation(t *testing.T) {
+	// As stored for a revoke-all at 10:00:00.400
+	revokedBefore := time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		issuedAt   time.Time
+		revoked    time.Time
+		wantReject bool
+	}{
+		{"never revoked", revokedBefore.Add(-time.Hour), time.Time{}, false},
+		{"issued a second earlier", revokedBefore.Add(-2 * time.Second), revokedBefore, true},
+		{"issued in the same second", revokedBefore.Add(-time.Second), revokedBefore, true},
+		{"issued the next second", revokedBefore, revokedBefore, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := issuedBeforeRevocation(tt.issuedAt, tt.revoked); got != tt.wantReject {
+				t.Errorf("issuedBeforeRevocation(%v, %v) = %v, want %v", tt.issuedAt, tt.revoked, got, tt.wantReject)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scopes a partner API key can carry
+const (
+	ScopeTestsCreate   = "tests:create"
+	ScopeReportsRead   = "reports:read"
+	ScopeCreditsManage = "credits:manage"
+	ScopeVouchersRead  = "vouchers:read"
+)
+
+// ApiKey is a partner credential. The key itself is shown once at issue
+// time; only its sha256 hash and a short display prefix are stored.
+type ApiKey struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	OrganizationId primitive.ObjectID `json:"organizationId" bson:"organizationId"`
+	Name           string             `json:"name" bson:"name"`
+	Prefix         string             `json:"prefix" bson:"prefix"`
+	KeyHash        string             `json:"-" bson:"keyHash"`
+	Scopes         []string           `json:"scopes" bson:"scopes"`
+	MonthlyQuota   int                `json:"monthlyQuota" bson:"monthlyQuota"` // 0 means unlimited
+	UsagePeriod    string             `json:"usagePeriod" bson:"usagePeriod"`   // YYYY-MM the counter belongs to
+	UsageCount     int                `json:"usageCount" bson:"usageCount"`
+	TotalUsage     int                `json:"totalUsage" bson:"totalUsage"`
+	Status         string             `json:"status" bson:"status"` // ACTIVE, REVOKED
+	LastUsedAt     *time.Time         `json:"lastUsedAt" bson:"lastUsedAt"`
+	RevokedAt      *time.Time         `json:"revokedAt" bson:"revokedAt"`
+	RotatedFrom    primitive.ObjectID `json:"rotatedFrom,omitempty" bson:"rotatedFrom,omitempty"`
+}
+
+func NewApiKey(organizationId primitive.ObjectID, name string, prefix string, keyHash string, scopes []string, monthlyQuota int) *ApiKey {
+	return &ApiKey{
+		OrganizationId: organizationId,
+		Name:           name,
+		Prefix:         prefix,
+		KeyHash:        keyHash,
+		Scopes:         scopes,
+		MonthlyQuota:   monthlyQuota,
+		UsagePeriod:    time.Now().UTC().Format("2006-01"),
+		Status:         "ACTIVE",
+	}
+}
+
+// HasScope reports whether the key was granted scope
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func FetchApiKeyById(id primitive.ObjectID) (*ApiKey, error) {
+	var key ApiKey
+
+	err := mgm.Coll(&ApiKey{}).First(bson.M{"_id": id}, &key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("api key with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func FetchActiveApiKeyByHash(keyHash string) (*ApiKey, error) {
+	var key ApiKey
+
+	err := mgm.Coll(&ApiKey{}).First(bson.M{"keyHash": keyHash, "status": "ACTIVE"}, &key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("api key not found")
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// ErrQuotaExceeded is returned when a key has used up its monthly quota
+var ErrQuotaExceeded = errors.New("monthly quota exceeded")
+
+// ConsumeApiKeyQuota counts one request against the key. The counter is
+// reset lazily when a new month starts, and the increment is conditional on
+// the quota so concurrent requests can't overshoot it.
+func ConsumeApiKeyQuota(key *ApiKey) (*ApiKey, error) {
+	period := time.Now().UTC().Format("2006-01")
+
+	if key.UsagePeriod != period {
+		_, err := mgm.Coll(&ApiKey{}).UpdateOne(
+			context.TODO(),
+			bson.M{"_id": key.ID, "usagePeriod": bson.M{"$ne": period}},
+			bson.M{"$set": bson.M{"usagePeriod": period, "usageCount": 0}},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	filter := bson.M{"_id": key.ID, "status": "ACTIVE"}
+	if key.MonthlyQuota > 0 {
+		filter["usageCount"] = bson.M{"$lt": key.MonthlyQuota}
+	}
+
+	var updated ApiKey
+	err := mgm.Coll(&ApiKey{}).FindOneAndUpdate(
+		context.TODO(),
+		filter,
+		bson.M{
+			"$inc": bson.M{"usageCount": 1, "totalUsage": 1},
+			"$set": bson.M{"lastUsedAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrQuotaExceeded
+		}
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func RevokeApiKey(id primitive.ObjectID) (*ApiKey, error) {
+	var key ApiKey
+
+	err := mgm.Coll(&ApiKey{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"status": "REVOKED", "revokedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&key)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no document found with the given ID")
+		}
+		return nil, err
+	}
+
+	return &key, nil
+}
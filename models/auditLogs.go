@@ -0,0 +1,36 @@
+package models
+
+import (
+	"log"
+
+	"github.com/kamva/mgm/v3"
+)
+
+// AuditLog is an append only record of a sensitive action
+type AuditLog struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Actor   string                 `json:"actor" bson:"actor"`     // Subject of the caller, or "system"
+	Action  string                 `json:"action" bson:"action"`   // e.g. DATA_EXPORTED, ERASURE_COMPLETED
+	Subject string                 `json:"subject" bson:"subject"` // Id of the record acted on
+	Details map[string]interface{} `json:"details" bson:"details"`
+}
+
+func NewAuditLog(actor string, action string, subject string, details map[string]interface{}) *AuditLog {
+	return &AuditLog{
+		Actor:   actor,
+		Action:  action,
+		Subject: subject,
+		Details: details,
+	}
+}
+
+// RecordAudit stores an audit entry. Failures are logged rather than
+// returned so auditing never blocks the action itself.
+func RecordAudit(actor string, action string, subject string, details map[string]interface{}) {
+	entry := NewAuditLog(actor, action, subject, details)
+	if err := mgm.Coll(entry).Create(entry); err != nil {
+		log.Printf(":: Error : failed to record audit %s for %s: %v", action, subject, err)
+	}
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Consent document types
+const (
+	ConsentTerms     = "TERMS"
+	ConsentPrivacy   = "PRIVACY"
+	ConsentResearch  = "RESEARCH"
+	ConsentMarketing = "MARKETING"
+)
+
+// ConsentDocument is one published version of a consent text
+type ConsentDocument struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Type     string `json:"type" bson:"type"`
+	Version  string `json:"version" bson:"version"`
+	Title    string `json:"title" bson:"title"`
+	Url      string `json:"url" bson:"url"`           // Where the webapp shows the full text
+	Required bool   `json:"required" bson:"required"` // Must be accepted to submit a test
+	Active   bool   `json:"active" bson:"active"`     // Only the latest version of a type is active
+}
+
+// Consent records that a user accepted a document version
+type Consent struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	UserId      primitive.ObjectID `json:"userId" bson:"userId"`
+	TestId      primitive.ObjectID `json:"testId,omitempty" bson:"testId,omitempty"`
+	Type        string             `json:"type" bson:"type"`
+	Version     string             `json:"version" bson:"version"`
+	Source      string             `json:"source" bson:"source"` // submit, signup, dashboard, partner
+	IpAddress   string             `json:"ipAddress" bson:"ipAddress"`
+	UserAgent   string             `json:"userAgent" bson:"userAgent"`
+	AcceptedAt  time.Time          `json:"acceptedAt" bson:"acceptedAt"`
+	WithdrawnAt *time.Time         `json:"withdrawnAt" bson:"withdrawnAt"`
+}
+
+func NewConsentDocument(consentType string, version string, title string, url string, required bool) *ConsentDocument {
+	return &ConsentDocument{
+		Type:     consentType,
+		Version:  version,
+		Title:    title,
+		Url:      url,
+		Required: required,
+		Active:   true,
+	}
+}
+
+func NewConsent(userId primitive.ObjectID, testId primitive.ObjectID, consentType string, version string, source string, ipAddress string, userAgent string) *Consent {
+	return &Consent{
+		UserId:     userId,
+		TestId:     testId,
+		Type:       consentType,
+		Version:    version,
+		Source:     source,
+		IpAddress:  ipAddress,
+		UserAgent:  userAgent,
+		AcceptedAt: time.Now(),
+	}
+}
+
+func FetchActiveConsentDocuments() ([]ConsentDocument, error) {
+	documents := []ConsentDocument{}
+	err := mgm.Coll(&ConsentDocument{}).SimpleFind(&documents, bson.M{"active": true})
+	return documents, err
+}
+
+// DeactivateConsentDocuments retires every version of a type before a new one is published
+func DeactivateConsentDocuments(consentType string) error {
+	_, err := mgm.Coll(&ConsentDocument{}).UpdateMany(
+		context.TODO(),
+		bson.M{"type": consentType, "active": true},
+		bson.M{"$set": bson.M{"active": false}},
+	)
+	return err
+}
+
+// WithdrawableConsentTypes are the optional consents a user can take back.
+// Terms and privacy are needed to keep an account; leaving them means erasure.
+var WithdrawableConsentTypes = []string{ConsentResearch, ConsentMarketing}
+
+// IsWithdrawableConsent reports whether consentType may be withdrawn
+func IsWithdrawableConsent(consentType string) bool {
+	for _, withdrawable := range WithdrawableConsentTypes {
+		if consentType == withdrawable {
+			return true
+		}
+	}
+	return false
+}
+
+// WithdrawConsents marks every standing consent of the given types withdrawn
+func WithdrawConsents(userId primitive.ObjectID, types []string) (int64, error) {
+	result, err := mgm.Coll(&Consent{}).UpdateMany(
+		context.TODO(),
+		bson.M{"userId": userId, "type": bson.M{"$in": types}, "withdrawnAt": nil},
+		bson.M{"$set": bson.M{"withdrawnAt": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// HasActiveConsent reports whether the user has a standing, not withdrawn
+// consent of the given type. Research exports and marketing email must
+// check this before using a user's data.
+func HasActiveConsent(userId primitive.ObjectID, consentType string) bool {
+	var consent Consent
+	err := mgm.Coll(&Consent{}).First(bson.M{"userId": userId, "type": consentType, "withdrawnAt": nil}, &consent)
+	return err == nil
+}
@@ -0,0 +1,19 @@
+package models
+
+import "testing"
+
+func TestIsWithdrawableConsent(t *testing.T) {
+	cases := map[string]bool{
+		ConsentResearch:  true,
+		ConsentMarketing: true,
+		ConsentTerms:     false,
+		ConsentPrivacy:   false,
+		"UNKNOWN":        false,
+	}
+
+	for consentType, want := range cases {
+		if got := IsWithdrawableConsent(consentType); got != want {
+			t.Errorf("IsWithdrawableConsent(%s) = %v, want %v", consentType, got, want)
+		}
+	}
+}
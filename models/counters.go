@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Counter is a named gapless sequence, e.g. invoice numbers of a financial year
+type Counter struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Name  string `json:"name" bson:"name"`
+	Value int64  `json:"value" bson:"value"`
+}
+
+// NextSequence atomically increments and returns the named counter, starting at 1
+func NextSequence(name string) (int64, error) {
+	var counter Counter
+
+	err := mgm.Coll(&Counter{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"name": name},
+		bson.M{"$inc": bson.M{"value": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.Value, nil
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Discount types of a coupon
+const (
+	CouponPercent = "PERCENT" // DiscountValue is a percentage, 1 to 100
+	CouponFlat    = "FLAT"    // DiscountValue is an amount in minor units
+)
+
+// Coupon is a promo code that discounts the report price at submission
+type Coupon struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Code           string    `json:"code" bson:"code"` // Stored upper case
+	Description    string    `json:"description" bson:"description"`
+	DiscountType   string    `json:"discountType" bson:"discountType"`
+	DiscountValue  int64     `json:"discountValue" bson:"discountValue"`
+	Currency       string    `json:"currency,omitempty" bson:"currency,omitempty"` // FLAT only, the currency of DiscountValue
+	ValidFrom      time.Time `json:"validFrom" bson:"validFrom"`
+	ValidUntil     time.Time `json:"validUntil,omitempty" bson:"validUntil,omitempty"` // Zero means no end
+	MaxRedemptions int64     `json:"maxRedemptions" bson:"maxRedemptions"`             // 0 means unlimited
+	PerUserLimit   int64     `json:"perUserLimit" bson:"perUserLimit"`                 // 0 means unlimited
+	TestNames      []string  `json:"testNames" bson:"testNames"`                       // Empty means every test
+	Redemptions    int64     `json:"redemptions" bson:"redemptions"`
+	Active         bool      `json:"active" bson:"active"`
+}
+
+func NewCoupon(code string, description string, discountType string, discountValue int64, currency string, validFrom time.Time, validUntil time.Time, maxRedemptions int64, perUserLimit int64, testNames []string) *Coupon {
+	return &Coupon{
+		Code:           NormalizeCouponCode(code),
+		Description:    description,
+		DiscountType:   discountType,
+		DiscountValue:  discountValue,
+		Currency:       currency,
+		ValidFrom:      validFrom,
+		ValidUntil:     validUntil,
+		MaxRedemptions: maxRedemptions,
+		PerUserLimit:   perUserLimit,
+		TestNames:      testNames,
+		Active:         true,
+	}
+}
+
+// NormalizeCouponCode makes codes case and whitespace insensitive
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// AppliesTo reports whether the coupon may be used on the given test
+func (coupon *Coupon) AppliesTo(testName string) bool {
+	if len(coupon.TestNames) == 0 {
+		return true
+	}
+	for _, name := range coupon.TestNames {
+		if name == testName {
+			return true
+		}
+	}
+	return false
+}
+
+// CouponLegacyCurrency is the currency of FLAT coupons created before they had one
+const CouponLegacyCurrency = "INR"
+
+// AppliesToCurrency reports whether the coupon may discount a price in
+// currency. Percentages apply anywhere, flat amounts only in their own currency.
+func (coupon *Coupon) AppliesToCurrency(currency string) bool {
+	if coupon.DiscountType != CouponFlat {
+		return true
+	}
+	couponCurrency := coupon.Currency
+	if couponCurrency == "" {
+		couponCurrency = CouponLegacyCurrency
+	}
+	return strings.EqualFold(couponCurrency, currency)
+}
+
+// Discount is how much the coupon takes off price, never more than the price
+func (coupon *Coupon) Discount(price int64) int64 {
+	discount := coupon.DiscountValue
+	if coupon.DiscountType == CouponPercent {
+		discount = price * coupon.DiscountValue / 100
+	}
+	if discount > price {
+		return price
+	}
+	return discount
+}
+
+func FetchCouponByCode(code string) (*Coupon, error) {
+	var coupon Coupon
+
+	err := mgm.Coll(&Coupon{}).First(bson.M{"code": NormalizeCouponCode(code)}, &coupon)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("coupon %s not found", code)
+		}
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+func FetchCouponById(id primitive.ObjectID) (*Coupon, error) {
+	var coupon Coupon
+
+	err := mgm.Coll(&Coupon{}).FindByID(id, &coupon)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("coupon with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// ErrCouponExhausted is returned once a coupon reached its max redemptions
+var ErrCouponExhausted = errors.New("coupon has been fully redeemed")
+
+// ReserveCouponRedemption takes one redemption slot, atomically respecting MaxRedemptions
+func ReserveCouponRedemption(couponId primitive.ObjectID) error {
+	filter := bson.M{
+		"_id":    couponId,
+		"active": true,
+		"$or": bson.A{
+			bson.M{"maxRedemptions": 0},
+			bson.M{"$expr": bson.M{"$lt": bson.A{"$redemptions", "$maxRedemptions"}}},
+		},
+	}
+
+	result, err := mgm.Coll(&Coupon{}).UpdateOne(context.TODO(), filter, bson.M{"$inc": bson.M{"redemptions": 1}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrCouponExhausted
+	}
+	return nil
+}
+
+// ReleaseCouponRedemption gives back a slot taken for a submission that failed
+func ReleaseCouponRedemption(couponId primitive.ObjectID) error {
+	_, err := mgm.Coll(&Coupon{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": couponId, "redemptions": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"redemptions": -1}},
+	)
+	return err
+}
+
+// DeactivateCoupon stops a coupon from being redeemed
+func DeactivateCoupon(couponId primitive.ObjectID) (*Coupon, error) {
+	var coupon Coupon
+
+	err := mgm.Coll(&Coupon{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": couponId},
+		bson.M{"$set": bson.M{"active": false}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&coupon)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no document found with the given ID")
+		}
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// CouponRedemption records a coupon applied to a test, for limits and analytics
+type CouponRedemption struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	CouponId       primitive.ObjectID `json:"couponId" bson:"couponId"`
+	Code           string             `json:"code" bson:"code"`
+	UserId         primitive.ObjectID `json:"userId" bson:"userId"`
+	TestId         primitive.ObjectID `json:"testId" bson:"testId"`
+	TestName       string             `json:"testName" bson:"testName"`
+	OriginalAmount int64              `json:"originalAmount" bson:"originalAmount"`
+	DiscountAmount int64              `json:"discountAmount" bson:"discountAmount"`
+	FinalAmount    int64              `json:"finalAmount" bson:"finalAmount"`
+	Currency       string             `json:"currency" bson:"currency"`
+}
+
+func NewCouponRedemption(coupon *Coupon, userId primitive.ObjectID, testId primitive.ObjectID, testName string, originalAmount int64, discountAmount int64, currency string) *CouponRedemption {
+	return &CouponRedemption{
+		CouponId:       coupon.ID,
+		Code:           coupon.Code,
+		UserId:         userId,
+		TestId:         testId,
+		TestName:       testName,
+		OriginalAmount: originalAmount,
+		DiscountAmount: discountAmount,
+		FinalAmount:    originalAmount - discountAmount,
+		Currency:       currency,
+	}
+}
+
+// CountUserCouponRedemptions is how many times a user already used a coupon
+func CountUserCouponRedemptions(couponId primitive.ObjectID, userId primitive.ObjectID) (int64, error) {
+	return mgm.Coll(&CouponRedemption{}).CountDocuments(context.TODO(), bson.M{"couponId": couponId, "userId": userId})
+}
+
+// CouponStats summarises the redemptions of a coupon
+type CouponStats struct {
+	Redemptions    int64 `json:"redemptions" bson:"redemptions"`
+	UniqueUsers    int64 `json:"uniqueUsers" bson:"uniqueUsers"`
+	TotalDiscount  int64 `json:"totalDiscount" bson:"totalDiscount"`
+	TotalCollected int64 `json:"totalCollected" bson:"totalCollected"`
+}
+
+func FetchCouponStats(couponId primitive.ObjectID) (*CouponStats, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"couponId": couponId}},
+		bson.M{"$group": bson.M{
+			"_id":            nil,
+			"redemptions":    bson.M{"$sum": 1},
+			"users":          bson.M{"$addToSet": "$userId"},
+			"totalDiscount":  bson.M{"$sum": "$discountAmount"},
+			"totalCollected": bson.M{"$sum": "$finalAmount"},
+		}},
+		bson.M{"$project": bson.M{
+			"redemptions":    1,
+			"uniqueUsers":    bson.M{"$size": "$users"},
+			"totalDiscount":  1,
+			"totalCollected": 1,
+		}},
+	}
+
+	cursor, err := mgm.Coll(&CouponRedemption{}).Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []CouponStats
+	if err := cursor.All(context.TODO(), &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return &CouponStats{}, nil
+	}
+	return &results[0], nil
+}
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestCouponAppliesToCurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		currency string
+		want     bool
+	}{
+		{"flat in its currency", Coupon{DiscountType: CouponFlat, DiscountValue: 500, Currency: "USD"}, "USD", true},
+		{"flat in another currency", Coupon{DiscountType: CouponFlat, DiscountValue: 500, Currency: "USD"}, "INR", false},
+		{"flat currency case", Coupon{DiscountType: CouponFlat, DiscountValue: 500, Currency: "usd"}, "USD", true},
+		{"legacy flat in rupees", Coupon{DiscountType: CouponFlat, DiscountValue: 50000}, "INR", true},
+		{"legacy flat in dollars", Coupon{DiscountType: CouponFlat, DiscountValue: 50000}, "USD", false},
+		{"percent in any currency", Coupon{DiscountType: CouponPercent, DiscountValue: 20}, "EUR", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.coupon.AppliesToCurrency(tt.currency); got != tt.want {
+				t.Errorf("AppliesToCurrency(%s) = %v, want %v", tt.currency, got, tt.want)
+			}
+		})
+	}
+}
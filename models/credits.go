@@ -0,0 +1,371 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Who can hold prepaid credits
+const (
+	CreditOwnerUser         = "USER"
+	CreditOwnerOrganization = "ORGANIZATION"
+)
+
+// Where a lot of credits came from
+const (
+	CreditSourcePurchase = "PURCHASE"
+	CreditSourceGrant    = "GRANT"
+	CreditSourceReferral = "REFERRAL" // Reward for a referred user's payment
+)
+
+// Credit transaction types. Credits are negative for CONSUME and EXPIRE.
+const (
+	CreditTxPurchase = "PURCHASE"
+	CreditTxGrant    = "GRANT"
+	CreditTxConsume  = "CONSUME"
+	CreditTxRestore  = "RESTORE" // A consumed credit given back, e.g. when the test could not be saved
+	CreditTxExpire   = "EXPIRE"
+)
+
+// Credit purchase states
+const (
+	CreditPurchasePending = "PENDING"
+	CreditPurchasePaid    = "PAID"
+	CreditPurchaseExpired = "EXPIRED"
+)
+
+// ErrNoCredits is returned when a wallet has no usable credit for a test
+var ErrNoCredits = errors.New("no credits available")
+
+// CreditOwner identifies a wallet: a user or an organization
+type CreditOwner struct {
+	Type string             `json:"type" bson:"type"`
+	Id   primitive.ObjectID `json:"id" bson:"id"`
+}
+
+func ownerFilter(owner CreditOwner) bson.M {
+	return bson.M{"owner.type": owner.Type, "owner.id": owner.Id}
+}
+
+// CreditLot is a batch of credits with one expiry, from a purchase or a grant.
+// A wallet's balance is the remaining credits of its unexpired lots.
+type CreditLot struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Owner      CreditOwner        `json:"owner" bson:"owner"`
+	TestName   string             `json:"testName" bson:"testName"` // Credits are spent on tests of this name
+	Credits    int                `json:"credits" bson:"credits"`
+	Remaining  int                `json:"remaining" bson:"remaining"`
+	ExpiresAt  time.Time          `json:"expiresAt" bson:"expiresAt"`
+	Source     string             `json:"source" bson:"source"`
+	PurchaseId primitive.ObjectID `json:"purchaseId,omitempty" bson:"purchaseId,omitempty"`
+	GrantedBy  string             `json:"grantedBy,omitempty" bson:"grantedBy,omitempty"`
+	Note       string             `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+func NewCreditLot(owner CreditOwner, testName string, credits int, expiresAt time.Time, source string) *CreditLot {
+	return &CreditLot{
+		Owner:     owner,
+		TestName:  testName,
+		Credits:   credits,
+		Remaining: credits,
+		ExpiresAt: expiresAt,
+		Source:    source,
+	}
+}
+
+// CreditTransaction is one movement in a wallet's history
+type CreditTransaction struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Owner    CreditOwner        `json:"owner" bson:"owner"`
+	LotId    primitive.ObjectID `json:"lotId" bson:"lotId"`
+	Type     string             `json:"type" bson:"type"`
+	TestName string             `json:"testName" bson:"testName"`
+	Credits  int                `json:"credits" bson:"credits"`
+	TestId   primitive.ObjectID `json:"testId,omitempty" bson:"testId,omitempty"`
+	Actor    string             `json:"actor" bson:"actor"`
+	Note     string             `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+func recordCreditTransaction(lot *CreditLot, txType string, credits int, testId primitive.ObjectID, actor string, note string) {
+	transaction := &CreditTransaction{
+		Owner:    lot.Owner,
+		LotId:    lot.ID,
+		Type:     txType,
+		TestName: lot.TestName,
+		Credits:  credits,
+		TestId:   testId,
+		Actor:    actor,
+		Note:     note,
+	}
+	if err := mgm.Coll(transaction).Create(transaction); err != nil {
+		// The lot is the source of truth; a missing history line is logged, not fatal
+		log.Printf(":: Error : failed to record credit %s on lot %s: %v", txType, lot.ID.Hex(), err)
+	}
+}
+
+// GrantCreditLot stores a lot given by an admin and records the grant
+func GrantCreditLot(lot *CreditLot, actor string) error {
+	lot.GrantedBy = actor
+	if err := mgm.Coll(lot).Create(lot); err != nil {
+		return err
+	}
+	recordCreditTransaction(lot, CreditTxGrant, lot.Credits, primitive.NilObjectID, actor, lot.Note)
+	return nil
+}
+
+// AddPurchasedCreditLot stores the lot bought by a purchase. It is idempotent:
+// a retried payment confirmation finds the existing lot and returns false.
+func AddPurchasedCreditLot(lot *CreditLot) (*CreditLot, bool, error) {
+	now := time.Now()
+	lot.ID = primitive.NewObjectID()
+	lot.CreatedAt = now
+	lot.UpdatedAt = now
+
+	result, err := mgm.Coll(lot).UpdateOne(
+		context.TODO(),
+		bson.M{"purchaseId": lot.PurchaseId},
+		bson.M{"$setOnInsert": lot},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if result.UpsertedCount == 0 {
+		var existing CreditLot
+		if err := mgm.Coll(&CreditLot{}).First(bson.M{"purchaseId": lot.PurchaseId}, &existing); err != nil {
+			return nil, false, err
+		}
+		return &existing, false, nil
+	}
+
+	recordCreditTransaction(lot, CreditTxPurchase, lot.Credits, primitive.NilObjectID, "payment", lot.Note)
+	return lot, true, nil
+}
+
+// ConsumeCredit atomically takes one credit for testName from the owner's
+// lot that expires first. It returns ErrNoCredits when none is left.
+func ConsumeCredit(owner CreditOwner, testName string, testId primitive.ObjectID, actor string) (*CreditLot, error) {
+	var lot CreditLot
+
+	filter := ownerFilter(owner)
+	filter["testName"] = testName
+	filter["remaining"] = bson.M{"$gt": 0}
+	filter["expiresAt"] = bson.M{"$gt": time.Now()}
+
+	err := mgm.Coll(&CreditLot{}).FindOneAndUpdate(
+		context.TODO(),
+		filter,
+		bson.M{"$inc": bson.M{"remaining": -1}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "expiresAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&lot)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoCredits
+		}
+		return nil, err
+	}
+
+	recordCreditTransaction(&lot, CreditTxConsume, -1, testId, actor, "")
+	return &lot, nil
+}
+
+// RestoreCredit gives back a credit taken by ConsumeCredit
+func RestoreCredit(lotId primitive.ObjectID, testId primitive.ObjectID, actor string, note string) error {
+	var lot CreditLot
+
+	err := mgm.Coll(&CreditLot{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": lotId, "$expr": bson.M{"$lt": bson.A{"$remaining", "$credits"}}},
+		bson.M{"$inc": bson.M{"remaining": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&lot)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("credit lot %s has nothing to restore", lotId.Hex())
+		}
+		return err
+	}
+
+	recordCreditTransaction(&lot, CreditTxRestore, 1, testId, actor, note)
+	return nil
+}
+
+// ExpireCreditLots zeroes every lot past its expiry and records what was lost.
+// It returns the number of credits expired.
+func ExpireCreditLots() (int, error) {
+	expired := 0
+
+	for {
+		var lot CreditLot
+
+		// Returns the lot before the update, so the lost credits are known
+		err := mgm.Coll(&CreditLot{}).FindOneAndUpdate(
+			context.TODO(),
+			bson.M{"remaining": bson.M{"$gt": 0}, "expiresAt": bson.M{"$lte": time.Now()}},
+			bson.M{"$set": bson.M{"remaining": 0}},
+		).Decode(&lot)
+
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return expired, nil
+			}
+			return expired, err
+		}
+
+		recordCreditTransaction(&lot, CreditTxExpire, -lot.Remaining, primitive.NilObjectID, "system", "")
+		expired += lot.Remaining
+	}
+}
+
+// CreditBalance is what a wallet can spend on one test
+type CreditBalance struct {
+	TestName   string    `json:"testName" bson:"_id"`
+	Credits    int       `json:"credits" bson:"credits"`
+	NextExpiry time.Time `json:"nextExpiry" bson:"nextExpiry"` // When the first of these credits lapse
+}
+
+// FetchCreditBalances sums the unexpired credits of a wallet per test
+func FetchCreditBalances(owner CreditOwner) ([]CreditBalance, error) {
+	match := ownerFilter(owner)
+	match["remaining"] = bson.M{"$gt": 0}
+	match["expiresAt"] = bson.M{"$gt": time.Now()}
+
+	pipeline := bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":        "$testName",
+			"credits":    bson.M{"$sum": "$remaining"},
+			"nextExpiry": bson.M{"$min": "$expiresAt"},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := mgm.Coll(&CreditLot{}).Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []CreditBalance{}
+	if err := cursor.All(context.TODO(), &balances); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// FetchCreditTransactions returns a page of a wallet's history, newest first
+func FetchCreditTransactions(owner CreditOwner, page int, limit int) ([]CreditTransaction, int64, error) {
+	filter := ownerFilter(owner)
+
+	total, err := mgm.Coll(&CreditTransaction{}).CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	transactions := []CreditTransaction{}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	if err := mgm.Coll(&CreditTransaction{}).SimpleFind(&transactions, filter, findOptions); err != nil {
+		return nil, 0, err
+	}
+
+	return transactions, total, nil
+}
+
+// CreditPurchase is a bundle bought through the payment provider. Its
+// credits are added once the payment link is paid.
+type CreditPurchase struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Owner             CreditOwner        `json:"owner" bson:"owner"`
+	UserId            primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"` // Buyer, unless bought with an API key
+	Sku               string             `json:"sku" bson:"sku"`
+	TestName          string             `json:"testName" bson:"testName"`
+	Credits           int                `json:"credits" bson:"credits"`
+	ValidityDays      int                `json:"validityDays" bson:"validityDays"`
+	Amount            int64              `json:"amount" bson:"amount"` // Minor units
+	Currency          string             `json:"currency" bson:"currency"`
+	Status            string             `json:"status" bson:"status"`
+	PaymentLink       string             `json:"paymentLink" bson:"paymentLink"`
+	ExternalPaymentId string             `json:"externalPaymentId" bson:"externalPaymentId"`
+	ProviderPaymentId string             `json:"providerPaymentId,omitempty" bson:"providerPaymentId,omitempty"`
+	LotId             primitive.ObjectID `json:"lotId,omitempty" bson:"lotId,omitempty"`
+}
+
+func NewCreditPurchase(owner CreditOwner, userId primitive.ObjectID, sku string, testName string, credits int, validityDays int, amount int64, currency string) *CreditPurchase {
+	return &CreditPurchase{
+		Owner:        owner,
+		UserId:       userId,
+		Sku:          sku,
+		TestName:     testName,
+		Credits:      credits,
+		ValidityDays: validityDays,
+		Amount:       amount,
+		Currency:     currency,
+		Status:       CreditPurchasePending,
+	}
+}
+
+func FetchCreditPurchaseById(id primitive.ObjectID) (*CreditPurchase, error) {
+	var purchase CreditPurchase
+
+	err := mgm.Coll(&CreditPurchase{}).First(bson.M{"_id": id}, &purchase)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("credit purchase %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &purchase, nil
+}
+
+// UpdateCreditPurchaseLink stores the payment link created for a purchase
+func UpdateCreditPurchaseLink(id primitive.ObjectID, paymentLink string, externalPaymentId string) error {
+	_, err := mgm.Coll(&CreditPurchase{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"paymentLink": paymentLink, "externalPaymentId": externalPaymentId}},
+	)
+	return err
+}
+
+// MarkCreditPurchasePaid settles a pending purchase with the lot its credits went to
+func MarkCreditPurchasePaid(id primitive.ObjectID, providerPaymentId string, lotId primitive.ObjectID) error {
+	_, err := mgm.Coll(&CreditPurchase{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id, "status": bson.M{"$ne": CreditPurchasePaid}},
+		bson.M{"$set": bson.M{"status": CreditPurchasePaid, "providerPaymentId": providerPaymentId, "lotId": lotId}},
+	)
+	return err
+}
+
+// ExpireCreditPurchase closes a purchase whose link lapsed unpaid
+func ExpireCreditPurchase(id primitive.ObjectID, linkId string) error {
+	_, err := mgm.Coll(&CreditPurchase{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id, "externalPaymentId": linkId, "status": CreditPurchasePending},
+		bson.M{"$set": bson.M{"status": CreditPurchaseExpired}},
+	)
+	return err
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Erasure modes
+const (
+	ErasureModeAnonymise = "ANONYMISE" // Personal data removed, test and payment records kept for accounting
+	ErasureModeDelete    = "DELETE"    // Every document tied to the user is removed
+)
+
+// ErasureRequest is a scheduled right-to-erasure request. It is carried out
+// once ScheduledFor passes unless cancelled during the grace period.
+type ErasureRequest struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	UserId       primitive.ObjectID `json:"userId" bson:"userId"`
+	RequestedBy  string             `json:"requestedBy" bson:"requestedBy"`
+	Reason       string             `json:"reason" bson:"reason"`
+	Mode         string             `json:"mode" bson:"mode"`
+	Status       string             `json:"status" bson:"status"` // SCHEDULED, CANCELLED, COMPLETED, FAILED
+	ScheduledFor time.Time          `json:"scheduledFor" bson:"scheduledFor"`
+	CompletedAt  *time.Time         `json:"completedAt" bson:"completedAt"`
+}
+
+func NewErasureRequest(userId primitive.ObjectID, requestedBy string, reason string, mode string, scheduledFor time.Time) *ErasureRequest {
+	return &ErasureRequest{
+		UserId:       userId,
+		RequestedBy:  requestedBy,
+		Reason:       reason,
+		Mode:         mode,
+		Status:       "SCHEDULED",
+		ScheduledFor: scheduledFor,
+	}
+}
+
+func FetchScheduledErasureRequest(userId primitive.ObjectID) (*ErasureRequest, error) {
+	var request ErasureRequest
+
+	err := mgm.Coll(&ErasureRequest{}).First(bson.M{"userId": userId, "status": "SCHEDULED"}, &request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no scheduled erasure request")
+		}
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// FetchDueErasureRequests returns scheduled requests whose grace period is over
+func FetchDueErasureRequests() ([]ErasureRequest, error) {
+	requests := []ErasureRequest{}
+	err := mgm.Coll(&ErasureRequest{}).SimpleFind(&requests, bson.M{
+		"status":       "SCHEDULED",
+		"scheduledFor": bson.M{"$lte": time.Now()},
+	})
+	return requests, err
+}
+
+func UpdateErasureRequestStatus(id primitive.ObjectID, status string) error {
+	set := bson.M{"status": status}
+	if status == "COMPLETED" {
+		set["completedAt"] = time.Now()
+	}
+
+	_, err := mgm.Coll(&ErasureRequest{}).UpdateOne(context.TODO(), bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
@@ -12,6 +12,8 @@ type FinalReport struct {
 	UserId           primitive.ObjectID `json:"userId" bson:"userId"`
 	TestId           primitive.ObjectID `json:"testId" bson:"testId"`
 	GeneratedContent string             `json:"generatedContent" bson:"generatedContent"`
+	Provider         string             `json:"provider,omitempty" bson:"provider,omitempty"` // LLM backend that wrote the content
+	Model            string             `json:"model,omitempty" bson:"model,omitempty"`
 }
 
 func NewFinalReport(userId primitive.ObjectID, testId primitive.ObjectID, generatedContent string) *FinalReport {
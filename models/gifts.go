@@ -0,0 +1,251 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a gift
+const (
+	GiftPending  = "PENDING"  // Waiting for the buyer's payment
+	GiftPaid     = "PAID"     // Paid, the recipient has been invited
+	GiftRedeemed = "REDEEMED" // The recipient took the test
+	GiftExpired  = "EXPIRED"  // The payment link lapsed unpaid
+	GiftRefunded = "REFUNDED" // Refunded before the recipient used it
+)
+
+// Gift is a test bought by one person for another. The recipient's report
+// is theirs: the buyer only hears that it is ready, and sees it if shared.
+type Gift struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	BuyerId        primitive.ObjectID `json:"buyerId" bson:"buyerId"`
+	RecipientName  string             `json:"recipientName" bson:"recipientName"`
+	RecipientEmail string             `json:"recipientEmail" bson:"recipientEmail"`
+	Message        string             `json:"message,omitempty" bson:"message,omitempty"`
+	Sku            string             `json:"sku" bson:"sku"`
+	TestName       string             `json:"testName" bson:"testName"`
+	Amount         int64              `json:"amount" bson:"amount"` // Minor units
+	Currency       string             `json:"currency" bson:"currency"`
+	Status         string             `json:"status" bson:"status"`
+
+	PaymentLink       string    `json:"paymentLink" bson:"paymentLink"`
+	ExternalPaymentId string    `json:"externalPaymentId" bson:"externalPaymentId"`
+	ProviderPaymentId string    `json:"providerPaymentId,omitempty" bson:"providerPaymentId,omitempty"`
+	PaidAt            time.Time `json:"paidAt,omitempty" bson:"paidAt,omitempty"`
+	ExpiresAt         time.Time `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"` // The recipient must take the test by then
+
+	RecipientUserId primitive.ObjectID `json:"recipientUserId,omitempty" bson:"recipientUserId,omitempty"`
+	TestId          primitive.ObjectID `json:"testId,omitempty" bson:"testId,omitempty"`
+	RedeemedAt      time.Time          `json:"redeemedAt,omitempty" bson:"redeemedAt,omitempty"`
+	BuyerNotified   bool               `json:"buyerNotified,omitempty" bson:"buyerNotified,omitempty"`
+	ReportShared    bool               `json:"reportShared,omitempty" bson:"reportShared,omitempty"` // Set by the recipient
+}
+
+func NewGift(buyerId primitive.ObjectID, recipientName string, recipientEmail string, message string, sku string, testName string, amount int64, currency string) *Gift {
+	return &Gift{
+		BuyerId:        buyerId,
+		RecipientName:  recipientName,
+		RecipientEmail: recipientEmail,
+		Message:        message,
+		Sku:            sku,
+		TestName:       testName,
+		Amount:         amount,
+		Currency:       currency,
+		Status:         GiftPending,
+	}
+}
+
+func FetchGiftById(id primitive.ObjectID) (*Gift, error) {
+	var gift Gift
+
+	err := mgm.Coll(&Gift{}).FindByID(id, &gift)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("gift with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &gift, nil
+}
+
+func FetchGiftByTestId(testId primitive.ObjectID) (*Gift, error) {
+	var gift Gift
+
+	err := mgm.Coll(&Gift{}).First(bson.M{"testId": testId}, &gift)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no gift for test %s", testId.Hex())
+		}
+		return nil, err
+	}
+
+	return &gift, nil
+}
+
+// FetchGiftsByBuyer lists the gifts a user bought, newest first
+func FetchGiftsByBuyer(buyerId primitive.ObjectID) ([]Gift, error) {
+	gifts := []Gift{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if err := mgm.Coll(&Gift{}).SimpleFind(&gifts, bson.M{"buyerId": buyerId}, findOptions); err != nil {
+		return nil, err
+	}
+	return gifts, nil
+}
+
+// UpdateGiftPaymentLink stores the payment link created for a gift
+func UpdateGiftPaymentLink(id primitive.ObjectID, paymentLink string, externalPaymentId string) error {
+	_, err := mgm.Coll(&Gift{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"paymentLink": paymentLink, "externalPaymentId": externalPaymentId, "updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// MarkGiftPaid settles a pending gift. The bool is false when the gift was
+// settled already, so the invitation is sent once.
+func MarkGiftPaid(id primitive.ObjectID, providerPaymentId string, expiresAt time.Time) (*Gift, bool, error) {
+	var gift Gift
+
+	now := time.Now().UTC()
+	err := mgm.Coll(&Gift{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id, "status": bson.M{"$in": []string{GiftPending, GiftExpired}}},
+		bson.M{"$set": bson.M{
+			"status":            GiftPaid,
+			"providerPaymentId": providerPaymentId,
+			"paidAt":            now,
+			"expiresAt":         expiresAt,
+			"updated_at":        now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&gift)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			existing, fetchErr := FetchGiftById(id)
+			if fetchErr != nil {
+				return nil, false, fetchErr
+			}
+			return existing, false, nil
+		}
+		return nil, false, err
+	}
+
+	return &gift, true, nil
+}
+
+// ExpireGiftPurchase closes a gift whose payment link lapsed unpaid
+func ExpireGiftPurchase(id primitive.ObjectID, linkId string) error {
+	_, err := mgm.Coll(&Gift{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id, "externalPaymentId": linkId, "status": GiftPending},
+		bson.M{"$set": bson.M{"status": GiftExpired, "updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// RefundGift withdraws a paid gift the recipient hasn't used
+func RefundGift(id primitive.ObjectID) error {
+	_, err := mgm.Coll(&Gift{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id, "status": GiftPaid},
+		bson.M{"$set": bson.M{"status": GiftRefunded, "updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// ErrGiftUnavailable is returned for gifts that were used, refunded, expired or never paid
+var ErrGiftUnavailable = errors.New("gift is no longer available")
+
+// RedeemGift ties a paid, unexpired gift to the recipient's test, atomically,
+// so one invitation can't pay for two tests
+func RedeemGift(id primitive.ObjectID, userId primitive.ObjectID, testId primitive.ObjectID) (*Gift, error) {
+	var gift Gift
+
+	now := time.Now().UTC()
+	err := mgm.Coll(&Gift{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id, "status": GiftPaid, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{
+			"status":          GiftRedeemed,
+			"recipientUserId": userId,
+			"testId":          testId,
+			"redeemedAt":      now,
+			"updated_at":      now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&gift)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrGiftUnavailable
+		}
+		return nil, err
+	}
+
+	return &gift, nil
+}
+
+// ReleaseGift makes a gift usable again after the submission that redeemed it failed
+func ReleaseGift(id primitive.ObjectID, testId primitive.ObjectID) error {
+	_, err := mgm.Coll(&Gift{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id, "status": GiftRedeemed, "testId": testId},
+		bson.M{
+			"$set":   bson.M{"status": GiftPaid, "updated_at": time.Now().UTC()},
+			"$unset": bson.M{"recipientUserId": "", "testId": "", "redeemedAt": ""},
+		},
+	)
+	return err
+}
+
+// ClaimGiftBuyerNotification returns true the first time it is called for a
+// gift, so the buyer hears about a finished report once
+func ClaimGiftBuyerNotification(id primitive.ObjectID) (bool, error) {
+	result, err := mgm.Coll(&Gift{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id, "buyerNotified": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"buyerNotified": true, "updated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// ShareGiftReport lets the buyer see the report of a gift the recipient redeemed
+func ShareGiftReport(testId primitive.ObjectID, recipientUserId primitive.ObjectID) (*Gift, bool, error) {
+	var gift Gift
+
+	err := mgm.Coll(&Gift{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"testId": testId, "recipientUserId": recipientUserId, "reportShared": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"reportShared": true, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&gift)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			existing, fetchErr := FetchGiftByTestId(testId)
+			if fetchErr != nil || existing.RecipientUserId != recipientUserId {
+				return nil, false, fmt.Errorf("no gift for test %s", testId.Hex())
+			}
+			return existing, false, nil
+		}
+		return nil, false, err
+	}
+
+	return &gift, true, nil
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GuardianConsent holds a minor's test until their guardian approves it
+type GuardianConsent struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	TestId          primitive.ObjectID `json:"testId" bson:"testId"`
+	UserId          primitive.ObjectID `json:"userId" bson:"userId"`
+	GuardianName    string             `json:"guardianName" bson:"guardianName"`
+	GuardianEmail   string             `json:"guardianEmail" bson:"guardianEmail"`
+	Relationship    string             `json:"relationship" bson:"relationship"`
+	PaymentRequired bool               `json:"paymentRequired" bson:"paymentRequired"`
+	Status          string             `json:"status" bson:"status"` // PENDING, APPROVED, DECLINED
+	DecidedAt       *time.Time         `json:"decidedAt" bson:"decidedAt"`
+	IpAddress       string             `json:"ipAddress" bson:"ipAddress"` // Where the guardian decided from
+	UserAgent       string             `json:"userAgent" bson:"userAgent"`
+}
+
+func NewGuardianConsent(testId primitive.ObjectID, userId primitive.ObjectID, guardianName string, guardianEmail string, relationship string, paymentRequired bool) *GuardianConsent {
+	return &GuardianConsent{
+		TestId:          testId,
+		UserId:          userId,
+		GuardianName:    guardianName,
+		GuardianEmail:   guardianEmail,
+		Relationship:    relationship,
+		PaymentRequired: paymentRequired,
+		Status:          "PENDING",
+	}
+}
+
+func FetchGuardianConsentById(id primitive.ObjectID) (*GuardianConsent, error) {
+	var consent GuardianConsent
+
+	err := mgm.Coll(&GuardianConsent{}).FindByID(id, &consent)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("guardian consent %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &consent, nil
+}
+
+func FetchGuardianConsentByTestId(testId primitive.ObjectID) (*GuardianConsent, error) {
+	var consent GuardianConsent
+
+	err := mgm.Coll(&GuardianConsent{}).First(bson.M{"testId": testId}, &consent)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no guardian consent for test %s", testId.Hex())
+		}
+		return nil, err
+	}
+
+	return &consent, nil
+}
+
+// DecideGuardianConsent moves a PENDING consent to status. It fails when the
+// consent was already decided so a link can only be used once.
+func DecideGuardianConsent(id primitive.ObjectID, status string, ipAddress string, userAgent string) (*GuardianConsent, error) {
+	var consent GuardianConsent
+
+	err := mgm.Coll(&GuardianConsent{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id, "status": "PENDING"},
+		bson.M{"$set": bson.M{
+			"status":    status,
+			"decidedAt": time.Now(),
+			"ipAddress": ipAddress,
+			"userAgent": userAgent,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&consent)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("guardian consent was already decided")
+		}
+		return nil, err
+	}
+
+	return &consent, nil
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Billing is the buyer's invoicing details given at submission
+type Billing struct {
+	Name    string `json:"name" bson:"name"`
+	Gstin   string `json:"gstin,omitempty" bson:"gstin,omitempty"` // B2B buyers only
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+	State   string `json:"state,omitempty" bson:"state,omitempty"` // GST state code, e.g. 29 for Karnataka
+	Country string `json:"country,omitempty" bson:"country,omitempty"`
+}
+
+// InvoiceParty is the seller or buyer printed on an invoice
+type InvoiceParty struct {
+	Name    string `json:"name" bson:"name"`
+	Email   string `json:"email,omitempty" bson:"email,omitempty"`
+	Gstin   string `json:"gstin,omitempty" bson:"gstin,omitempty"`
+	Address string `json:"address,omitempty" bson:"address,omitempty"`
+	State   string `json:"state,omitempty" bson:"state,omitempty"`
+}
+
+// InvoiceLine is one item of an invoice. Amounts are minor units.
+type InvoiceLine struct {
+	Description   string `json:"description" bson:"description"`
+	HsnSac        string `json:"hsnSac" bson:"hsnSac"`
+	Quantity      int64  `json:"quantity" bson:"quantity"`
+	TaxableAmount int64  `json:"taxableAmount" bson:"taxableAmount"`
+	TaxRate       int64  `json:"taxRate" bson:"taxRate"` // Percent
+}
+
+// Invoice is a GST tax invoice issued for a paid test
+type Invoice struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Number        string             `json:"number" bson:"number"` // PREFIX/FY/sequence, e.g. MS/2026-27/000042
+	FinancialYear string             `json:"financialYear" bson:"financialYear"`
+	Sequence      int64              `json:"sequence" bson:"sequence"`
+	UserId        primitive.ObjectID `json:"userId" bson:"userId"`
+	TestId        primitive.ObjectID `json:"testId" bson:"testId"`
+	PaymentId     primitive.ObjectID `json:"paymentId,omitempty" bson:"paymentId,omitempty"` // Ledger entry, when there is one
+	Seller        InvoiceParty       `json:"seller" bson:"seller"`
+	Buyer         InvoiceParty       `json:"buyer" bson:"buyer"`
+	PlaceOfSupply string             `json:"placeOfSupply" bson:"placeOfSupply"`
+	Lines         []InvoiceLine      `json:"lines" bson:"lines"`
+	Currency      string             `json:"currency" bson:"currency"`
+	TaxableAmount int64              `json:"taxableAmount" bson:"taxableAmount"`
+	Cgst          int64              `json:"cgst" bson:"cgst"`
+	Sgst          int64              `json:"sgst" bson:"sgst"`
+	Igst          int64              `json:"igst" bson:"igst"`
+	Total         int64              `json:"total" bson:"total"`
+	IssuedAt      time.Time          `json:"issuedAt" bson:"issuedAt"`
+	EmailedAt     time.Time          `json:"emailedAt,omitempty" bson:"emailedAt,omitempty"`
+}
+
+// FetchInvoiceByTestId returns the invoice of a test. There is at most one.
+func FetchInvoiceByTestId(testId primitive.ObjectID) (*Invoice, error) {
+	var invoice Invoice
+
+	err := mgm.Coll(&Invoice{}).First(bson.M{"testId": testId}, &invoice)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no invoice for test %s", testId.Hex())
+		}
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// MarkInvoiceEmailed records when the invoice was sent to the buyer
+func MarkInvoiceEmailed(id primitive.ObjectID) error {
+	_, err := mgm.Coll(&Invoice{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"emailedAt": time.Now().UTC()}},
+	)
+	return err
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Organization is a B2B customer such as a coaching institute, school or HR platform
+type Organization struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Name         string `json:"name" bson:"name"`
+	Type         string `json:"type" bson:"type"` // INSTITUTE, SCHOOL, HR_PLATFORM
+	ContactEmail string `json:"contactEmail" bson:"contactEmail"`
+	Status       string `json:"status" bson:"status"`
+}
+
+func NewOrganization(name string, orgType string, contactEmail string, status string) *Organization {
+	return &Organization{
+		Name:         name,
+		Type:         orgType,
+		ContactEmail: contactEmail,
+		Status:       status,
+	}
+}
+
+func FetchOrganizationById(id primitive.ObjectID) (*Organization, error) {
+	var organization Organization
+
+	err := mgm.Coll(&Organization{}).First(bson.M{"_id": id}, &organization)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("organization with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &organization, nil
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a payment grant
+const (
+	PaymentGrantActive  = "ACTIVE"
+	PaymentGrantUsed    = "USED"
+	PaymentGrantRevoked = "REVOKED"
+)
+
+// PaymentGrant lets one test skip payment. It is issued by an admin with a
+// reason, handed out as a signed token, expires and can be used once.
+type PaymentGrant struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	IssuedBy  string    `json:"issuedBy" bson:"issuedBy"`
+	Reason    string    `json:"reason" bson:"reason"`
+	TestName  string    `json:"testName" bson:"testName"`
+	Email     string    `json:"email,omitempty" bson:"email,omitempty"` // Only this user may use it, when set
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+	Status    string    `json:"status" bson:"status"`
+
+	UsedBy    primitive.ObjectID `json:"usedBy,omitempty" bson:"usedBy,omitempty"`
+	TestId    primitive.ObjectID `json:"testId,omitempty" bson:"testId,omitempty"`
+	UsedAt    time.Time          `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	RevokedBy string             `json:"revokedBy,omitempty" bson:"revokedBy,omitempty"`
+}
+
+func NewPaymentGrant(issuedBy string, reason string, testName string, email string, expiresAt time.Time) *PaymentGrant {
+	return &PaymentGrant{
+		IssuedBy:  issuedBy,
+		Reason:    reason,
+		TestName:  testName,
+		Email:     email,
+		ExpiresAt: expiresAt,
+		Status:    PaymentGrantActive,
+	}
+}
+
+func FetchPaymentGrantById(id primitive.ObjectID) (*PaymentGrant, error) {
+	var grant PaymentGrant
+
+	err := mgm.Coll(&PaymentGrant{}).FindByID(id, &grant)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("payment grant with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+// FetchPaymentGrants returns a page of grants, newest first, optionally in one status
+func FetchPaymentGrants(status string, page int, limit int) ([]PaymentGrant, int64, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := mgm.Coll(&PaymentGrant{}).CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	grants := []PaymentGrant{}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	if err := mgm.Coll(&PaymentGrant{}).SimpleFind(&grants, filter, findOptions); err != nil {
+		return nil, 0, err
+	}
+
+	return grants, total, nil
+}
+
+// ErrPaymentGrantUnavailable is returned for grants that were used, revoked or expired
+var ErrPaymentGrantUnavailable = errors.New("payment grant is no longer valid")
+
+// UsePaymentGrant marks an active, unexpired grant as used by the test,
+// atomically, so a token can't pay for two tests
+func UsePaymentGrant(grantId primitive.ObjectID, userId primitive.ObjectID, testId primitive.ObjectID) (*PaymentGrant, error) {
+	var grant PaymentGrant
+
+	now := time.Now().UTC()
+	err := mgm.Coll(&PaymentGrant{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": grantId, "status": PaymentGrantActive, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{
+			"status":     PaymentGrantUsed,
+			"usedBy":     userId,
+			"testId":     testId,
+			"usedAt":     now,
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&grant)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPaymentGrantUnavailable
+		}
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+// ReleasePaymentGrant makes a grant usable again after the submission that
+// used it failed
+func ReleasePaymentGrant(grantId primitive.ObjectID, testId primitive.ObjectID) error {
+	_, err := mgm.Coll(&PaymentGrant{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": grantId, "status": PaymentGrantUsed, "testId": testId},
+		bson.M{
+			"$set":   bson.M{"status": PaymentGrantActive, "updated_at": time.Now().UTC()},
+			"$unset": bson.M{"usedBy": "", "testId": "", "usedAt": ""},
+		},
+	)
+	return err
+}
+
+// RevokePaymentGrant stops an unused grant from being used
+func RevokePaymentGrant(grantId primitive.ObjectID, revokedBy string) (*PaymentGrant, error) {
+	var grant PaymentGrant
+
+	err := mgm.Coll(&PaymentGrant{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": grantId, "status": PaymentGrantActive},
+		bson.M{"$set": bson.M{"status": PaymentGrantRevoked, "revokedBy": revokedBy, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&grant)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no active payment grant found with the given ID")
+		}
+		return nil, err
+	}
+
+	return &grant, nil
+}
@@ -0,0 +1,278 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ledger states of a payment
+const (
+	LedgerStatusCreated      = "CREATED"
+	LedgerStatusPaid         = "PAID"
+	LedgerStatusFailed       = "FAILED" // Only recorded in the history, the link stays payable
+	LedgerStatusExpired      = "EXPIRED"
+	LedgerStatusCancelled    = "CANCELLED"
+	LedgerStatusRefunded     = "REFUNDED"
+	LedgerStatusPartRefunded = "PARTIALLY_REFUNDED"
+)
+
+// PaymentEvent is one entry of a payment's status history with the provider payload that caused it
+type PaymentEvent struct {
+	Status            string    `json:"status" bson:"status"`
+	Source            string    `json:"source" bson:"source"` // submit, callback, webhook, admin...
+	ProviderPaymentId string    `json:"providerPaymentId,omitempty" bson:"providerPaymentId,omitempty"`
+	Amount            int64     `json:"amount,omitempty" bson:"amount,omitempty"` // Minor units of Currency
+	Currency          string    `json:"currency,omitempty" bson:"currency,omitempty"`
+	Error             string    `json:"error,omitempty" bson:"error,omitempty"`
+	Payload           string    `json:"payload,omitempty" bson:"payload,omitempty"` // Raw provider JSON
+	At                time.Time `json:"at" bson:"at"`
+}
+
+// PaymentRefund is one refund made against the settling payment
+type PaymentRefund struct {
+	ProviderRefundId string    `json:"providerRefundId" bson:"providerRefundId"`
+	Amount           int64     `json:"amount" bson:"amount"` // Minor units of Currency
+	Currency         string    `json:"currency" bson:"currency"`
+	Status           string    `json:"status" bson:"status"` // Provider refund status, e.g. processed or pending
+	Reason           string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	RequestedBy      string    `json:"requestedBy" bson:"requestedBy"` // Admin subject, or the provider for dashboard refunds
+	At               time.Time `json:"at" bson:"at"`
+}
+
+// Payment is the ledger entry for one payment link of a test, or of a credit
+// purchase, and every attempt made on it
+type Payment struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	UserId            primitive.ObjectID `json:"userId" bson:"userId"`
+	TestId            primitive.ObjectID `json:"testId" bson:"testId"`
+	Provider          string             `json:"provider" bson:"provider"`
+	Amount            int64              `json:"amount" bson:"amount"` // Minor units, e.g. paise
+	Currency          string             `json:"currency" bson:"currency"`
+	ProviderLinkId    string             `json:"providerLinkId" bson:"providerLinkId"`
+	ProviderPaymentId string             `json:"providerPaymentId,omitempty" bson:"providerPaymentId,omitempty"` // razorpay_payment_id of the settling payment
+	Status            string             `json:"status" bson:"status"`
+	History           []PaymentEvent     `json:"history" bson:"history"`
+	RefundedAmount    int64              `json:"refundedAmount" bson:"refundedAmount"`
+	Refunds           []PaymentRefund    `json:"refunds,omitempty" bson:"refunds,omitempty"`
+	CreditPurchaseId  primitive.ObjectID `json:"creditPurchaseId,omitempty" bson:"creditPurchaseId,omitempty"` // Set instead of TestId for bundles
+	GiftId            primitive.ObjectID `json:"giftId,omitempty" bson:"giftId,omitempty"`                     // Set instead of TestId for gifts
+}
+
+// RefundableAmount is what is left to refund of the settling payment
+func (p *Payment) RefundableAmount() int64 {
+	if p.Status != LedgerStatusPaid && p.Status != LedgerStatusPartRefunded {
+		return 0
+	}
+	return p.Amount - p.RefundedAmount
+}
+
+func NewPayment(userId primitive.ObjectID, testId primitive.ObjectID, provider string, providerLinkId string, amount int64, currency string, payload string) *Payment {
+	return &Payment{
+		UserId:         userId,
+		TestId:         testId,
+		Provider:       provider,
+		Amount:         amount,
+		Currency:       currency,
+		ProviderLinkId: providerLinkId,
+		Status:         LedgerStatusCreated,
+		History: []PaymentEvent{
+			{Status: LedgerStatusCreated, Source: "submit", Amount: amount, Currency: currency, Payload: payload, At: time.Now().UTC()},
+		},
+	}
+}
+
+func fetchPayment(filter bson.M) (*Payment, error) {
+	var payment Payment
+
+	err := mgm.Coll(&Payment{}).First(filter, &payment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("payment not found")
+		}
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+func FetchPaymentById(id primitive.ObjectID) (*Payment, error) {
+	return fetchPayment(bson.M{"_id": id})
+}
+
+func FetchPaymentByLinkId(providerLinkId string) (*Payment, error) {
+	return fetchPayment(bson.M{"providerLinkId": providerLinkId})
+}
+
+func FetchPaymentByProviderPaymentId(providerPaymentId string) (*Payment, error) {
+	return fetchPayment(bson.M{"providerPaymentId": providerPaymentId})
+}
+
+// AppendPaymentEvent adds an entry to the history and, unless status is
+// empty, moves the payment to that status
+func AppendPaymentEvent(id primitive.ObjectID, status string, event PaymentEvent) (*Payment, error) {
+	var payment Payment
+
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+
+	set := bson.M{}
+	if status != "" {
+		set["status"] = status
+	}
+	if status == LedgerStatusPaid && event.ProviderPaymentId != "" {
+		set["providerPaymentId"] = event.ProviderPaymentId
+	}
+
+	update := bson.M{"$push": bson.M{"history": event}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+
+	err := mgm.Coll(&Payment{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&payment)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no document found with the given ID")
+		}
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// AddPaymentRefund records a refund once per provider refund id. The boolean
+// is false when the refund was already recorded, e.g. the webhook for a
+// refund we issued ourselves.
+//
+// The new status is worked out from the refunded total it was read with, and
+// the update only applies while that total is unchanged, so two partial
+// refunds landing together can't both write a stale status.
+func AddPaymentRefund(id primitive.ObjectID, refund PaymentRefund, payload string) (*Payment, bool, error) {
+	if refund.At.IsZero() {
+		refund.At = time.Now().UTC()
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		existing, err := FetchPaymentById(id)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, recorded := range existing.Refunds {
+			if recorded.ProviderRefundId == refund.ProviderRefundId {
+				return existing, false, nil
+			}
+		}
+		if refund.Currency == "" {
+			refund.Currency = existing.Currency
+		}
+
+		status := LedgerStatusPartRefunded
+		if existing.RefundedAmount+refund.Amount >= existing.Amount {
+			status = LedgerStatusRefunded
+		}
+
+		update := bson.M{
+			"$inc": bson.M{"refundedAmount": refund.Amount},
+			"$set": bson.M{"status": status},
+			"$push": bson.M{
+				"refunds": refund,
+				"history": PaymentEvent{
+					Status:            status,
+					Source:            refund.RequestedBy,
+					ProviderPaymentId: existing.ProviderPaymentId,
+					Amount:            refund.Amount,
+					Currency:          refund.Currency,
+					Payload:           payload,
+					At:                refund.At,
+				},
+			},
+		}
+
+		var payment Payment
+		err = mgm.Coll(&Payment{}).FindOneAndUpdate(
+			context.TODO(),
+			bson.M{
+				"_id":                      id,
+				"refundedAmount":           existing.RefundedAmount,
+				"refunds.providerRefundId": bson.M{"$ne": refund.ProviderRefundId},
+			},
+			update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&payment)
+
+		if err == nil {
+			return &payment, true, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, false, err
+		}
+		// Another refund was recorded in between; read the new total and try again
+	}
+
+	return nil, false, fmt.Errorf("payment %s changed while recording refund %s", id.Hex(), refund.ProviderRefundId)
+}
+
+// PaymentQuery narrows the admin payment listing. Zero values are ignored.
+type PaymentQuery struct {
+	UserId primitive.ObjectID
+	TestId primitive.ObjectID
+	Status string
+	From   time.Time
+	To     time.Time
+}
+
+// FindPayments returns one page of payments matching the query, newest first, and the total count
+func FindPayments(query PaymentQuery, page int, limit int) ([]Payment, int64, error) {
+	filter := bson.M{}
+	if !query.UserId.IsZero() {
+		filter["userId"] = query.UserId
+	}
+	if !query.TestId.IsZero() {
+		filter["testId"] = query.TestId
+	}
+	if query.Status != "" {
+		filter["status"] = query.Status
+	}
+	if !query.From.IsZero() || !query.To.IsZero() {
+		createdAt := bson.M{}
+		if !query.From.IsZero() {
+			createdAt["$gte"] = query.From
+		}
+		if !query.To.IsZero() {
+			createdAt["$lt"] = query.To
+		}
+		filter["created_at"] = createdAt
+	}
+
+	total, err := mgm.Coll(&Payment{}).CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	payments := []Payment{}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	if err := mgm.Coll(&Payment{}).SimpleFind(&payments, filter, findOptions); err != nil {
+		return nil, 0, err
+	}
+
+	return payments, total, nil
+}
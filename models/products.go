@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report tiers a test can be sold in
+const (
+	TierStandard = "STANDARD"
+)
+
+// Product is a sellable report: one test in one tier with its prices
+type Product struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Sku         string           `json:"sku" bson:"sku"` // e.g. BIG_5_STANDARD
+	Name        string           `json:"name" bson:"name"`
+	Description string           `json:"description" bson:"description"` // Shown on the payment link
+	TestName    string           `json:"testName" bson:"testName"`
+	Tier        string           `json:"tier" bson:"tier"`
+	Prices      map[string]int64 `json:"prices" bson:"prices"`     // Currency code to amount in minor units
+	TaxClass    string           `json:"taxClass" bson:"taxClass"` // e.g. GST_18
+	HsnSac      string           `json:"hsnSac" bson:"hsnSac"`     // Printed on invoices
+	Active      bool             `json:"active" bson:"active"`
+
+	// Bundles sell prepaid credits for TestName instead of a single report
+	Credits            int `json:"credits,omitempty" bson:"credits,omitempty"`
+	CreditValidityDays int `json:"creditValidityDays,omitempty" bson:"creditValidityDays,omitempty"`
+}
+
+func NewProduct(sku string, name string, description string, testName string, tier string, prices map[string]int64, taxClass string, hsnSac string) *Product {
+	return &Product{
+		Sku:         sku,
+		Name:        name,
+		Description: description,
+		TestName:    testName,
+		Tier:        tier,
+		Prices:      prices,
+		TaxClass:    taxClass,
+		HsnSac:      hsnSac,
+		Active:      true,
+	}
+}
+
+// IsBundle reports whether the product sells credits
+func (product *Product) IsBundle() bool {
+	return product.Credits > 0
+}
+
+// Price returns the product's amount in a currency
+func (product *Product) Price(currency string) (int64, bool) {
+	amount, ok := product.Prices[currency]
+	return amount, ok
+}
+
+func fetchProduct(filter bson.M) (*Product, error) {
+	var product Product
+
+	err := mgm.Coll(&Product{}).First(filter, &product)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("product not found")
+		}
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+func FetchProductBySku(sku string) (*Product, error) {
+	return fetchProduct(bson.M{"sku": sku})
+}
+
+// FetchActiveProduct returns the single report product sold for a test and tier
+func FetchActiveProduct(testName string, tier string) (*Product, error) {
+	return fetchProduct(bson.M{"testName": testName, "tier": tier, "active": true, "credits": bson.M{"$not": bson.M{"$gt": 0}}})
+}
+
+// FetchProducts lists the catalog, only active products unless includeInactive
+func FetchProducts(includeInactive bool) ([]Product, error) {
+	filter := bson.M{"active": true}
+	if includeInactive {
+		filter = bson.M{}
+	}
+
+	products := []Product{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "testName", Value: 1}, {Key: "tier", Value: 1}})
+	if err := mgm.Coll(&Product{}).SimpleFind(&products, filter, findOptions); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// UpsertProduct creates or replaces the product with the same sku
+func UpsertProduct(product *Product) (*Product, error) {
+	existing, err := FetchProductBySku(product.Sku)
+	if err != nil {
+		if createErr := mgm.Coll(product).Create(product); createErr != nil {
+			return nil, createErr
+		}
+		return product, nil
+	}
+
+	product.ID = existing.ID
+	product.CreatedAt = existing.CreatedAt
+	if err := mgm.Coll(product).Update(product); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// SetProductActive enables or retires a product
+func SetProductActive(sku string, active bool) (*Product, error) {
+	var product Product
+
+	err := mgm.Coll(&Product{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"sku": sku},
+		bson.M{"$set": bson.M{"active": active}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("product %s not found", sku)
+		}
+		return nil, err
+	}
+
+	return &product, nil
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of differences the reconciler finds between our records and the provider's
+const (
+	MismatchPaidNotRecorded    = "PAID_NOT_RECORDED"    // Provider settled a link we still show as pending
+	MismatchExpiredNotRecorded = "EXPIRED_NOT_RECORDED" // Provider expired or cancelled a link we still show as pending
+	MismatchAmount             = "AMOUNT_MISMATCH"      // Provider collected a different amount than the ledger expects
+	MismatchStaleLink          = "STALE_LINK"           // Link is past its validity but the provider still has it open
+	MismatchProviderError      = "PROVIDER_ERROR"       // The link could not be fetched from the provider
+)
+
+// ReconciliationMismatch is one difference found, and what was done about it
+type ReconciliationMismatch struct {
+	TestId    primitive.ObjectID `json:"testId" bson:"testId"`
+	PaymentId primitive.ObjectID `json:"paymentId,omitempty" bson:"paymentId,omitempty"` // Ledger entry, when there is one
+	Provider  string             `json:"provider" bson:"provider"`
+	LinkId    string             `json:"linkId" bson:"linkId"`
+	Kind      string             `json:"kind" bson:"kind"`
+	Ours      string             `json:"ours" bson:"ours"`
+	Theirs    string             `json:"theirs" bson:"theirs"`
+	Action    string             `json:"action" bson:"action"` // e.g. MARKED_PAID, NONE
+	At        time.Time          `json:"at" bson:"at"`
+}
+
+// ReconciliationReport collects every reconciler run of one day (IST)
+type ReconciliationReport struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Date       string                   `json:"date" bson:"date"` // 2006-01-02
+	Runs       int                      `json:"runs" bson:"runs"`
+	Checked    int                      `json:"checked" bson:"checked"`
+	Settled    int                      `json:"settled" bson:"settled"`
+	Expired    int                      `json:"expired" bson:"expired"`
+	Mismatches []ReconciliationMismatch `json:"mismatches" bson:"mismatches"`
+	LastRunAt  time.Time                `json:"lastRunAt" bson:"lastRunAt"`
+}
+
+// ReconciliationRun is the outcome of a single reconciler pass
+type ReconciliationRun struct {
+	Checked    int
+	Settled    int
+	Expired    int
+	Mismatches []ReconciliationMismatch
+}
+
+// RecordReconciliationRun adds a run to the report of its day, creating it on the first run
+func RecordReconciliationRun(date string, run ReconciliationRun) (*ReconciliationReport, error) {
+	var report ReconciliationReport
+
+	if run.Mismatches == nil {
+		run.Mismatches = []ReconciliationMismatch{}
+	}
+
+	now := time.Now()
+	err := mgm.Coll(&ReconciliationReport{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"date": date},
+		bson.M{
+			"$inc": bson.M{
+				"runs":    1,
+				"checked": run.Checked,
+				"settled": run.Settled,
+				"expired": run.Expired,
+			},
+			"$push":        bson.M{"mismatches": bson.M{"$each": run.Mismatches}},
+			"$set":         bson.M{"lastRunAt": now, "updated_at": now},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func FetchReconciliationReport(date string) (*ReconciliationReport, error) {
+	var report ReconciliationReport
+
+	err := mgm.Coll(&ReconciliationReport{}).First(bson.M{"date": date}, &report)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no reconciliation report for %s", date)
+		}
+		return nil, err
+	}
+
+	return &report, nil
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a referral
+const (
+	ReferralPending  = "PENDING"  // Discount given, reward due once the referee's payment is captured
+	ReferralFlagged  = "FLAGGED"  // Looked like abuse, the reward waits for an admin
+	ReferralRewarded = "REWARDED" // Reward added to the referrer's wallet
+	ReferralRejected = "REJECTED" // No reward, decided by an admin
+)
+
+// Reasons a referral is flagged for review
+const (
+	ReferralFlagVelocity = "REFERRER_VELOCITY" // The referrer brought in more users than usual today
+	ReferralFlagSharedIp = "SHARED_IP"         // Several referees of the code submitted from one address
+)
+
+// ReferralCode is the code a user shares to refer others. Each user has one.
+type ReferralCode struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	UserId primitive.ObjectID `json:"userId" bson:"userId"`
+	Code   string             `json:"code" bson:"code"` // Stored upper case
+}
+
+// FetchOrCreateReferralCode returns the user's code, storing code as theirs
+// when they don't have one yet
+func FetchOrCreateReferralCode(userId primitive.ObjectID, code string) (*ReferralCode, error) {
+	var referralCode ReferralCode
+
+	now := time.Now().UTC()
+	err := mgm.Coll(&ReferralCode{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"userId": userId},
+		bson.M{"$setOnInsert": bson.M{
+			"userId":     userId,
+			"code":       NormalizeCouponCode(code),
+			"created_at": now,
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&referralCode)
+
+	if err != nil {
+		return nil, err
+	}
+	return &referralCode, nil
+}
+
+func FetchReferralCodeByCode(code string) (*ReferralCode, error) {
+	var referralCode ReferralCode
+
+	err := mgm.Coll(&ReferralCode{}).First(bson.M{"code": NormalizeCouponCode(code)}, &referralCode)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("referral code %s not found", code)
+		}
+		return nil, err
+	}
+
+	return &referralCode, nil
+}
+
+// Referral is one referred test: the referee's discount and the referrer's reward
+type Referral struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Code       string             `json:"code" bson:"code"`
+	ReferrerId primitive.ObjectID `json:"referrerId" bson:"referrerId"`
+	RefereeId  primitive.ObjectID `json:"refereeId" bson:"refereeId"`
+	TestId     primitive.ObjectID `json:"testId" bson:"testId"`
+	Discount   int64              `json:"discount" bson:"discount"` // Minor units
+	Currency   string             `json:"currency" bson:"currency"`
+	IpAddress  string             `json:"ipAddress,omitempty" bson:"ipAddress,omitempty"`
+	Status     string             `json:"status" bson:"status"`
+	Flags      []string           `json:"flags,omitempty" bson:"flags,omitempty"`
+
+	RewardCredits int                `json:"rewardCredits,omitempty" bson:"rewardCredits,omitempty"`
+	RewardLotId   primitive.ObjectID `json:"rewardLotId,omitempty" bson:"rewardLotId,omitempty"`
+	RewardedAt    time.Time          `json:"rewardedAt,omitempty" bson:"rewardedAt,omitempty"`
+	ReviewedBy    string             `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+}
+
+func NewReferral(code *ReferralCode, refereeId primitive.ObjectID, testId primitive.ObjectID, discount int64, currency string, ipAddress string, flags []string) *Referral {
+	status := ReferralPending
+	if len(flags) > 0 {
+		status = ReferralFlagged
+	}
+	return &Referral{
+		Code:       code.Code,
+		ReferrerId: code.UserId,
+		RefereeId:  refereeId,
+		TestId:     testId,
+		Discount:   discount,
+		Currency:   currency,
+		IpAddress:  ipAddress,
+		Status:     status,
+		Flags:      flags,
+	}
+}
+
+func FetchReferralById(id primitive.ObjectID) (*Referral, error) {
+	var referral Referral
+
+	err := mgm.Coll(&Referral{}).FindByID(id, &referral)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("referral with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &referral, nil
+}
+
+func FetchReferralByTestId(testId primitive.ObjectID) (*Referral, error) {
+	var referral Referral
+
+	err := mgm.Coll(&Referral{}).First(bson.M{"testId": testId}, &referral)
+	if err != nil {
+		return nil, err
+	}
+
+	return &referral, nil
+}
+
+// CountReferralsByReferee is how many times a user was referred before
+func CountReferralsByReferee(refereeId primitive.ObjectID) (int64, error) {
+	return mgm.Coll(&Referral{}).CountDocuments(context.TODO(), bson.M{"refereeId": refereeId})
+}
+
+// CountReferralsSince is how many referrals a referrer made since the given
+// time, optionally only those from one IP address
+func CountReferralsSince(referrerId primitive.ObjectID, since time.Time, ipAddress string) (int64, error) {
+	filter := bson.M{"referrerId": referrerId, "created_at": bson.M{"$gte": since}}
+	if ipAddress != "" {
+		filter["ipAddress"] = ipAddress
+	}
+	return mgm.Coll(&Referral{}).CountDocuments(context.TODO(), filter)
+}
+
+// FetchReferrals returns a page of referrals, newest first, optionally in one status
+func FetchReferrals(status string, page int, limit int) ([]Referral, int64, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	total, err := mgm.Coll(&Referral{}).CountDocuments(context.TODO(), filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	referrals := []Referral{}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	if err := mgm.Coll(&Referral{}).SimpleFind(&referrals, filter, findOptions); err != nil {
+		return nil, 0, err
+	}
+
+	return referrals, total, nil
+}
+
+// ErrReferralNotDue is returned when a referral is not in the state a change needs
+var ErrReferralNotDue = errors.New("referral is not in a state that allows this")
+
+// UpdateReferralStatus moves a referral from one of the from states to status
+func UpdateReferralStatus(id primitive.ObjectID, from []string, status string, reviewedBy string) (*Referral, error) {
+	var referral Referral
+
+	update := bson.M{"status": status, "updated_at": time.Now().UTC()}
+	if reviewedBy != "" {
+		update["reviewedBy"] = reviewedBy
+	}
+
+	err := mgm.Coll(&Referral{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&referral)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrReferralNotDue
+		}
+		return nil, err
+	}
+
+	return &referral, nil
+}
+
+// ClaimReferralReward marks a pending referral as rewarded, atomically, so
+// the payment callback and webhook can't both pay the referrer
+func ClaimReferralReward(id primitive.ObjectID, credits int) (*Referral, error) {
+	var referral Referral
+
+	now := time.Now().UTC()
+	err := mgm.Coll(&Referral{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": id, "status": ReferralPending},
+		bson.M{"$set": bson.M{
+			"status":        ReferralRewarded,
+			"rewardCredits": credits,
+			"rewardedAt":    now,
+			"updated_at":    now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&referral)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrReferralNotDue
+		}
+		return nil, err
+	}
+
+	return &referral, nil
+}
+
+func UpdateReferralRewardLot(id primitive.ObjectID, lotId primitive.ObjectID) error {
+	_, err := mgm.Coll(&Referral{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"rewardLotId": lotId, "updated_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// ReferralStats summarises a referrer's referrals
+type ReferralStats struct {
+	Referred      int64 `json:"referred"`
+	Pending       int64 `json:"pending"`
+	Rewarded      int64 `json:"rewarded"`
+	UnderReview   int64 `json:"underReview"`
+	Rejected      int64 `json:"rejected"`
+	CreditsEarned int64 `json:"creditsEarned"`
+}
+
+func FetchReferralStats(referrerId primitive.ObjectID) (*ReferralStats, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"referrerId": referrerId}},
+		bson.M{"$group": bson.M{
+			"_id":     "$status",
+			"count":   bson.M{"$sum": 1},
+			"credits": bson.M{"$sum": "$rewardCredits"},
+		}},
+	}
+
+	cursor, err := mgm.Coll(&Referral{}).Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Status  string `bson:"_id"`
+		Count   int64  `bson:"count"`
+		Credits int64  `bson:"credits"`
+	}
+	if err := cursor.All(context.TODO(), &groups); err != nil {
+		return nil, err
+	}
+
+	stats := &ReferralStats{}
+	for _, group := range groups {
+		stats.Referred += group.Count
+		stats.CreditsEarned += group.Credits
+		switch group.Status {
+		case ReferralPending:
+			stats.Pending = group.Count
+		case ReferralRewarded:
+			stats.Rewarded = group.Count
+		case ReferralFlagged:
+			stats.UnderReview = group.Count
+		case ReferralRejected:
+			stats.Rejected = group.Count
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshToken is a server side record of an issued refresh token.
+// Only the sha256 hash of the token is stored.
+type RefreshToken struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	TokenHash  string     `json:"-" bson:"tokenHash"`
+	Subject    string     `json:"subject" bson:"subject"` // Admin username or user id
+	Role       string     `json:"role" bson:"role"`
+	FamilyId   string     `json:"familyId" bson:"familyId"` // Shared by every rotation of one login
+	ExpiresAt  time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt" bson:"revokedAt"`
+	ReplacedBy string     `json:"replacedBy" bson:"replacedBy"`
+}
+
+// TokenRevocation records the instant before which every access token of a subject is rejected
+type TokenRevocation struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Subject       string    `json:"subject" bson:"subject"`
+	RevokedBefore time.Time `json:"revokedBefore" bson:"revokedBefore"`
+}
+
+func NewRefreshToken(tokenHash string, subject string, role string, familyId string, expiresAt time.Time) *RefreshToken {
+	return &RefreshToken{
+		TokenHash: tokenHash,
+		Subject:   subject,
+		Role:      role,
+		FamilyId:  familyId,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func FetchRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+
+	err := mgm.Coll(&RefreshToken{}).First(bson.M{"tokenHash": tokenHash}, &token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ErrRefreshTokenClaimed is returned for tokens that are unknown, expired or already rotated
+var ErrRefreshTokenClaimed = errors.New("refresh token is not valid for rotation")
+
+// ClaimRefreshToken revokes an unexpired, unrevoked token and returns it.
+// Only one of several concurrent refreshes with the same token gets it;
+// the others get ErrRefreshTokenClaimed.
+func ClaimRefreshToken(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	now := time.Now()
+
+	err := mgm.Coll(&RefreshToken{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"tokenHash": tokenHash, "revokedAt": nil, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"revokedAt": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrRefreshTokenClaimed
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// SetRefreshTokenReplacement records the hash of the token that replaced a claimed one
+func SetRefreshTokenReplacement(tokenHash string, replacedBy string) error {
+	_, err := mgm.Coll(&RefreshToken{}).UpdateOne(
+		context.TODO(),
+		bson.M{"tokenHash": tokenHash},
+		bson.M{"$set": bson.M{"replacedBy": replacedBy}},
+	)
+	return err
+}
+
+// RevokeRefreshTokenFamily revokes every token descending from the same login
+func RevokeRefreshTokenFamily(familyId string) error {
+	now := time.Now()
+	_, err := mgm.Coll(&RefreshToken{}).UpdateMany(
+		context.TODO(),
+		bson.M{"familyId": familyId, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": now}},
+	)
+	return err
+}
+
+// RevokeAllTokensForSubject revokes every refresh token of the subject and
+// rejects any access token issued up to now
+func RevokeAllTokensForSubject(subject string) error {
+	now := time.Now()
+	_, err := mgm.Coll(&RefreshToken{}).UpdateMany(
+		context.TODO(),
+		bson.M{"subject": subject, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = mgm.Coll(&TokenRevocation{}).UpdateOne(
+		context.TODO(),
+		bson.M{"subject": subject},
+		bson.M{"$set": bson.M{"revokedBefore": revocationCutoff(now)}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// revocationCutoff rounds a revocation up to the next whole second. Access
+// tokens carry iat in whole seconds, so a token signed earlier in the same
+// second as the revocation would otherwise look newer than it.
+func revocationCutoff(revokedAt time.Time) time.Time {
+	return revokedAt.Truncate(time.Second).Add(time.Second)
+}
+
+// FetchRevokedBefore returns the revocation instant for a subject, or the zero time
+func FetchRevokedBefore(subject string) (time.Time, error) {
+	var revocation TokenRevocation
+
+	err := mgm.Coll(&TokenRevocation{}).First(bson.M{"subject": subject}, &revocation)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	return revocation.RevokedBefore, nil
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevocationCutoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		revokedAt time.Time
+		want      time.Time
+	}{
+		{"within a second", time.Date(2024, 5, 1, 10, 0, 0, 400*int(time.Millisecond), time.UTC), time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC)},
+		{"on a whole second", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := revocationCutoff(tt.revokedAt); !got.Equal(tt.want) {
+				t.Errorf("revocationCutoff(%v) = %v, want %v", tt.revokedAt, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/kamva/mgm/v3"
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,6 +13,52 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Payment states of a test. Lower case values are payment link statuses stored as received.
+const (
+	PaymentStatusPending       = "PENDING"
+	PaymentStatusBypass        = "BYPASS_PAYMENT" // The retired pMode pass, kept for old tests
+	PaymentStatusGranted       = "ADMIN_GRANT"    // Payment waived by an admin issued grant
+	PaymentStatusPartnerBilled = "PARTNER_BILLED"
+	PaymentStatusGuardianHold  = "AWAITING_GUARDIAN"
+	PaymentStatusDeclined      = "GUARDIAN_DECLINED"
+	PaymentStatusPaid          = "paid"
+	PaymentStatusExpired       = "expired"
+	PaymentStatusCancelled     = "cancelled"
+	PaymentStatusRefunded      = "refunded"
+	PaymentStatusPartRefunded  = "partially_refunded"
+	PaymentStatusCouponFree    = "COUPON_FREE"     // A coupon covered the whole price
+	PaymentStatusCredits       = "PREPAID_CREDITS" // Paid with a credit from a wallet
+	PaymentStatusVoucher       = "VOUCHER"         // Paid upfront by an institution through a voucher code
+	PaymentStatusGift          = "GIFT"            // Paid by someone else who gifted the test
+)
+
+// PayableStatuses are the states a payment can settle a test from. Paid,
+// refunded and waived tests are final, so replayed callbacks and late
+// webhooks leave them alone.
+var PayableStatuses = []string{PaymentStatusPending, PaymentStatusExpired, PaymentStatusCancelled, PaymentStatusGuardianHold}
+
+// IsPayableStatus reports whether a payment can still settle a test in status
+func IsPayableStatus(status string) bool {
+	for _, payable := range PayableStatuses {
+		if status == payable {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRefundedStatus reports whether a test's payment was given back in full or in part
+func IsRefundedStatus(status string) bool {
+	return status == PaymentStatusRefunded || status == PaymentStatusPartRefunded
+}
+
+// Report generation states of a test
+const (
+	ReportSentPending = "PENDING"
+	ReportSentDone    = "DONE"
+	ReportSentFailed  = "FAILED" // The narrative couldn't be generated, it is retried by a job
+)
+
 // Question model with fields for MongoDB
 type Test struct {
 	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
@@ -27,6 +74,29 @@ type Test struct {
 	PaymentLink       string             `json:"paymentLink" bson:"paymentLink"`
 	ExternalPaymentId string             `json:"externalPaymentId" bson:"externalPaymentId"`
 	ReportSent        string             `json:"reportSent" bson:"reportSent"`
+	OrganizationId    primitive.ObjectID `json:"organizationId,omitempty" bson:"organizationId,omitempty"` // Set when a partner created the test
+
+	PaymentLinkCreatedAt time.Time `json:"paymentLinkCreatedAt,omitempty" bson:"paymentLinkCreatedAt,omitempty"` // Set when the link was created after the test
+	ProviderPaymentId    string    `json:"providerPaymentId,omitempty" bson:"providerPaymentId,omitempty"`       // Payment that settled the link, e.g. pay_xxx
+	LastPaymentError     string    `json:"lastPaymentError,omitempty" bson:"lastPaymentError,omitempty"`         // Reason of the latest failed attempt
+	ReportRevoked        bool      `json:"reportRevoked,omitempty" bson:"reportRevoked,omitempty"`               // Set when a refund withdrew access to the report
+	ReportAttempts       int       `json:"reportAttempts,omitempty" bson:"reportAttempts,omitempty"`             // Failed attempts at generating the narrative
+	LastReportError      string    `json:"lastReportError,omitempty" bson:"lastReportError,omitempty"`
+	Amount               int64     `json:"amount,omitempty" bson:"amount,omitempty"` // Price charged in minor units, after any coupon
+	Currency             string    `json:"currency,omitempty" bson:"currency,omitempty"`
+	Country              string    `json:"country,omitempty" bson:"country,omitempty"` // Billing country the price was chosen for
+	ProductSku           string    `json:"productSku,omitempty" bson:"productSku,omitempty"`
+	Billing              *Billing  `json:"billing,omitempty" bson:"billing,omitempty"` // Invoicing details, when given
+	CouponCode           string    `json:"couponCode,omitempty" bson:"couponCode,omitempty"`
+
+	PaymentLinkRegenerations int       `json:"paymentLinkRegenerations,omitempty" bson:"paymentLinkRegenerations,omitempty"` // New links requested after submission
+	PaymentLinkRegeneratedAt time.Time `json:"paymentLinkRegeneratedAt,omitempty" bson:"paymentLinkRegeneratedAt,omitempty"`
+
+	CreditLotId  primitive.ObjectID `json:"creditLotId,omitempty" bson:"creditLotId,omitempty"` // Lot the test's credit came from
+	VoucherCode  string             `json:"voucherCode,omitempty" bson:"voucherCode,omitempty"`
+	GrantId      primitive.ObjectID `json:"grantId,omitempty" bson:"grantId,omitempty"` // Payment grant that waived the price
+	ReferralCode string             `json:"referralCode,omitempty" bson:"referralCode,omitempty"`
+	GiftId       primitive.ObjectID `json:"giftId,omitempty" bson:"giftId,omitempty"` // Gift that paid for the test
 }
 
 // NewQuestion creates a new instance of the Question model
@@ -56,6 +126,14 @@ func NewTest(
 	}
 }
 
+// PaymentLinkIssuedAt is when the current payment link was created
+func (t *Test) PaymentLinkIssuedAt() time.Time {
+	if !t.PaymentLinkCreatedAt.IsZero() {
+		return t.PaymentLinkCreatedAt
+	}
+	return t.CreatedAt
+}
+
 func FetchTestById(testId primitive.ObjectID) (*Test, error) {
 	var test Test
 
@@ -72,6 +150,71 @@ func FetchTestById(testId primitive.ObjectID) (*Test, error) {
 	return &test, nil
 }
 
+// CountTestsByUser is how many tests a user has taken
+func CountTestsByUser(userId primitive.ObjectID) (int64, error) {
+	return mgm.Coll(&Test{}).CountDocuments(context.TODO(), bson.M{"userId": userId})
+}
+
+// FetchTestByProviderPaymentId finds the test settled by a provider payment
+func FetchTestByProviderPaymentId(paymentId string) (*Test, error) {
+	var test Test
+
+	err := mgm.Coll(&Test{}).First(bson.M{"providerPaymentId": paymentId}, &test)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("test for payment %s not found", paymentId)
+		}
+		return nil, err
+	}
+
+	return &test, nil
+}
+
+// MarkTestPaid moves a payable test to paid exactly once. The boolean is
+// false when the test was already paid, refunded or waived, so callers only
+// start report generation once even when the callback and the webhook race.
+func MarkTestPaid(testId primitive.ObjectID, providerPaymentId string) (*Test, bool, error) {
+	var test Test
+
+	update := bson.M{
+		"$set": bson.M{
+			"paymentStatus":     PaymentStatusPaid,
+			"providerPaymentId": providerPaymentId,
+			"lastPaymentError":  "",
+		},
+	}
+
+	err := mgm.Coll(&Test{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": testId, "paymentStatus": bson.M{"$in": PayableStatuses}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&test)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			existing, fetchErr := FetchTestById(testId)
+			if fetchErr != nil {
+				return nil, false, fetchErr
+			}
+			return existing, false, nil
+		}
+		return nil, false, err
+	}
+
+	return &test, true, nil
+}
+
+// UpdateTestPaymentError records a failed payment attempt. The link stays payable.
+func UpdateTestPaymentError(testId primitive.ObjectID, reason string) error {
+	_, err := mgm.Coll(&Test{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": testId},
+		bson.M{"$set": bson.M{"lastPaymentError": reason}},
+	)
+	return err
+}
+
 func UpdateTestPaymentStatus(testId primitive.ObjectID, status string) (*Test, error) {
 	var test Test
 
@@ -100,6 +243,126 @@ func UpdateTestPaymentStatus(testId primitive.ObjectID, status string) (*Test, e
 	return &test, nil
 }
 
+// ClosePendingTestPayment moves a test still awaiting payment through linkId
+// to status, e.g. expired. It is a no-op returning false when the test was
+// settled meanwhile or has been given another link.
+func ClosePendingTestPayment(testId primitive.ObjectID, linkId string, status string) (bool, error) {
+	result, err := mgm.Coll(&Test{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": testId, "externalPaymentId": linkId, "paymentStatus": PaymentStatusPending},
+		bson.M{"$set": bson.M{"paymentStatus": status}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// ClaimPaymentLinkRegeneration counts a request for a new payment link. It
+// fails when the test is not awaiting payment, has had maxRegenerations new
+// links already, or had one after notBefore, so concurrent requests can't
+// get past the limit.
+func ClaimPaymentLinkRegeneration(testId primitive.ObjectID, notBefore time.Time, maxRegenerations int) (*Test, error) {
+	var test Test
+
+	err := mgm.Coll(&Test{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{
+			"_id":                      testId,
+			"paymentStatus":            bson.M{"$in": []string{PaymentStatusPending, PaymentStatusExpired, PaymentStatusCancelled}},
+			"paymentLinkRegenerations": bson.M{"$not": bson.M{"$gte": maxRegenerations}},
+			"paymentLinkRegeneratedAt": bson.M{"$not": bson.M{"$gt": notBefore}},
+		},
+		bson.M{
+			"$inc": bson.M{"paymentLinkRegenerations": 1},
+			"$set": bson.M{"paymentLinkRegeneratedAt": time.Now().UTC()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&test)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("payment link can't be regenerated now")
+		}
+		return nil, err
+	}
+
+	return &test, nil
+}
+
+// FetchTestsAwaitingPayment returns tests with an open payment link
+func FetchTestsAwaitingPayment() ([]Test, error) {
+	tests := []Test{}
+	err := mgm.Coll(&Test{}).SimpleFind(&tests, bson.M{
+		"paymentStatus":     PaymentStatusPending,
+		"externalPaymentId": bson.M{"$ne": ""},
+	})
+	return tests, err
+}
+
+// RecordReportFailure marks the report of a test for another attempt
+func RecordReportFailure(testId primitive.ObjectID, reason string) error {
+	_, err := mgm.Coll(&Test{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": testId},
+		bson.M{
+			"$set": bson.M{"reportSent": ReportSentFailed, "lastReportError": reason},
+			"$inc": bson.M{"reportAttempts": 1},
+		},
+	)
+	return err
+}
+
+// FetchTestsWithFailedReports returns tests whose report failed fewer than maxAttempts times
+func FetchTestsWithFailedReports(maxAttempts int) ([]Test, error) {
+	tests := []Test{}
+	err := mgm.Coll(&Test{}).SimpleFind(&tests, bson.M{
+		"reportSent":     ReportSentFailed,
+		"reportAttempts": bson.M{"$lt": maxAttempts},
+	})
+	return tests, err
+}
+
+// UpdateTestPaymentLink stores a newly created payment link and resets the status to PENDING
+func UpdateTestPaymentLink(testId primitive.ObjectID, paymentLink string, externalPaymentId string) (*Test, error) {
+	var test Test
+
+	update := bson.M{
+		"$set": bson.M{
+			"paymentLink":          paymentLink,
+			"externalPaymentId":    externalPaymentId,
+			"paymentStatus":        PaymentStatusPending,
+			"paymentLinkCreatedAt": time.Now().UTC(),
+		},
+	}
+
+	err := mgm.Coll(&Test{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": testId},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&test)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no document found with the given ID")
+		}
+		return nil, err
+	}
+
+	return &test, nil
+}
+
+// UpdateTestReportRevoked withdraws or restores access to the test's report
+func UpdateTestReportRevoked(testId primitive.ObjectID, revoked bool) error {
+	_, err := mgm.Coll(&Test{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": testId},
+		bson.M{"$set": bson.M{"reportRevoked": revoked}},
+	)
+	return err
+}
+
 func UpdateTestReportSent(testId primitive.ObjectID, reportSent string) (*Test, error) {
 	var test Test
 
@@ -0,0 +1,25 @@
+package models
+
+import "testing"
+
+func TestIsPayableStatus(t *testing.T) {
+	cases := map[string]bool{
+		PaymentStatusPending:      true,
+		PaymentStatusExpired:      true,
+		PaymentStatusCancelled:    true,
+		PaymentStatusGuardianHold: true,
+		PaymentStatusPaid:         false,
+		PaymentStatusRefunded:     false,
+		PaymentStatusPartRefunded: false,
+		PaymentStatusDeclined:     false,
+		PaymentStatusCouponFree:   false,
+		PaymentStatusGranted:      false,
+		PaymentStatusGift:         false,
+	}
+
+	for status, want := range cases {
+		if got := IsPayableStatus(status); got != want {
+			t.Errorf("IsPayableStatus(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UserIdentity links an external identity provider account to a User
+type UserIdentity struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	UserId          primitive.ObjectID `json:"userId" bson:"userId"`
+	Provider        string             `json:"provider" bson:"provider"`               // e.g. google
+	ProviderSubject string             `json:"providerSubject" bson:"providerSubject"` // The "sub" claim of the ID token
+	Email           string             `json:"email" bson:"email"`
+}
+
+func NewUserIdentity(userId primitive.ObjectID, provider string, providerSubject string, email string) *UserIdentity {
+	return &UserIdentity{
+		UserId:          userId,
+		Provider:        provider,
+		ProviderSubject: providerSubject,
+		Email:           email,
+	}
+}
+
+func FetchUserIdentity(provider string, providerSubject string) (*UserIdentity, error) {
+	var identity UserIdentity
+
+	err := mgm.Coll(&UserIdentity{}).First(bson.M{"provider": provider, "providerSubject": providerSubject}, &identity)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("identity not found")
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
@@ -16,14 +16,24 @@ type User struct {
 	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
 	mgm.DefaultModel `bson:",inline"`
 
-	Name             string `json:"name" bson:"name"`   // Name of the test
-	Email            string `json:"email" bson:"email"` // The actual question text
-	Gender           string `json:"gender" bson:"gender"`
-	Age              int    `json:"age" bson:"age"`
-	Password         string `json:"password" bson:"password"`
-	Role             string `json:"role" bson:"role"`
-	Status           string `json:"status" bson:"status"`
-	OnBoardingStatus string `json:"onboarding_status" bson:"onboarding_status"`
+	Name             string   `json:"name" bson:"name"`   // Name of the test
+	Email            string   `json:"email" bson:"email"` // The actual question text
+	Gender           string   `json:"gender" bson:"gender"`
+	Age              int      `json:"age" bson:"age"`
+	Password         string   `json:"password" bson:"password"`
+	Role             string   `json:"role" bson:"role"`
+	Status           string   `json:"status" bson:"status"`
+	OnBoardingStatus string   `json:"onboarding_status" bson:"onboarding_status"`
+	Profile          *Profile `json:"profile,omitempty" bson:"profile,omitempty"`
+}
+
+// Profile is collected during onboarding and used to personalise reports
+type Profile struct {
+	EducationLevel string   `json:"educationLevel" bson:"educationLevel"` // e.g. CLASS_10, CLASS_12, UNDERGRADUATE, POSTGRADUATE
+	Stream         string   `json:"stream" bson:"stream"`                 // e.g. Science, Commerce, Arts
+	Occupation     string   `json:"occupation" bson:"occupation"`         // e.g. Student, Software Engineer
+	Goals          []string `json:"goals" bson:"goals"`
+	City           string   `json:"city" bson:"city"`
 }
 
 // NewQuestion creates a new instance of the Question model
@@ -46,7 +56,7 @@ func UpdateUserOnBoardingStatus(userId primitive.ObjectID, onBoardingStatus stri
 	// Define the update document
 	update := bson.M{
 		"$set": bson.M{
-			"OnBoardingStatus": onBoardingStatus,
+			"onboarding_status": onBoardingStatus,
 		},
 	}
 
@@ -68,6 +78,19 @@ func UpdateUserOnBoardingStatus(userId primitive.ObjectID, onBoardingStatus stri
 	return &user, nil
 }
 
+// UpdateUserProfile stores the onboarding profile and marks onboarding complete
+func UpdateUserProfile(userId primitive.ObjectID, profile Profile) (*User, error) {
+	if _, err := mgm.Coll(&User{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": userId},
+		bson.M{"$set": bson.M{"profile": profile}},
+	); err != nil {
+		return nil, err
+	}
+
+	return UpdateUserOnBoardingStatus(userId, "COMPLETED")
+}
+
 func FetchUserUsingId(id primitive.ObjectID) User {
 	collRef := mgm.Coll(&User{})
 
@@ -78,3 +101,17 @@ func FetchUserUsingId(id primitive.ObjectID) User {
 	return user
 
 }
+
+func FetchUserByEmail(email string) (*User, error) {
+	var user User
+
+	err := mgm.Coll(&User{}).First(bson.M{"email": email}, &user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no user found with the given email")
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,277 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a voucher
+const (
+	VoucherAvailable = "AVAILABLE"
+	VoucherRedeemed  = "REDEEMED"
+	VoucherVoid      = "VOID" // Its batch was deactivated before it was used
+)
+
+// VoucherBatch is a set of single use codes an organization paid for upfront,
+// e.g. a school buying reports for a class
+type VoucherBatch struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	OrganizationId primitive.ObjectID `json:"organizationId" bson:"organizationId"`
+	Name           string             `json:"name" bson:"name"` // e.g. "Class 10 B, 2026"
+	TestName       string             `json:"testName" bson:"testName"`
+	Quantity       int                `json:"quantity" bson:"quantity"`
+	ValidUntil     time.Time          `json:"validUntil,omitempty" bson:"validUntil,omitempty"` // Zero means no end
+	CreatedBy      string             `json:"createdBy" bson:"createdBy"`
+	Active         bool               `json:"active" bson:"active"`
+}
+
+func NewVoucherBatch(organizationId primitive.ObjectID, name string, testName string, quantity int, validUntil time.Time, createdBy string) *VoucherBatch {
+	return &VoucherBatch{
+		OrganizationId: organizationId,
+		Name:           name,
+		TestName:       testName,
+		Quantity:       quantity,
+		ValidUntil:     validUntil,
+		CreatedBy:      createdBy,
+		Active:         true,
+	}
+}
+
+// Voucher is one code of a batch. It pays for a single test.
+type Voucher struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	BatchId    primitive.ObjectID `json:"batchId" bson:"batchId"`
+	Code       string             `json:"code" bson:"code"` // Stored upper case
+	TestName   string             `json:"testName" bson:"testName"`
+	Status     string             `json:"status" bson:"status"`
+	TestId     primitive.ObjectID `json:"testId,omitempty" bson:"testId,omitempty"`
+	UserId     primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	RedeemedAt time.Time          `json:"redeemedAt,omitempty" bson:"redeemedAt,omitempty"`
+}
+
+func NewVoucher(batch *VoucherBatch, code string) *Voucher {
+	return &Voucher{
+		BatchId:  batch.ID,
+		Code:     NormalizeCouponCode(code),
+		TestName: batch.TestName,
+		Status:   VoucherAvailable,
+	}
+}
+
+// CreateVoucherBatch stores a batch with its codes
+func CreateVoucherBatch(batch *VoucherBatch, codes []string) error {
+	if err := mgm.Coll(batch).Create(batch); err != nil {
+		return err
+	}
+
+	vouchers := make([]interface{}, 0, len(codes))
+	now := time.Now().UTC()
+	for _, code := range codes {
+		voucher := NewVoucher(batch, code)
+		voucher.ID = primitive.NewObjectID()
+		voucher.CreatedAt = now
+		voucher.UpdatedAt = now
+		vouchers = append(vouchers, voucher)
+	}
+
+	_, err := mgm.Coll(&Voucher{}).InsertMany(context.TODO(), vouchers)
+	return err
+}
+
+func FetchVoucherBatchById(id primitive.ObjectID) (*VoucherBatch, error) {
+	var batch VoucherBatch
+
+	err := mgm.Coll(&VoucherBatch{}).FindByID(id, &batch)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("voucher batch with ID %s not found", id.Hex())
+		}
+		return nil, err
+	}
+
+	return &batch, nil
+}
+
+// FetchVoucherBatches lists batches, newest first, optionally of one organization
+func FetchVoucherBatches(organizationId primitive.ObjectID) ([]VoucherBatch, error) {
+	filter := bson.M{}
+	if !organizationId.IsZero() {
+		filter["organizationId"] = organizationId
+	}
+
+	batches := []VoucherBatch{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if err := mgm.Coll(&VoucherBatch{}).SimpleFind(&batches, filter, findOptions); err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// FetchVouchersByBatch returns every code of a batch in creation order
+func FetchVouchersByBatch(batchId primitive.ObjectID) ([]Voucher, error) {
+	vouchers := []Voucher{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if err := mgm.Coll(&Voucher{}).SimpleFind(&vouchers, bson.M{"batchId": batchId}, findOptions); err != nil {
+		return nil, err
+	}
+	return vouchers, nil
+}
+
+func FetchVoucherByCode(code string) (*Voucher, error) {
+	var voucher Voucher
+
+	err := mgm.Coll(&Voucher{}).First(bson.M{"code": NormalizeCouponCode(code)}, &voucher)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("voucher %s not found", code)
+		}
+		return nil, err
+	}
+
+	return &voucher, nil
+}
+
+// ErrVoucherUsed is returned when a voucher was redeemed or voided already
+var ErrVoucherUsed = errors.New("voucher has already been used")
+
+// RedeemVoucher marks an available voucher as used by the test, atomically,
+// so two submissions can't share a code
+func RedeemVoucher(voucherId primitive.ObjectID, testId primitive.ObjectID, userId primitive.ObjectID) (*Voucher, error) {
+	var voucher Voucher
+
+	err := mgm.Coll(&Voucher{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": voucherId, "status": VoucherAvailable},
+		bson.M{"$set": bson.M{
+			"status":     VoucherRedeemed,
+			"testId":     testId,
+			"userId":     userId,
+			"redeemedAt": time.Now().UTC(),
+			"updated_at": time.Now().UTC(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&voucher)
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrVoucherUsed
+		}
+		return nil, err
+	}
+
+	return &voucher, nil
+}
+
+// ReleaseVoucher makes a voucher available again after the submission that
+// redeemed it failed
+func ReleaseVoucher(voucherId primitive.ObjectID, testId primitive.ObjectID) error {
+	_, err := mgm.Coll(&Voucher{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": voucherId, "status": VoucherRedeemed, "testId": testId},
+		bson.M{
+			"$set":   bson.M{"status": VoucherAvailable, "updated_at": time.Now().UTC()},
+			"$unset": bson.M{"testId": "", "userId": "", "redeemedAt": ""},
+		},
+	)
+	return err
+}
+
+// DeactivateVoucherBatch stops a batch and voids its unused codes. It
+// returns how many codes were voided.
+func DeactivateVoucherBatch(batchId primitive.ObjectID) (*VoucherBatch, int64, error) {
+	var batch VoucherBatch
+
+	err := mgm.Coll(&VoucherBatch{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"_id": batchId},
+		bson.M{"$set": bson.M{"active": false, "updated_at": time.Now().UTC()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&batch)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, 0, fmt.Errorf("no document found with the given ID")
+		}
+		return nil, 0, err
+	}
+
+	result, err := mgm.Coll(&Voucher{}).UpdateMany(
+		context.TODO(),
+		bson.M{"batchId": batchId, "status": VoucherAvailable},
+		bson.M{"$set": bson.M{"status": VoucherVoid, "updated_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &batch, result.ModifiedCount, nil
+}
+
+// VoucherBatchUsage summarises how much of a batch was used
+type VoucherBatchUsage struct {
+	Quantity       int       `json:"quantity"`
+	Available      int64     `json:"available"`
+	Redeemed       int64     `json:"redeemed"`
+	Void           int64     `json:"void"`
+	ReportsSent    int64     `json:"reportsSent"` // Redeemed tests whose report was delivered
+	LastRedeemedAt time.Time `json:"lastRedeemedAt,omitempty"`
+}
+
+func FetchVoucherBatchUsage(batch *VoucherBatch) (*VoucherBatchUsage, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"batchId": batch.ID}},
+		bson.M{"$group": bson.M{
+			"_id":            "$status",
+			"count":          bson.M{"$sum": 1},
+			"lastRedeemedAt": bson.M{"$max": "$redeemedAt"},
+			"testIds":        bson.M{"$push": "$testId"},
+		}},
+	}
+
+	cursor, err := mgm.Coll(&Voucher{}).Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Status         string               `bson:"_id"`
+		Count          int64                `bson:"count"`
+		LastRedeemedAt time.Time            `bson:"lastRedeemedAt"`
+		TestIds        []primitive.ObjectID `bson:"testIds"`
+	}
+	if err := cursor.All(context.TODO(), &groups); err != nil {
+		return nil, err
+	}
+
+	usage := &VoucherBatchUsage{Quantity: batch.Quantity}
+	for _, group := range groups {
+		switch group.Status {
+		case VoucherAvailable:
+			usage.Available = group.Count
+		case VoucherVoid:
+			usage.Void = group.Count
+		case VoucherRedeemed:
+			usage.Redeemed = group.Count
+			usage.LastRedeemedAt = group.LastRedeemedAt
+
+			usage.ReportsSent, err = mgm.Coll(&Test{}).CountDocuments(context.TODO(), bson.M{"_id": bson.M{"$in": group.TestIds}, "reportSent": "DONE"})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return usage, nil
+}
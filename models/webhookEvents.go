@@ -0,0 +1,126 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookEvent records a provider event so retried deliveries are handled once
+type WebhookEvent struct {
+	// DefaultModel includes the MongoDB ID (_id), createdAt, and updatedAt fields.
+	mgm.DefaultModel `bson:",inline"`
+
+	Provider    string    `json:"provider" bson:"provider"`
+	EventId     string    `json:"eventId" bson:"eventId"`
+	Event       string    `json:"event" bson:"event"`     // e.g. payment_link.paid
+	Payload     string    `json:"payload" bson:"payload"` // Personal fields are redacted before it is stored
+	Status      string    `json:"status" bson:"status"`   // RECEIVED, PROCESSED or FAILED
+	Error       string    `json:"error,omitempty" bson:"error,omitempty"`
+	ClaimedAt   time.Time `json:"claimedAt,omitempty" bson:"claimedAt,omitempty"` // When a delivery started processing it
+	ProcessedAt time.Time `json:"processedAt,omitempty" bson:"processedAt,omitempty"`
+}
+
+func NewWebhookEvent(provider string, eventId string, event string, payload string) *WebhookEvent {
+	return &WebhookEvent{
+		Provider: provider,
+		EventId:  eventId,
+		Event:    event,
+		Payload:  payload,
+		Status:   "RECEIVED",
+	}
+}
+
+// WebhookClaimTimeout is how long a delivery may stay RECEIVED before a
+// retry takes it over, in case the process handling it died
+const WebhookClaimTimeout = 5 * time.Minute
+
+// EnsureWebhookEventIndexes creates the unique (provider, eventId) index that
+// ClaimWebhookEvent relies on to see concurrent deliveries as one event
+func EnsureWebhookEventIndexes() error {
+	_, err := mgm.Coll(&WebhookEvent{}).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "eventId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// ClaimWebhookEvent stores an incoming event and reports whether this
+// delivery should process it. A new event is claimed by whoever inserts it;
+// an existing one only when it FAILED or its claim has gone stale, so a
+// retry arriving while the first delivery is still running is a duplicate.
+func ClaimWebhookEvent(provider string, eventId string, event string, payload string) (*WebhookEvent, bool, error) {
+	now := time.Now().UTC()
+	id := primitive.NewObjectID()
+	filter := bson.M{"provider": provider, "eventId": eventId}
+
+	err := mgm.Coll(&WebhookEvent{}).FindOneAndUpdate(
+		context.TODO(),
+		filter,
+		bson.M{"$setOnInsert": bson.M{
+			"_id":        id,
+			"provider":   provider,
+			"eventId":    eventId,
+			"event":      event,
+			"payload":    payload,
+			"status":     "RECEIVED",
+			"claimedAt":  now,
+			"created_at": now,
+			"updated_at": now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Nothing was there before, so this delivery inserted it
+		webhookEvent := NewWebhookEvent(provider, eventId, event, payload)
+		webhookEvent.ID = id
+		webhookEvent.ClaimedAt = now
+		return webhookEvent, true, nil
+	}
+	// A concurrent upsert of the same event loses on the unique index
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, false, err
+	}
+
+	var existing WebhookEvent
+	err = mgm.Coll(&WebhookEvent{}).FindOneAndUpdate(
+		context.TODO(),
+		bson.M{
+			"provider": provider,
+			"eventId":  eventId,
+			"$or": bson.A{
+				bson.M{"status": "FAILED"},
+				bson.M{"status": "RECEIVED", "claimedAt": bson.M{"$lt": now.Add(-WebhookClaimTimeout)}},
+			},
+		},
+		bson.M{"$set": bson.M{"status": "RECEIVED", "claimedAt": now, "updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &existing, true, nil
+}
+
+// UpdateWebhookEventStatus marks an event PROCESSED or FAILED
+func UpdateWebhookEventStatus(id primitive.ObjectID, status string, errorMessage string) error {
+	_, err := mgm.Coll(&WebhookEvent{}).UpdateOne(
+		context.TODO(),
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"status":      status,
+			"error":       errorMessage,
+			"processedAt": time.Now().UTC(),
+		}},
+	)
+	return err
+}
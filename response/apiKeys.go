@@ -0,0 +1,16 @@
+package response
+
+// Define the struct for creating an organization
+type Organization struct {
+	Name         string `json:"name" binding:"required"`
+	Type         string `json:"type"`
+	ContactEmail string `json:"contactEmail"`
+}
+
+// Define the struct for issuing a partner API key
+type ApiKey struct {
+	OrganizationId string   `json:"organizationId" binding:"required"`
+	Name           string   `json:"name"`
+	Scopes         []string `json:"scopes" binding:"required"`
+	MonthlyQuota   int      `json:"monthlyQuota"` // 0 means unlimited
+}
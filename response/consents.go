@@ -0,0 +1,22 @@
+package response
+
+// Define the struct for an accepted consent document version
+type ConsentAcceptance struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+// Define the struct for publishing a consent document version
+type ConsentDocument struct {
+	Type     string `json:"type" binding:"required"`
+	Version  string `json:"version" binding:"required"`
+	Title    string `json:"title"`
+	Url      string `json:"url"`
+	Required bool   `json:"required"`
+}
+
+// Define the struct for granting or withdrawing consents from the dashboard
+type ConsentChange struct {
+	Consents []ConsentAcceptance `json:"consents"` // Used when granting
+	Types    []string            `json:"types"`    // Used when withdrawing
+}
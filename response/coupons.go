@@ -0,0 +1,17 @@
+package response
+
+import "time"
+
+// Define the struct for creating a coupon
+type Coupon struct {
+	Code           string    `json:"code" binding:"required"`
+	Description    string    `json:"description"`
+	DiscountType   string    `json:"discountType" binding:"required"`  // PERCENT or FLAT
+	DiscountValue  int64     `json:"discountValue" binding:"required"` // Percentage, or minor units for FLAT
+	Currency       string    `json:"currency"`                         // FLAT only, defaults to INR
+	ValidFrom      time.Time `json:"validFrom"`                        // Defaults to now
+	ValidUntil     time.Time `json:"validUntil"`                       // Omit for no end date
+	MaxRedemptions int64     `json:"maxRedemptions"`                   // 0 means unlimited
+	PerUserLimit   int64     `json:"perUserLimit"`                     // 0 means unlimited
+	TestNames      []string  `json:"testNames"`                        // Omit for every test
+}
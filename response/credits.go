@@ -0,0 +1,18 @@
+package response
+
+// Define the struct for buying a credit bundle
+type CreditPurchase struct {
+	Sku      string `json:"sku" binding:"required"`
+	Currency string `json:"currency"` // Chosen from the country when empty
+	Country  string `json:"country"`  // Billing country, e.g. IN; detected from the request when empty
+}
+
+// Define the struct for an admin grant of credits
+type CreditGrant struct {
+	OwnerType    string `json:"ownerType" binding:"required"` // USER or ORGANIZATION
+	OwnerId      string `json:"ownerId" binding:"required"`
+	TestName     string `json:"testName"` // BIG_5 by default
+	Credits      int    `json:"credits" binding:"required"`
+	ValidityDays int    `json:"validityDays"` // 365 by default
+	Note         string `json:"note"`
+}
@@ -0,0 +1,11 @@
+package response
+
+// Define the struct for gifting a test
+type Gift struct {
+	RecipientName  string `json:"recipientName" binding:"required"`
+	RecipientEmail string `json:"recipientEmail" binding:"required,email"`
+	Message        string `json:"message"`  // Optional note included in the invitation
+	Tier           string `json:"tier"`     // Report tier from the catalog, STANDARD by default
+	Currency       string `json:"currency"` // Chosen from the country when empty
+	Country        string `json:"country"`  // Billing country, e.g. IN; detected from the request when empty
+}
@@ -0,0 +1,9 @@
+package response
+
+// Define the struct for issuing a payment grant
+type PaymentGrant struct {
+	Reason     string `json:"reason" binding:"required"` // Recorded with every use, e.g. "Support ticket 1234"
+	TestName   string `json:"testName"`                  // BIG_5 by default
+	Email      string `json:"email"`                     // Optional, restricts the grant to this user
+	ValidHours int    `json:"validHours"`                // 72 by default, at most 720
+}
@@ -0,0 +1,8 @@
+package response
+
+// Define the struct for an admin refund
+type Refund struct {
+	Amount       int64  `json:"amount"` // In paise, 0 refunds whatever is left
+	Reason       string `json:"reason" binding:"required"`
+	RevokeReport bool   `json:"revokeReport"`
+}
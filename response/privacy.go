@@ -0,0 +1,8 @@
+package response
+
+// Define the struct for an erasure request
+type Erasure struct {
+	Reason    string `json:"reason"`
+	Mode      string `json:"mode"`      // ANONYMISE (default) or DELETE
+	Immediate bool   `json:"immediate"` // Admin only, skips the grace period
+}
@@ -0,0 +1,16 @@
+package response
+
+// Define the struct for creating or replacing a catalog product
+type Product struct {
+	Sku         string           `json:"sku" binding:"required"`
+	Name        string           `json:"name" binding:"required"`
+	Description string           `json:"description"`
+	TestName    string           `json:"testName" binding:"required"`
+	Tier        string           `json:"tier"`                      // STANDARD by default
+	Prices      map[string]int64 `json:"prices" binding:"required"` // e.g. {"INR": 21000}, minor units
+	TaxClass    string           `json:"taxClass"`                  // e.g. GST_18
+	HsnSac      string           `json:"hsnSac"`
+
+	Credits            int `json:"credits"`            // Makes the product a bundle of this many credits
+	CreditValidityDays int `json:"creditValidityDays"` // How long bundle credits last, 365 days by default
+}
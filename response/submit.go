@@ -6,6 +6,31 @@ type Answers struct {
 	Answer string `json:"answer"`
 }
 
+// Define the struct for a guardian of a test taker under the consent age
+type Guardian struct {
+	Name         string `json:"name"`
+	Email        string `json:"email"`
+	Relationship string `json:"relationship"`
+}
+
+// Define the struct for the onboarding profile
+type Profile struct {
+	EducationLevel string   `json:"educationLevel"`
+	Stream         string   `json:"stream"`
+	Occupation     string   `json:"occupation"`
+	Goals          []string `json:"goals"`
+	City           string   `json:"city"`
+}
+
+// Define the struct for invoicing details
+type Billing struct {
+	Name    string `json:"name"`
+	Gstin   string `json:"gstin"` // B2B buyers only
+	Address string `json:"address"`
+	State   string `json:"state"`   // GST state code, e.g. 29 for Karnataka
+	Country string `json:"country"` // ISO 3166 code, e.g. IN; prices are in its currency
+}
+
 // Define the main struct
 type Submit struct {
 	Email   string    `json:"email"`
@@ -13,5 +38,18 @@ type Submit struct {
 	Age     int       `json:"age"`    // Assuming age is an integer
 	Gender  string    `json:"gender"` // Assuming gender is a string
 	Answers []Answers `json:"answers"`
-	PMode   string    `json:"pMode"`
+
+	Consents []ConsentAcceptance `json:"consents"` // Consent document versions the user accepted
+	Guardian *Guardian           `json:"guardian"` // Required below GUARDIAN_CONSENT_AGE
+	Profile  *Profile            `json:"profile"`  // Optional onboarding profile, saved on the user
+
+	CouponCode   string `json:"couponCode"`   // Optional promo code
+	VoucherCode  string `json:"voucherCode"`  // Optional prepaid voucher from an institution, instead of paying
+	GrantToken   string `json:"grantToken"`   // Optional admin issued payment grant, instead of paying
+	ReferralCode string `json:"referralCode"` // Optional code of the user who referred the test taker
+	GiftToken    string `json:"giftToken"`    // Optional token from a gift invitation, instead of paying
+	Tier         string `json:"tier"`         // Report tier from the catalog, STANDARD by default
+	Currency     string `json:"currency"`     // Chosen from the billing country when empty
+
+	Billing *Billing `json:"billing"` // Optional, printed on the tax invoice
 }
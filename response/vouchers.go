@@ -0,0 +1,12 @@
+package response
+
+import "time"
+
+// Define the struct for generating a batch of voucher codes
+type VoucherBatch struct {
+	OrganizationId string    `json:"organizationId" binding:"required"`
+	Name           string    `json:"name"`
+	TestName       string    `json:"testName"` // BIG_5 by default
+	Quantity       int       `json:"quantity" binding:"required"`
+	ValidUntil     time.Time `json:"validUntil"` // Optional
+}
@@ -0,0 +1,181 @@
+package routers
+
+import (
+	"log"
+	"myproject/libs"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var allowedApiKeyScopes = map[string]bool{
+	models.ScopeTestsCreate:   true,
+	models.ScopeReportsRead:   true,
+	models.ScopeCreditsManage: true,
+	models.ScopeVouchersRead:  true,
+}
+
+// CreateOrganization registers a B2B partner
+func CreateOrganization(c *gin.Context) {
+	var request response.Organization
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization data"})
+		return
+	}
+
+	organization := models.NewOrganization(request.Name, request.Type, request.ContactEmail, "ACTIVE")
+	if err := mgm.Coll(organization).Create(organization); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// ListOrganizations returns every registered partner
+func ListOrganizations(c *gin.Context) {
+	organizations := []models.Organization{}
+	if err := mgm.Coll(&models.Organization{}).SimpleFind(&organizations, bson.M{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organizations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organizations)
+}
+
+// generateApiKey returns a new plaintext key and its display prefix
+func generateApiKey() (string, string, error) {
+	secret, err := libs.GenerateOpaqueToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	key := "msk_" + secret
+	return key, key[:12], nil
+}
+
+// IssueApiKey creates a key for an organization. The plaintext key is only returned here.
+func IssueApiKey(c *gin.Context) {
+	var request response.ApiKey
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key request"})
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !allowedApiKeyScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+	}
+
+	organizationId, err := primitive.ObjectIDFromHex(request.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	if _, err := models.FetchOrganizationById(organizationId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, prefix, err := generateApiKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	apiKey := models.NewApiKey(organizationId, request.Name, prefix, libs.HashToken(key), request.Scopes, request.MonthlyQuota)
+	if err := mgm.Coll(apiKey).Create(apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKey": apiKey, "key": key})
+}
+
+// ListApiKeys returns the keys of an organization, or all keys, with their usage counters
+func ListApiKeys(c *gin.Context) {
+	filter := bson.M{}
+	if organizationIdString := c.Query("organizationId"); organizationIdString != "" {
+		organizationId, err := primitive.ObjectIDFromHex(organizationIdString)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		filter["organizationId"] = organizationId
+	}
+
+	apiKeys := []models.ApiKey{}
+	if err := mgm.Coll(&models.ApiKey{}).SimpleFind(&apiKeys, filter); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, apiKeys)
+}
+
+// RotateApiKey issues a replacement key with the same scopes and usage, then revokes the old one
+func RotateApiKey(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	oldKey, err := models.FetchApiKeyById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if oldKey.Status != "ACTIVE" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API key is already revoked"})
+		return
+	}
+
+	key, prefix, err := generateApiKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	// Usage carries over so rotating can't be used to reset the quota
+	newKey := models.NewApiKey(oldKey.OrganizationId, oldKey.Name, prefix, libs.HashToken(key), oldKey.Scopes, oldKey.MonthlyQuota)
+	newKey.UsagePeriod = oldKey.UsagePeriod
+	newKey.UsageCount = oldKey.UsageCount
+	newKey.TotalUsage = oldKey.TotalUsage
+	newKey.RotatedFrom = oldKey.ID
+	if err := mgm.Coll(newKey).Create(newKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store API key"})
+		return
+	}
+
+	if _, err := models.RevokeApiKey(oldKey.ID); err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke old API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKey": newKey, "key": key})
+}
+
+// RevokeApiKey disables a key immediately
+func RevokeApiKey(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	apiKey, err := models.RevokeApiKey(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, apiKey)
+}
@@ -1,25 +1,80 @@
 package routers
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"myproject/libs"
+	"myproject/middlewares"
+	"myproject/models"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
 )
 
-// JWT secret key
-var jwtSecret = []byte(os.Getenv("JWT_SECRET_KEY"))
-
 // UserCredentials represents the JSON structure for user authentication
 type UserCredentials struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
+// RefreshRequest carries the opaque refresh token issued alongside an access token
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+func accessTokenDuration() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func refreshTokenDuration() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_HOURS"))
+	if err != nil || hours <= 0 {
+		fmt.Println("Invalid REFRESH_TOKEN_HOURS, defaulting to 720 hours")
+		hours = 720
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// issueTokenPair signs an access token and stores a new refresh token in the given family
+func issueTokenPair(subject string, role string, familyId string) (gin.H, error) {
+	accessToken, err := libs.SignAccessToken(subject, role, accessTokenDuration())
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := libs.GenerateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	if familyId == "" {
+		familyId, err = libs.GenerateOpaqueToken(16)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	record := models.NewRefreshToken(libs.HashToken(refreshToken), subject, role, familyId, time.Now().Add(refreshTokenDuration()))
+	if err := mgm.Coll(record).Create(record); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(accessTokenDuration().Seconds()),
+	}, nil
+}
+
 // Authenticate handles user authentication and generates a JWT token
 func Authenticate(c *gin.Context) {
 	println("Authenticate")
@@ -34,31 +89,113 @@ func Authenticate(c *gin.Context) {
 		return
 	}
 
-	durationStr := os.Getenv("DURATION_HOURS")
-
-	durationHours, err := strconv.Atoi(durationStr)
+	tokens, err := issueTokenPair(creds.Username, "ADMIN", "")
 	if err != nil {
-		fmt.Println("Invalid DURATION_HOURS, defaulting to 72 hours")
-		durationHours = 72
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
 
-	// Add the duration to the current time
-	futureTime := time.Now().Add(time.Duration(durationHours) * time.Hour)
+	c.JSON(http.StatusOK, tokens)
+}
 
-	// Convert to Unix timestamp
-	unixTime := futureTime.Unix()
+// RefreshAccessToken rotates a refresh token. Presenting a token that was
+// already rotated revokes its whole family, since it has likely leaked.
+func RefreshAccessToken(c *gin.Context) {
+	var request RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
 
-	// Create JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": creds.Username,
-		"exp":      unixTime,
-	})
+	tokenHash := libs.HashToken(request.RefreshToken)
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// Claiming the token revokes it, so two refreshes racing with the same
+	// token can't both rotate it
+	record, err := models.ClaimRefreshToken(tokenHash)
 	if err != nil {
+		if !errors.Is(err, models.ErrRefreshTokenClaimed) {
+			log.Println(":: Error : " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		existing, fetchErr := models.FetchRefreshTokenByHash(tokenHash)
+		if fetchErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		if existing.RevokedAt != nil {
+			log.Println(":: Warning : refresh token reuse detected for " + existing.Subject)
+			if err := models.RevokeRefreshTokenFamily(existing.FamilyId); err != nil {
+				log.Println(":: Error : " + err.Error())
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	tokens, err := issueTokenPair(record.Subject, record.Role, record.FamilyId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	newHash := libs.HashToken(tokens["refreshToken"].(string))
+	if err := models.SetRefreshTokenReplacement(tokenHash, newHash); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the presented refresh token and the rest of its family
+func Logout(c *gin.Context) {
+	var request RefreshRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	record, err := models.FetchRefreshTokenByHash(libs.HashToken(request.RefreshToken))
+	if err == nil {
+		if err := models.RevokeRefreshTokenFamily(record.FamilyId); err != nil {
+			log.Println(":: Error : " + err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+			return
+		}
+	}
+
+	// Unknown tokens are treated as already logged out
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// RevokeAllTokens logs the caller out of every session. Admins may pass
+// ?subject= to revoke someone else's sessions.
+func RevokeAllTokens(c *gin.Context) {
+	principal := middlewares.CurrentPrincipal(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
+		return
+	}
+
+	subject := principal.Subject
+	if target := c.Query("subject"); target != "" && target != subject {
+		if principal.Role != "ADMIN" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		subject = target
+	}
+
+	if err := models.RevokeAllTokensForSubject(subject); err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked"})
 }
@@ -0,0 +1,158 @@
+package routers
+
+import (
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var consentTypes = map[string]bool{
+	models.ConsentTerms:     true,
+	models.ConsentPrivacy:   true,
+	models.ConsentResearch:  true,
+	models.ConsentMarketing: true,
+}
+
+// consentContextFor captures where the request came from
+func consentContextFor(c *gin.Context, source string) controller.ConsentContext {
+	return controller.ConsentContext{
+		Source:    source,
+		IpAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// FetchConsentDocuments returns the current version of every consent document
+func FetchConsentDocuments(c *gin.Context) {
+	documents, err := models.FetchActiveConsentDocuments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch consent documents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, documents)
+}
+
+// PublishConsentDocument makes a new version the active one for its type
+func PublishConsentDocument(c *gin.Context) {
+	var request response.ConsentDocument
+	if err := c.ShouldBindJSON(&request); err != nil || !consentTypes[request.Type] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent document"})
+		return
+	}
+
+	if err := models.DeactivateConsentDocuments(request.Type); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retire previous version"})
+		return
+	}
+
+	document := models.NewConsentDocument(request.Type, request.Version, request.Title, request.Url, request.Required)
+	if err := mgm.Coll(document).Create(document); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish consent document"})
+		return
+	}
+
+	models.RecordAudit(middlewares.CurrentPrincipal(c).Subject, "CONSENT_DOCUMENT_PUBLISHED", document.ID.Hex(), map[string]interface{}{
+		"type":    document.Type,
+		"version": document.Version,
+	})
+
+	c.JSON(http.StatusOK, document)
+}
+
+// HandleListMyConsents returns the consent history of the signed in user
+func HandleListMyConsents(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have consents"})
+		return
+	}
+
+	consents := []models.Consent{}
+	if err := mgm.Coll(&models.Consent{}).SimpleFind(&consents, bson.M{"userId": userId}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch consents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, consents)
+}
+
+// HandleGrantMyConsents records consents given from the dashboard, e.g. a marketing opt-in
+func HandleGrantMyConsents(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have consents"})
+		return
+	}
+
+	var request response.ConsentChange
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent request"})
+		return
+	}
+
+	documents, err := models.FetchActiveConsentDocuments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch consent documents"})
+		return
+	}
+
+	var accepted []models.ConsentDocument
+	for _, acceptance := range request.Consents {
+		for _, document := range documents {
+			if document.Type == acceptance.Type && document.Version == acceptance.Version {
+				accepted = append(accepted, document)
+			}
+		}
+	}
+	if len(accepted) != len(request.Consents) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Consents must reference current document versions"})
+		return
+	}
+
+	if err := controller.RecordConsents(userId, primitive.NilObjectID, accepted, consentContextFor(c, "dashboard")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consents"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Consents recorded"})
+}
+
+// HandleWithdrawMyConsents withdraws the listed consent types
+func HandleWithdrawMyConsents(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have consents"})
+		return
+	}
+
+	var request response.ConsentChange
+	if err := c.ShouldBindJSON(&request); err != nil || len(request.Types) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid consent request"})
+		return
+	}
+
+	for _, consentType := range request.Types {
+		if !models.IsWithdrawableConsent(consentType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": consentType + " consent can't be withdrawn, request erasure of your account instead"})
+			return
+		}
+	}
+
+	withdrawn, err := models.WithdrawConsents(userId, request.Types)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to withdraw consents"})
+		return
+	}
+
+	models.RecordAudit(userId.Hex(), "CONSENT_WITHDRAWN", userId.Hex(), map[string]interface{}{"types": request.Types})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Consents withdrawn", "withdrawn": withdrawn})
+}
@@ -0,0 +1,122 @@
+package routers
+
+import (
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateCoupon registers a promo code
+func CreateCoupon(c *gin.Context) {
+	var request response.Coupon
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon data"})
+		return
+	}
+
+	switch request.DiscountType {
+	case models.CouponPercent:
+		if request.DiscountValue < 1 || request.DiscountValue > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Percentage discount must be between 1 and 100"})
+			return
+		}
+		request.Currency = "" // A percentage applies to every currency
+	case models.CouponFlat:
+		if request.DiscountValue < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Flat discount must be positive"})
+			return
+		}
+		request.Currency = strings.ToUpper(strings.TrimSpace(request.Currency))
+		if request.Currency == "" {
+			request.Currency = models.CouponLegacyCurrency
+		}
+		if len(request.Currency) != 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Currency must be an ISO 4217 code"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Discount type must be PERCENT or FLAT"})
+		return
+	}
+
+	if request.ValidFrom.IsZero() {
+		request.ValidFrom = time.Now().UTC()
+	}
+	if !request.ValidUntil.IsZero() && !request.ValidUntil.After(request.ValidFrom) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validUntil must be after validFrom"})
+		return
+	}
+
+	if _, err := models.FetchCouponByCode(request.Code); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	coupon := models.NewCoupon(request.Code, request.Description, request.DiscountType, request.DiscountValue, request.Currency, request.ValidFrom, request.ValidUntil, request.MaxRedemptions, request.PerUserLimit, request.TestNames)
+	if err := mgm.Coll(coupon).Create(coupon); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// ListCoupons returns every coupon, newest first
+func ListCoupons(c *gin.Context) {
+	coupons := []models.Coupon{}
+	findOptions := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if err := mgm.Coll(&models.Coupon{}).SimpleFind(&coupons, bson.M{}, findOptions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+// DeactivateCoupon stops further redemptions of a coupon
+func DeactivateCoupon(c *gin.Context) {
+	couponId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	coupon, err := models.DeactivateCoupon(couponId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// HandleCouponStats reports how a coupon has been used
+func HandleCouponStats(c *gin.Context) {
+	couponId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	coupon, err := models.FetchCouponById(couponId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := models.FetchCouponStats(couponId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupon stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"coupon": coupon, "stats": stats})
+}
@@ -0,0 +1,195 @@
+package routers
+
+import (
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// partnerWallet is the wallet of the organization behind the calling API key
+func partnerWallet(c *gin.Context) (models.CreditOwner, bool) {
+	organizationId, err := primitive.ObjectIDFromHex(middlewares.CurrentPrincipal(c).OrganizationId)
+	if err != nil {
+		return models.CreditOwner{}, false
+	}
+	return controller.OrganizationWallet(organizationId), true
+}
+
+// respondCreditBalances writes the wallet's usable credits per test
+func respondCreditBalances(c *gin.Context, owner models.CreditOwner) {
+	balances, err := models.FetchCreditBalances(owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"credits": balances})
+}
+
+// respondCreditTransactions writes a page of the wallet's history
+func respondCreditTransactions(c *gin.Context, owner models.CreditOwner) {
+	page, limit := parsePagination(c)
+
+	transactions, total, err := models.FetchCreditTransactions(owner, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credit history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transactions": transactions, "page": page, "limit": limit, "total": total})
+}
+
+// HandleGetMyCredits returns the signed in user's credit balances
+func HandleGetMyCredits(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a wallet"})
+		return
+	}
+
+	respondCreditBalances(c, controller.UserWallet(userId))
+}
+
+// HandleListMyCreditTransactions returns the signed in user's credit history
+func HandleListMyCreditTransactions(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a wallet"})
+		return
+	}
+
+	respondCreditTransactions(c, controller.UserWallet(userId))
+}
+
+// HandlePurchaseMyCredits opens a payment link for a bundle for the signed in user
+func HandlePurchaseMyCredits(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a wallet"})
+		return
+	}
+
+	var request response.CreditPurchase
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase data"})
+		return
+	}
+
+	user := models.FetchUserUsingId(userId)
+	purchase, myErr := controller.PurchaseCredits(controller.UserWallet(userId), userId, user.Name, user.Email, request.Sku, request.Currency, pricingCountry(c, request.Country))
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purchaseId": purchase.ID.Hex(), "paymentLink": purchase.PaymentLink})
+}
+
+// HandleGetPartnerCredits returns the partner organization's credit balances
+func HandleGetPartnerCredits(c *gin.Context) {
+	owner, ok := partnerWallet(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not tied to an organization"})
+		return
+	}
+
+	respondCreditBalances(c, owner)
+}
+
+// HandleListPartnerCreditTransactions returns the partner organization's credit history
+func HandleListPartnerCreditTransactions(c *gin.Context) {
+	owner, ok := partnerWallet(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not tied to an organization"})
+		return
+	}
+
+	respondCreditTransactions(c, owner)
+}
+
+// HandlePurchasePartnerCredits opens a payment link for a bundle for the
+// partner organization, in the name of its contact
+func HandlePurchasePartnerCredits(c *gin.Context) {
+	owner, ok := partnerWallet(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not tied to an organization"})
+		return
+	}
+
+	var request response.CreditPurchase
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase data"})
+		return
+	}
+
+	organization, err := models.FetchOrganizationById(owner.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	purchase, myErr := controller.PurchaseCredits(owner, primitive.NilObjectID, organization.Name, organization.ContactEmail, request.Sku, request.Currency, pricingCountry(c, request.Country))
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purchaseId": purchase.ID.Hex(), "paymentLink": purchase.PaymentLink})
+}
+
+// HandleGrantCredits adds credits to a user's or organization's wallet without payment
+func HandleGrantCredits(c *gin.Context) {
+	var request response.CreditGrant
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grant data"})
+		return
+	}
+
+	ownerId, err := primitive.ObjectIDFromHex(request.OwnerId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid owner ID"})
+		return
+	}
+	if request.TestName == "" {
+		request.TestName = "BIG_5"
+	}
+
+	owner := models.CreditOwner{Type: request.OwnerType, Id: ownerId}
+	lot, myErr := controller.GrantCredits(owner, request.TestName, request.Credits, request.ValidityDays, request.Note, middlewares.CurrentPrincipal(c).Subject)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, lot)
+}
+
+// HandleGetWallet returns any wallet's balances with a page of its history
+func HandleGetWallet(c *gin.Context) {
+	ownerId, err := primitive.ObjectIDFromHex(c.Param("ownerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid owner ID"})
+		return
+	}
+	owner := models.CreditOwner{Type: c.Param("ownerType"), Id: ownerId}
+
+	balances, err := models.FetchCreditBalances(owner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credits"})
+		return
+	}
+
+	page, limit := parsePagination(c)
+	transactions, total, err := models.FetchCreditTransactions(owner, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credit history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"credits": balances, "transactions": transactions, "page": page, "limit": limit, "total": total})
+}
@@ -0,0 +1,93 @@
+package routers
+
+import (
+	"context"
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// currentUserId returns the id of the signed in user, or false for admins and partners
+func currentUserId(c *gin.Context) (primitive.ObjectID, bool) {
+	principal := middlewares.CurrentPrincipal(c)
+	if principal == nil || principal.Role != "USER" {
+		return primitive.NilObjectID, false
+	}
+
+	userId, err := primitive.ObjectIDFromHex(principal.Subject)
+	if err != nil {
+		return primitive.NilObjectID, false
+	}
+	return userId, true
+}
+
+// parsePagination reads ?page= and ?limit=, defaulting to the first 10 items
+func parsePagination(c *gin.Context) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 {
+		limit = 10
+	}
+	if limit > 50 {
+		limit = 50
+	}
+	return page, limit
+}
+
+// HandleListMyTests lists the signed in user's tests with payment and report state
+func HandleListMyTests(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a dashboard"})
+		return
+	}
+
+	page, limit := parsePagination(c)
+
+	tests, total, err := controller.ListUserTests(userId, c.Query("testName"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tests": tests, "page": page, "limit": limit, "total": total})
+}
+
+// HandleGetMyTest returns a single test of the signed in user
+func HandleGetMyTest(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a dashboard"})
+		return
+	}
+
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	test, err := models.FetchTestById(testId)
+	if err != nil || test.UserId != userId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "test with ID " + testId.Hex() + " not found"})
+		return
+	}
+
+	count, err := mgm.Coll(&models.FinalReport{}).CountDocuments(context.TODO(), bson.M{"testId": testId})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test"})
+		return
+	}
+
+	c.JSON(http.StatusOK, controller.SummarizeTest(*test, count > 0))
+}
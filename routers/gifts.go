@@ -0,0 +1,126 @@
+package routers
+
+import (
+	"myproject/controller"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HandlePurchaseGift opens a payment link for a test the signed in user gives to someone else
+func HandlePurchaseGift(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can buy gifts"})
+		return
+	}
+
+	var request response.Gift
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gift data"})
+		return
+	}
+
+	buyer := models.FetchUserUsingId(userId)
+	gift, myErr := controller.PurchaseGift(buyer, request.RecipientName, request.RecipientEmail, request.Message, request.Tier, request.Currency, pricingCountry(c, request.Country))
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"giftId": gift.ID.Hex(), "paymentLink": gift.PaymentLink})
+}
+
+// HandleListMyGifts lists the gifts the signed in user bought. A report link
+// is only included once the recipient shared it.
+func HandleListMyGifts(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a dashboard"})
+		return
+	}
+
+	gifts, err := models.FetchGiftsByBuyer(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gifts"})
+		return
+	}
+
+	summaries := make([]gin.H, 0, len(gifts))
+	for _, gift := range gifts {
+		summary := gin.H{
+			"_id":            gift.ID,
+			"recipientName":  gift.RecipientName,
+			"recipientEmail": gift.RecipientEmail,
+			"status":         gift.Status,
+			"amount":         gift.Amount,
+			"currency":       gift.Currency,
+			"createdAt":      gift.CreatedAt,
+			"expiresAt":      gift.ExpiresAt,
+			"redeemedAt":     gift.RedeemedAt,
+			"reportReady":    gift.BuyerNotified,
+		}
+		if gift.Status == models.GiftPending {
+			summary["paymentLink"] = gift.PaymentLink
+		}
+		if gift.ReportShared {
+			summary["reportLink"] = os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + gift.TestId.Hex()
+		}
+		summaries = append(summaries, summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"gifts": summaries})
+}
+
+// HandleGetGiftInvitation describes the gift behind an invitation link, for
+// the recipient's landing page
+func HandleGetGiftInvitation(c *gin.Context) {
+	giftId, err := controller.ParseGiftToken(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired gift link"})
+		return
+	}
+
+	gift, err := models.FetchGiftById(giftId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired gift link"})
+		return
+	}
+
+	buyer := models.FetchUserUsingId(gift.BuyerId)
+	c.JSON(http.StatusOK, gin.H{
+		"recipientName": gift.RecipientName,
+		"buyerName":     buyer.Name,
+		"message":       gift.Message,
+		"testName":      gift.TestName,
+		"available":     gift.Status == models.GiftPaid,
+		"expiresAt":     gift.ExpiresAt,
+	})
+}
+
+// HandleShareGiftReport lets the recipient of a gifted test share their
+// report with the person who gifted it
+func HandleShareGiftReport(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a dashboard"})
+		return
+	}
+
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	if myErr := controller.ShareGiftReport(testId, userId); myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Report shared"})
+}
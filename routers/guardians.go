@@ -0,0 +1,48 @@
+package routers
+
+import (
+	"myproject/controller"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGuardianApprove records the guardian's consent from the emailed link
+// and sends them on to payment
+func HandleGuardianApprove(c *gin.Context) {
+	webappPaymentStatusPath := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("WEBAPP_PAYMENT_STATUS_PATH")
+
+	consentId, err := controller.ParseGuardianConsentToken(c.Query("token"))
+	if err != nil {
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=failed&message=This link is invalid or has expired")
+		return
+	}
+
+	link, consentErr := controller.ApproveGuardianConsent(c, consentId)
+	if consentErr != nil {
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=failed&message="+url.QueryEscape(consentErr.Message))
+		return
+	}
+
+	c.Redirect(http.StatusFound, link)
+}
+
+// HandleGuardianDecline records that the guardian refused consent
+func HandleGuardianDecline(c *gin.Context) {
+	webappPaymentStatusPath := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("WEBAPP_PAYMENT_STATUS_PATH")
+
+	consentId, err := controller.ParseGuardianConsentToken(c.Query("token"))
+	if err != nil {
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=failed&message=This link is invalid or has expired")
+		return
+	}
+
+	if consentErr := controller.DeclineGuardianConsent(c, consentId); consentErr != nil {
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=failed&message="+url.QueryEscape(consentErr.Message))
+		return
+	}
+
+	c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=declined&message=No report will be generated")
+}
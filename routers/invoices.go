@@ -0,0 +1,82 @@
+package routers
+
+import (
+	"log"
+	"myproject/controller"
+	"myproject/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// invoicePaidStatuses are the test states an invoice can be issued for
+var invoicePaidStatuses = map[string]bool{
+	models.PaymentStatusPaid:         true,
+	models.PaymentStatusPartRefunded: true,
+	models.PaymentStatusRefunded:     true,
+}
+
+// sendInvoice streams a test's invoice as PDF, issuing it first for tests paid before invoicing existed
+func sendInvoice(c *gin.Context, test *models.Test) {
+	if !invoicePaidStatuses[test.PaymentStatus] {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No invoice for this test"})
+		return
+	}
+
+	invoice, err := controller.IssueInvoice(test.ID)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusNotFound, gin.H{"error": "No invoice for this test"})
+		return
+	}
+
+	filename := "invoice-" + strings.ReplaceAll(invoice.Number, "/", "-") + ".pdf"
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	if err := controller.WriteInvoice(c.Writer, invoice); err != nil {
+		log.Println(":: Error : failed to render invoice: " + err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// HandleDownloadMyInvoice lets the signed in user download the invoice of one of their tests
+func HandleDownloadMyInvoice(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a dashboard"})
+		return
+	}
+
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	test, err := models.FetchTestById(testId)
+	if err != nil || test.UserId != userId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "test with ID " + testId.Hex() + " not found"})
+		return
+	}
+
+	sendInvoice(c, test)
+}
+
+// HandleAdminDownloadInvoice downloads the invoice of any test
+func HandleAdminDownloadInvoice(c *gin.Context) {
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	test, err := models.FetchTestById(testId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	sendInvoice(c, test)
+}
@@ -1,16 +1,17 @@
 package routers
 
 import (
+	"errors"
 	"fmt"
 	"myproject/models"
 	"myproject/response"
 	"net/http"
+	"os"
+	"strings"
 
 	"myproject/controller"
 
 	"context"
-	"os"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kamva/mgm/v3"
@@ -27,63 +28,193 @@ func HandleSubmission(c *gin.Context) {
 		return
 	}
 
-	println("::: PMODE :::" + submission.PMode)
+	// Every required consent document must be accepted at its current version
+	consentDocuments, consentErr := controller.ValidateConsents(submission.Consents)
+	if consentErr != nil {
+		c.JSON(consentErr.Code, gin.H{"error": consentErr.Message})
+		return
+	}
+
+	if answersErr := controller.ValidateAnswers(submission.Answers); answersErr != nil {
+		c.JSON(answersErr.Code, gin.H{"error": answersErr.Message})
+		return
+	}
+
+	// Discounts that can't be combined are refused before anything is redeemed
+	if submission.ReferralCode != "" && (submission.CouponCode != "" || submission.VoucherCode != "" || submission.GrantToken != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A referral code can't be combined with a coupon, voucher or payment grant"})
+		return
+	}
+	if submission.GiftToken != "" && (submission.CouponCode != "" || submission.VoucherCode != "" || submission.GrantToken != "" || submission.ReferralCode != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A gift can't be combined with other discounts"})
+		return
+	}
+	if submission.GrantToken != "" && (submission.CouponCode != "" || submission.VoucherCode != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A payment grant can't be combined with a coupon or voucher"})
+		return
+	}
+	if submission.VoucherCode != "" && submission.CouponCode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A voucher can't be combined with a coupon"})
+		return
+	}
+
+	// Test takers under the consent age are held until a guardian approves
+	if submission.Age <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Age is required"})
+		return
+	}
+	needsGuardian := controller.RequiresGuardianConsent(submission.Age)
+	if needsGuardian && (submission.Guardian == nil || submission.Guardian.Email == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Guardian details are required for test takers under %d", controller.GuardianConsentAge())})
+		return
+	}
 
 	// Find or create the user
-	var existingUsers []models.User
-	mgm.Coll(&models.User{}).SimpleFind(&existingUsers, bson.M{"email": submission.Email})
+	user, userErr := controller.FindOrCreateUser(submission.Name, submission.Email, submission.Gender, submission.Age)
+	if userErr != nil {
+		c.JSON(userErr.Code, gin.H{"error": userErr.Message})
+		return
+	}
 
-	if len(existingUsers) == 0 {
-		newUser := models.NewUser(submission.Name, submission.Email, submission.Gender, submission.Age, "", "USER", "ACTIVE", "PENDING")
-		if err := mgm.Coll(newUser).Create(newUser); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+	if submission.Profile != nil {
+		updatedUser, err := models.UpdateUserProfile(user.ID, toProfile(*submission.Profile))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save profile"})
 			return
 		}
-		existingUsers = append(existingUsers, *newUser)
+		user = updatedUser
 	}
 
-	user := existingUsers[0]
+	// Price the report, applying the coupon if one was given
+	testName := "BIG_5"
+	billingCountry := ""
+	if submission.Billing != nil {
+		billingCountry = submission.Billing.Country
+	}
+	country := pricingCountry(c, billingCountry)
+	quote, err := controller.QuoteTest(testName, submission.Tier, submission.Currency, country)
+	if err != nil {
+		fmt.Println(":: ERROR : " + err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This report is not available for purchase"})
+		return
+	}
+	price := quote.Amount
+
+	testId := primitive.NewObjectID()
+
+	// Everything redeemed below is given back if the submission fails later
+	var gift *models.Gift
+	var grant *models.PaymentGrant
+	var voucher *models.Voucher
+	var creditLot *models.CreditLot
+	var couponQuote *controller.CouponQuote
+	release := func(reason string) {
+		controller.ReleaseCoupon(couponQuote)
+		controller.RestoreTestCredit(creditLot, testId, reason)
+		controller.ReleaseVoucher(voucher, testId)
+		controller.ReleasePaymentGrant(grant, testId)
+		controller.ReleaseGift(gift, testId)
+	}
 
-	// Create a new test entry
+	// A gift was paid for by whoever sent the invitation
+	if submission.GiftToken != "" {
+		redeemed, giftErr := controller.RedeemGift(submission.GiftToken, testName, user.ID, testId)
+		if giftErr != nil {
+			c.JSON(giftErr.Code, gin.H{"error": giftErr.Message})
+			return
+		}
+		gift = redeemed
+	}
 
-	var testPaymentStatus string = "PENDING"
-	var testPaymentLink string = ""
-	var paymentLinkId string = ""
-	var testId primitive.ObjectID = primitive.NewObjectID()
+	// An admin issued grant waives the payment outright
+	if submission.GrantToken != "" {
+		used, grantErr := controller.UsePaymentGrant(submission.GrantToken, testName, *user, testId)
+		if grantErr != nil {
+			c.JSON(grantErr.Code, gin.H{"error": grantErr.Message})
+			return
+		}
+		grant = used
+	}
 
-	if submission.PMode != "" && submission.PMode == "pass" {
-		// Just Generate New Report
-		testPaymentStatus = "BYPASS_PAYMENT"
-	} else {
-		// Go through payment mode
-		referenceId := "big5_" + testId.Hex()
-		amount := os.Getenv("BIG_5_REPORT_PRICE")
+	// A voucher from an institution pays for the test outright
+	if submission.VoucherCode != "" {
+		redeemed, voucherErr := controller.RedeemVoucher(submission.VoucherCode, testName, testId, user.ID)
+		if voucherErr != nil {
+			c.JSON(voucherErr.Code, gin.H{"error": voucherErr.Message})
+			return
+		}
+		voucher = redeemed
+	}
 
-		amountInt, err := strconv.Atoi(amount)
-		if err != nil {
-			fmt.Println(":: ERROR : " + err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generated payment link"})
+	// A referred test taker gets a discount on their first report
+	var referralQuote *controller.ReferralQuote
+	if submission.ReferralCode != "" {
+		applied, referralErr := controller.ApplyReferral(submission.ReferralCode, *user, price, c.ClientIP())
+		if referralErr != nil {
+			release("referral rejected")
+			c.JSON(referralErr.Code, gin.H{"error": referralErr.Message})
 			return
 		}
-		data, err := controller.GeneratePaymentLink(amountInt, "For BIG 5 report generator", submission.Name, user.Email, referenceId)
+		referralQuote = applied
+		price -= applied.Discount
+		quote.Amount = price
+	}
 
-		if err != nil {
+	// Signed in users pay with a prepaid credit when their wallet has one,
+	// unless they chose to use a coupon instead
+	if payerId, ok := currentUserId(c); ok && gift == nil && grant == nil && voucher == nil && referralQuote == nil && submission.CouponCode == "" {
+		lot, err := models.ConsumeCredit(controller.UserWallet(payerId), testName, testId, payerId.Hex())
+		if err != nil && !errors.Is(err, models.ErrNoCredits) {
 			fmt.Println(":: ERROR : " + err.Error())
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generated payment link"})
+			release("credits could not be used")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to use credits"})
 			return
 		}
+		creditLot = lot
+	}
 
-		// Type assertion to get the string value from the map
-		shortURL, ok := data["short_url"].(string)
-		if !ok {
-			fmt.Println(":: ERROR : short_url is not a string")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generated payment link"})
+	if submission.CouponCode != "" {
+		redeemed, couponErr := controller.RedeemCoupon(submission.CouponCode, user.ID, testName, price, quote.Currency)
+		if couponErr != nil {
+			release("coupon rejected")
+			c.JSON(couponErr.Code, gin.H{"error": couponErr.Message})
 			return
 		}
+		couponQuote = redeemed
+		price = redeemed.FinalAmount
+		quote.Amount = price
+	}
 
-		id, ok := data["id"].(string)
-		if !ok {
-			fmt.Println(":: ERROR : id is not a string")
+	// Create a new test entry
+
+	var testPaymentStatus string = "PENDING"
+	var testPaymentLink string = ""
+	var paymentLinkId string = ""
+
+	if needsGuardian {
+		// The guardian pays once they approve
+		testPaymentStatus = models.PaymentStatusGuardianHold
+	} else if gift != nil {
+		// Paid by the buyer of the gift
+		testPaymentStatus = models.PaymentStatusGift
+	} else if grant != nil {
+		// Waived by an admin
+		testPaymentStatus = models.PaymentStatusGranted
+	} else if voucher != nil {
+		// Paid upfront by the institution
+		testPaymentStatus = models.PaymentStatusVoucher
+	} else if creditLot != nil {
+		// Paid from the wallet
+		testPaymentStatus = models.PaymentStatusCredits
+	} else if price == 0 {
+		// The coupon covered the whole price
+		testPaymentStatus = models.PaymentStatusCouponFree
+	} else {
+		// Go through payment mode
+		shortURL, id, err := controller.CreateTestPaymentLink(testId, user.ID, submission.Name, user.Email, *quote)
+		if err != nil {
+			fmt.Println(":: ERROR : " + err.Error())
+			release("payment link could not be created")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generated payment link"})
 			return
 		}
@@ -92,42 +223,110 @@ func HandleSubmission(c *gin.Context) {
 		paymentLinkId = id
 	}
 
-	newTest := models.NewTest(testId, submission.Name, submission.Age, submission.Gender, "BIG_5", user.ID, testPaymentStatus, testPaymentLink, paymentLinkId, "PENDING")
+	newTest := models.NewTest(testId, submission.Name, submission.Age, submission.Gender, testName, user.ID, testPaymentStatus, testPaymentLink, paymentLinkId, "PENDING")
+	newTest.Amount = price
+	newTest.ProductSku = quote.Sku
+	if creditLot != nil {
+		newTest.Amount = 0
+		newTest.CreditLotId = creditLot.ID
+	}
+	if voucher != nil {
+		newTest.Amount = 0
+		newTest.VoucherCode = voucher.Code
+	}
+	if grant != nil {
+		newTest.Amount = 0
+		newTest.GrantId = grant.ID
+	}
+	if gift != nil {
+		newTest.Amount = 0
+		newTest.GiftId = gift.ID
+		newTest.ProductSku = gift.Sku // What the buyer paid for, not the recipient's quote
+	}
+	newTest.Currency = quote.Currency
+	newTest.Country = country
+	if submission.Billing != nil {
+		newTest.Billing = &models.Billing{
+			Name:    submission.Billing.Name,
+			Gstin:   strings.ToUpper(strings.TrimSpace(submission.Billing.Gstin)),
+			Address: submission.Billing.Address,
+			State:   submission.Billing.State,
+			Country: submission.Billing.Country,
+		}
+	}
+	if couponQuote != nil {
+		newTest.CouponCode = couponQuote.Coupon.Code
+	}
+	if referralQuote != nil {
+		newTest.ReferralCode = referralQuote.Code.Code
+	}
 
 	if err := mgm.Coll(&models.Test{}).Create(newTest); err != nil {
+		controller.DiscardSubmission(c, testId, paymentLinkId)
+		release("test could not be saved")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create test"})
 		return
 	}
 
-	// Store scores
-	var scoreDocs []models.Score
-	for _, answer := range submission.Answers {
-		questionId, err := primitive.ObjectIDFromHex(answer.Id)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid question ID"})
-			return
-		}
-		scoreDocs = append(scoreDocs, *models.NewScore(user.ID, questionId, answer.Answer, newTest.ID))
+	if err := controller.RecordCouponRedemption(couponQuote, user.ID, newTest.ID, testName, quote.Currency); err != nil {
+		fmt.Println(":: ERROR : " + err.Error())
 	}
 
-	var docs []interface{}
-	for _, q := range scoreDocs {
-		docs = append(docs, q) // Add each question as an interface{}
+	if err := controller.RecordReferral(referralQuote, user.ID, newTest.ID, quote.Currency, c.ClientIP()); err != nil {
+		fmt.Println(":: ERROR : " + err.Error())
 	}
 
-	_, err := mgm.Coll(&models.Score{}).InsertMany(c, docs)
+	if err := controller.RecordConsents(user.ID, newTest.ID, consentDocuments, consentContextFor(c, "submit")); err != nil {
+		fmt.Println(":: ERROR : " + err.Error())
+		controller.DiscardSubmission(c, testId, paymentLinkId)
+		release("consents could not be recorded")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consents"})
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store scores"})
+	// Store scores
+	if scoreErr := controller.StoreScores(c, user.ID, newTest.ID, submission.Answers); scoreErr != nil {
+		controller.DiscardSubmission(c, testId, paymentLinkId)
+		release("scores could not be stored")
+		c.JSON(scoreErr.Code, gin.H{"error": scoreErr.Message})
 		return
 	}
 
-	if submission.PMode != "" && submission.PMode == "pass" {
+	if needsGuardian {
+		paymentRequired := price > 0 && gift == nil && grant == nil && creditLot == nil && voucher == nil
+		if err := controller.RequestGuardianConsent(*newTest, *submission.Guardian, paymentRequired); err != nil {
+			fmt.Println(":: ERROR : " + err.Error())
+			controller.DiscardSubmission(c, testId, paymentLinkId)
+			release("guardian consent could not be requested")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request guardian consent"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Submission successful, waiting for guardian consent", "paymentLink": ""})
+		return
+	}
+
+	if testPaymentStatus == models.PaymentStatusGranted || testPaymentStatus == models.PaymentStatusCouponFree || testPaymentStatus == models.PaymentStatusCredits || testPaymentStatus == models.PaymentStatusVoucher || testPaymentStatus == models.PaymentStatusGift {
 		// Just Generate New Report
-		go controller.GenerateNewReport(c, *newTest, user)
+		go controller.GenerateNewReport(c, *newTest, *user)
+		c.JSON(http.StatusOK, gin.H{"message": "Submission successful", "paymentLink": testPaymentLink, "testId": newTest.ID.Hex()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Submission successful", "paymentLink": testPaymentLink})
+	// Unpaid tests get the free summary straight away; paying adds the narrative
+	if myErr := controller.GenerateSummaryReport(c, *newTest); myErr != nil {
+		fmt.Println(":: ERROR : " + myErr.Message)
+		c.JSON(http.StatusOK, gin.H{"message": "Submission successful", "paymentLink": testPaymentLink, "testId": newTest.ID.Hex()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Submission successful",
+		"paymentLink": testPaymentLink,
+		"testId":      newTest.ID.Hex(),
+		"reportTier":  controller.ReportAccessSummary,
+		"reportLink":  os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + newTest.ID.Hex(),
+	})
 }
 
 // Generate report
@@ -163,11 +362,17 @@ func HandleReportGeneration(c *gin.Context) {
 		return
 	}
 
-	// Checking if report already generated
+	// The narrative is only written for tests past payment
+	if controller.ReportAccessFor(test) != controller.ReportAccessFull {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "This test has not been paid for"})
+		return
+	}
+
+	// Checking if report already generated, the domain scores alone are the free summary
 
 	filter := bson.D{{Key: "testId", Value: testId}}
 
-	count, err := mgm.Coll(&models.Report{}).CountDocuments(context.TODO(), filter)
+	count, err := mgm.Coll(&models.FinalReport{}).CountDocuments(context.TODO(), filter)
 
 	if err != nil {
 		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err})
@@ -186,12 +391,5 @@ func HandleReportGeneration(c *gin.Context) {
 		return
 	}
 
-	_, err = models.UpdateTestReportSent(test.ID, "DONE")
-
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": err})
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{"message": "Report generated successfully"})
 }
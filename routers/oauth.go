@@ -0,0 +1,216 @@
+package routers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	apis "myproject/apis"
+	"myproject/controller"
+	"myproject/libs"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HandleOIDCLogin redirects the browser to the provider's consent screen
+func HandleOIDCLogin(c *gin.Context) {
+	provider, err := apis.GetIdentityProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	nonce, err := libs.GenerateOpaqueToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// The state is signed so the callback needs no server side session.
+	// Consents accepted on the signup page (?consents=TERMS:v1,PRIVACY:v2)
+	// travel with it and are recorded once the user is known.
+	state, err := libs.SignPurposeToken("oidc_state", map[string]interface{}{
+		"provider": provider.Name,
+		"nonce":    nonce,
+		"consents": c.Query("consents"),
+	}, oidcStateValidity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	// The nonce is also kept in a cookie, so a state minted in someone
+	// else's browser can't finish a login in this one
+	setOIDCStateCookie(c, nonce, oidcStateValidity)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleOIDCCallback finishes the login, links the identity to a User by
+// verified email and hands our own tokens to the webapp
+func HandleOIDCCallback(c *gin.Context) {
+	webappLoginPath := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("WEBAPP_LOGIN_PATH")
+
+	provider, err := apis.GetIdentityProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message="+url.QueryEscape(providerError))
+		return
+	}
+
+	state, err := libs.ParsePurposeToken("oidc_state", c.Query("state"))
+	if err != nil || state["provider"] != provider.Name {
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message=Invalid login state")
+		return
+	}
+	nonce, _ := state["nonce"].(string)
+
+	cookieNonce, err := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1) // The state is single use
+	if err != nil || nonce == "" || subtle.ConstantTimeCompare([]byte(cookieNonce), []byte(nonce)) != 1 {
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message=Invalid login state")
+		return
+	}
+
+	idToken, err := provider.ExchangeCode(c.Query("code"))
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message=Login failed")
+		return
+	}
+
+	identity, err := provider.VerifyIDToken(idToken, nonce)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message=Login failed")
+		return
+	}
+
+	consentsParam, _ := state["consents"].(string)
+	consentDocuments, consentErr := controller.ValidateConsents(parseConsentsParam(consentsParam))
+	if consentErr != nil && !identityHasAccount(provider, identity) {
+		// Signing up requires the current required consents
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message="+url.QueryEscape(consentErr.Message))
+		return
+	}
+
+	user, err := findOrLinkUser(provider, identity)
+	if err != nil {
+		// The reason may carry database errors, so it only goes to the log
+		log.Println(":: Error : " + err.Error())
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message=Login failed")
+		return
+	}
+
+	if consentErr == nil {
+		if err := controller.RecordConsents(user.ID, primitive.NilObjectID, consentDocuments, consentContextFor(c, "signup")); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+	}
+
+	tokens, err := issueTokenPair(user.ID.Hex(), "USER", "")
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.Redirect(http.StatusFound, webappLoginPath+"?status=failed&message=Login failed")
+		return
+	}
+
+	// Tokens go in the fragment so they never reach server logs
+	fragment := url.Values{}
+	fragment.Set("token", tokens["token"].(string))
+	fragment.Set("refreshToken", tokens["refreshToken"].(string))
+	c.Redirect(http.StatusFound, webappLoginPath+"?status=success#"+fragment.Encode())
+}
+
+const (
+	oidcStateCookie   = "oidc_state"
+	oidcStateValidity = 10 * time.Minute
+)
+
+// setOIDCStateCookie stores the login nonce for the callback. Lax lets the
+// cookie through on the provider's top level redirect back to us.
+func setOIDCStateCookie(c *gin.Context, nonce string, maxAge time.Duration) {
+	secure := c.Request.TLS != nil || strings.HasPrefix(os.Getenv("BACKEND_API_DOMAIN"), "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, nonce, int(maxAge.Seconds()), "/auth/oidc/", "", secure, true)
+}
+
+// parseConsentsParam reads "TYPE:version" pairs separated by commas
+func parseConsentsParam(param string) []response.ConsentAcceptance {
+	var accepted []response.ConsentAcceptance
+	for _, pair := range strings.Split(param, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		accepted = append(accepted, response.ConsentAcceptance{Type: parts[0], Version: parts[1]})
+	}
+	return accepted
+}
+
+// identityHasAccount reports whether logging in with identity reaches an existing user
+func identityHasAccount(provider *apis.IdentityProvider, identity *apis.IdentityClaims) bool {
+	if _, err := models.FetchUserIdentity(provider.Name, identity.Subject); err == nil {
+		return true
+	}
+	if !provider.LinkByEmail || identity.Email == "" || !identity.EmailVerified {
+		return false
+	}
+	_, err := models.FetchUserByEmail(identity.Email)
+	return err == nil
+}
+
+// findOrLinkUser resolves an external identity to a User. Existing links win,
+// otherwise accounts are matched by verified email or created. Providers not
+// trusted with email linking can only create new accounts.
+func findOrLinkUser(provider *apis.IdentityProvider, identity *apis.IdentityClaims) (*models.User, error) {
+	if link, err := models.FetchUserIdentity(provider.Name, identity.Subject); err == nil {
+		user := models.FetchUserUsingId(link.UserId)
+		if user.ID.IsZero() {
+			return nil, fmt.Errorf("linked user no longer exists")
+		}
+		return &user, nil
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, fmt.Errorf("email is not verified with the identity provider")
+	}
+	email := identity.Email
+
+	user, err := models.FetchUserByEmail(email)
+	if err == nil && !provider.LinkByEmail {
+		return nil, fmt.Errorf("an account with this email already exists, sign in with the method you used before")
+	}
+	if err != nil {
+		user = models.NewUser(identity.Name, email, "", 0, "", "USER", "ACTIVE", "PENDING")
+		if err := mgm.Coll(user).Create(user); err != nil {
+			return nil, err
+		}
+	}
+
+	link := models.NewUserIdentity(user.ID, provider.Name, identity.Subject, email)
+	if err := mgm.Coll(link).Create(link); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
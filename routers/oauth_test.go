@@ -0,0 +1,95 @@
+package routers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A login state is only accepted back in the browser that started the login
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			// Getting past the state check ends at the code exchange
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 "http://" + r.Host,
+			"authorization_endpoint": "http://" + r.Host + "/authorize",
+			"token_endpoint":         "http://" + r.Host + "/token",
+			"jwks_uri":               "http://" + r.Host + "/jwks",
+		})
+	}))
+	defer issuer.Close()
+
+	t.Setenv("JWT_SECRET_KEY", "test-secret")
+	t.Setenv("OIDC_PROVIDERS", "mock")
+	t.Setenv("OIDC_MOCK_ISSUER", issuer.URL)
+	t.Setenv("OIDC_MOCK_CLIENT_ID", "client-1")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/auth/oidc/:provider/login", HandleOIDCLogin)
+	router.GET("/auth/oidc/:provider/callback", HandleOIDCCallback)
+
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", login.Code, login.Body.String())
+	}
+
+	var stateCookie *http.Cookie
+	for _, cookie := range login.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || stateCookie.Value == "" {
+		t.Fatal("login did not set the state cookie")
+	}
+	if !stateCookie.HttpOnly || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("state cookie is not HttpOnly and SameSite=Lax: %+v", stateCookie)
+	}
+
+	authURL, _ := url.Parse(login.Header().Get("Location"))
+	state := authURL.Query().Get("state")
+	if authURL.Query().Get("nonce") != stateCookie.Value {
+		t.Errorf("cookie holds %q, login sent nonce %q", stateCookie.Value, authURL.Query().Get("nonce"))
+	}
+
+	callback := func(cookie *http.Cookie) string {
+		request := httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?code=code-1&state="+url.QueryEscape(state), nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Header().Get("Location")
+	}
+
+	tests := []struct {
+		name      string
+		cookie    *http.Cookie
+		wantState bool
+	}{
+		{"no cookie", nil, false},
+		{"other browser", &http.Cookie{Name: oidcStateCookie, Value: "someone-else"}, false},
+		{"same browser", &http.Cookie{Name: oidcStateCookie, Value: stateCookie.Value}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := callback(tt.cookie)
+			rejected := strings.Contains(location, "Invalid+login+state") || strings.Contains(location, "Invalid login state")
+			if rejected == tt.wantState {
+				t.Errorf("callback redirected to %q", location)
+			}
+		})
+	}
+}
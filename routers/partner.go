@@ -0,0 +1,164 @@
+package routers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HandlePartnerSubmission creates a test on behalf of a partner. No payment
+// link is created: a credit is taken from the organization's wallet when it
+// has one, otherwise the test is billed on contract. The report is generated
+// straight away, unless the test taker is under the consent age: then the test
+// is held until a guardian approves, as on the public form.
+func HandlePartnerSubmission(c *gin.Context) {
+	principal := middlewares.CurrentPrincipal(c)
+
+	var submission response.Submit
+	if err := c.ShouldBindJSON(&submission); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid submission format"})
+		return
+	}
+
+	organizationId, err := primitive.ObjectIDFromHex(principal.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not tied to an organization"})
+		return
+	}
+
+	// Test takers under the consent age are held until a guardian approves
+	if submission.Age <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Age is required"})
+		return
+	}
+	needsGuardian := controller.RequiresGuardianConsent(submission.Age)
+	if needsGuardian && (submission.Guardian == nil || submission.Guardian.Email == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Guardian details are required for test takers under %d", controller.GuardianConsentAge())})
+		return
+	}
+
+	// Partners collect the consents from the test taker, as the public form does
+	consentDocuments, consentErr := controller.ValidateConsents(submission.Consents)
+	if consentErr != nil {
+		c.JSON(consentErr.Code, gin.H{"error": consentErr.Message})
+		return
+	}
+
+	if answersErr := controller.ValidateAnswers(submission.Answers); answersErr != nil {
+		c.JSON(answersErr.Code, gin.H{"error": answersErr.Message})
+		return
+	}
+
+	user, userErr := controller.FindOrCreateUser(submission.Name, submission.Email, submission.Gender, submission.Age)
+	if userErr != nil {
+		c.JSON(userErr.Code, gin.H{"error": userErr.Message})
+		return
+	}
+
+	testId := primitive.NewObjectID()
+	paymentStatus := models.PaymentStatusPartnerBilled
+
+	creditLot, err := models.ConsumeCredit(controller.OrganizationWallet(organizationId), "BIG_5", testId, principal.Subject)
+	if err != nil && !errors.Is(err, models.ErrNoCredits) {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to use credits"})
+		return
+	}
+	if creditLot != nil {
+		paymentStatus = models.PaymentStatusCredits
+	}
+
+	if needsGuardian {
+		// Billed the same way once the guardian approves
+		paymentStatus = models.PaymentStatusGuardianHold
+	}
+
+	newTest := models.NewTest(testId, submission.Name, submission.Age, submission.Gender, "BIG_5", user.ID, paymentStatus, "", "", "PENDING")
+	newTest.OrganizationId = organizationId
+	if creditLot != nil {
+		newTest.CreditLotId = creditLot.ID
+	}
+
+	if err := mgm.Coll(&models.Test{}).Create(newTest); err != nil {
+		controller.DiscardSubmission(c, testId, "")
+		controller.RestoreTestCredit(creditLot, testId, "test could not be saved")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create test"})
+		return
+	}
+
+	if err := controller.RecordConsents(user.ID, newTest.ID, consentDocuments, consentContextFor(c, "partner")); err != nil {
+		log.Println(":: Error : " + err.Error())
+		controller.DiscardSubmission(c, testId, "")
+		controller.RestoreTestCredit(creditLot, testId, "consents could not be recorded")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record consents"})
+		return
+	}
+
+	if scoreErr := controller.StoreScores(c, user.ID, newTest.ID, submission.Answers); scoreErr != nil {
+		controller.DiscardSubmission(c, testId, "")
+		controller.RestoreTestCredit(creditLot, testId, "scores could not be stored")
+		c.JSON(scoreErr.Code, gin.H{"error": scoreErr.Message})
+		return
+	}
+
+	if needsGuardian {
+		// The partner has already paid or will be billed, the guardian only approves
+		if err := controller.RequestGuardianConsent(*newTest, *submission.Guardian, false); err != nil {
+			log.Println(":: Error : " + err.Error())
+			controller.DiscardSubmission(c, testId, "")
+			controller.RestoreTestCredit(creditLot, testId, "guardian consent could not be requested")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request guardian consent"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Submission successful, waiting for guardian consent", "testId": newTest.ID.Hex()})
+		return
+	}
+
+	go controller.GenerateNewReport(c, *newTest, *user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Submission successful", "testId": newTest.ID.Hex()})
+}
+
+// HandlePartnerReport returns a report for a test the partner created
+func HandlePartnerReport(c *gin.Context) {
+	principal := middlewares.CurrentPrincipal(c)
+
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	test, err := models.FetchTestById(testId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if principal.Role != "ADMIN" && test.OrganizationId.Hex() != principal.OrganizationId {
+		c.JSON(http.StatusNotFound, gin.H{"error": "test with ID " + testId.Hex() + " not found"})
+		return
+	}
+
+	reports, err := controller.GetCompleteReportByTestId(testId.Hex())
+	if errors.Is(err, controller.ErrReportRevoked) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Report is not ready yet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reports)
+}
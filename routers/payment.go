@@ -1,6 +1,7 @@
 package routers
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	apis "myproject/apis"
@@ -16,17 +17,6 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func ExtractTestId(referenceId string) (string, error) {
-	// Split the input string by the underscore delimiter
-	parts := strings.Split(referenceId, "_")
-	if len(parts) != 2 {
-		return "", fmt.Errorf("input string is not in the expected format")
-	}
-
-	// Return the second part of the split string
-	return parts[1], nil
-}
-
 func HandlePaymentCallback(c *gin.Context) {
 
 	webappDomain := os.Getenv("WEBAPP_DOMAIN")
@@ -42,40 +32,32 @@ func HandlePaymentCallback(c *gin.Context) {
 		}
 	}
 
-	// Extract required query parameters
-	referenceId := queryParams.Get("razorpay_payment_link_reference_id")
-	paymentLinkStatus := queryParams.Get("razorpay_payment_link_status")
-	paymentLinkId := queryParams.Get("razorpay_payment_link_id")
-	razorpayPaymentId := queryParams.Get("razorpay_payment_id")
-	signature := queryParams.Get("razorpay_signature")
-
-	// Check if all required parameters are present
-	if referenceId == "" || paymentLinkStatus == "" || paymentLinkId == "" || razorpayPaymentId == "" || signature == "" {
+	// Razorpay callbacks predate the provider parameter
+	provider, err := apis.GetPaymentProvider(c.DefaultQuery("provider", "razorpay"))
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
 		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=failed")
 		return
 	}
 
-	params := map[string]interface{}{
-		"payment_link_id":           paymentLinkId,
-		"razorpay_payment_id":       razorpayPaymentId,
-		"payment_link_reference_id": referenceId,
-		"payment_link_status":       paymentLinkStatus,
-	}
-
 	// Verify the payment link
-	if apis.VerifyPaymentLink(params, signature) {
+	event, err := provider.VerifyCallback(queryParams)
+	if err == nil {
 		// Payment verification successful
-		// Mark payment status of test as successful
-
-		testIdString, err := ExtractTestId(referenceId)
-		if err != nil {
-			log.Println(":: Error : " + err.Error())
-			c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
-			return
+		if kind, id, err := controller.ParsePaymentReference(event.ReferenceId); err == nil {
+			switch kind {
+			case controller.ReferenceKindCredits:
+				handleCreditPurchaseCallback(c, id, *event, webappPaymentStatusPath)
+				return
+			case controller.ReferenceKindGift:
+				handleGiftPurchaseCallback(c, id, *event, webappPaymentStatusPath)
+				return
+			}
 		}
 
-		log.Println(testIdString)
-		testId, err := primitive.ObjectIDFromHex(testIdString)
+		// Mark payment status of test as successful
+
+		testId, err := controller.TestIdFromReference(event.ReferenceId)
 		if err != nil {
 			log.Println(":: Error : " + err.Error())
 			c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
@@ -89,25 +71,42 @@ func HandlePaymentCallback(c *gin.Context) {
 			return
 		}
 
-		if test.PaymentStatus == "paid" {
-			c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=success&message=Already marked paid")
+		// Settled tests, refunded ones included, are never moved by a replayed callback
+		if query := settledCallbackQuery(test.PaymentStatus); query != "" {
+			c.Redirect(http.StatusFound, webappPaymentStatusPath+query)
 			return
 		}
 
-		updatedTest, err := models.UpdateTestPaymentStatus(testId, paymentLinkStatus)
-		if err != nil {
-			log.Println(":: Error : " + err.Error())
-			c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
+		// The webhook may settle the same payment; the report is generated only once
+		if event.Status == models.PaymentStatusPaid {
+			if _, myErr := controller.CompleteTestPayment(c.Copy(), testId, event.PaymentId); myErr != nil {
+				c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
+				return
+			}
+		} else if event.Status == apis.LinkStatusCreated {
+			// The customer came back without paying; the link is still open
+			c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment was not completed")
 			return
+		} else {
+			// Only the test's current link can close it, so a callback for a
+			// replaced link leaves the new one payable
+			if _, err := models.ClosePendingTestPayment(testId, event.LinkId, event.Status); err != nil {
+				log.Println(":: Error : " + err.Error())
+				c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
+				return
+			}
 		}
 
-		log.Println(updatedTest.TestGiver)
-		user := models.FetchUserUsingId(updatedTest.UserId)
+		payload, _ := json.Marshal(event)
+		controller.RecordPaymentEvent(event.LinkId, event.PaymentId, controller.LedgerStatusFor(event.Status), models.PaymentEvent{
+			Status:            strings.ToUpper(event.Status),
+			Source:            "callback",
+			ProviderPaymentId: event.PaymentId,
+			Amount:            event.Amount,
+			Currency:          event.Currency,
+			Payload:           string(payload),
+		})
 
-		// Generate report if the payment status is "paid"
-		if paymentLinkStatus == "paid" {
-			go controller.GenerateNewReport(c, *test, user)
-		}
 		link := os.Getenv("WEBAPP_DOMAIN") + os.Getenv("REPORT_PATH") + test.ID.Hex()
 		time.Sleep(2 * time.Second)
 		c.Redirect(http.StatusFound, link)
@@ -116,5 +115,94 @@ func HandlePaymentCallback(c *gin.Context) {
 	}
 
 	// In case of failed signature
+	log.Println(":: Error : " + err.Error())
 	c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=failed&message=Sorry! Please try again")
 }
+
+// settledCallbackQuery is where the customer is sent back to when their test
+// can no longer be paid, or "" while it still can
+func settledCallbackQuery(paymentStatus string) string {
+	switch {
+	case models.IsPayableStatus(paymentStatus):
+		return ""
+	case models.IsRefundedStatus(paymentStatus):
+		return "?status=failed&message=This payment has been refunded"
+	default:
+		return "?status=success&message=Already marked paid"
+	}
+}
+
+// HandleRegeneratePaymentLink gives the user a fresh payment link for an unpaid test
+func HandleRegeneratePaymentLink(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can request a payment link"})
+		return
+	}
+
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	shortURL, myErr := controller.RegeneratePaymentLink(c, testId, userId)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "A new payment link has been created and emailed", "paymentLink": shortURL})
+}
+
+// handleCreditPurchaseCallback settles a bundle the customer just paid for
+func handleCreditPurchaseCallback(c *gin.Context, purchaseId primitive.ObjectID, event apis.PaymentEvent, webappPaymentStatusPath string) {
+	if event.Status != models.PaymentStatusPaid {
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment was not completed")
+		return
+	}
+
+	// The webhook may add the same credits; they are added only once
+	if err := controller.CompleteCreditPurchase(purchaseId, event.PaymentId); err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
+		return
+	}
+
+	payload, _ := json.Marshal(event)
+	controller.RecordPaymentEvent(event.LinkId, event.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+		Source:            "callback",
+		ProviderPaymentId: event.PaymentId,
+		Amount:            event.Amount,
+		Currency:          event.Currency,
+		Payload:           string(payload),
+	})
+
+	c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=success&message=Your credits have been added to your wallet")
+}
+
+// handleGiftPurchaseCallback settles a gift the buyer just paid for
+func handleGiftPurchaseCallback(c *gin.Context, giftId primitive.ObjectID, event apis.PaymentEvent, webappPaymentStatusPath string) {
+	if event.Status != models.PaymentStatusPaid {
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment was not completed")
+		return
+	}
+
+	// The webhook may settle the same gift; the recipient is invited once
+	if err := controller.CompleteGiftPurchase(giftId, event.PaymentId); err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=pending&message=Your payment is being processed")
+		return
+	}
+
+	payload, _ := json.Marshal(event)
+	controller.RecordPaymentEvent(event.LinkId, event.PaymentId, models.LedgerStatusPaid, models.PaymentEvent{
+		Source:            "callback",
+		ProviderPaymentId: event.PaymentId,
+		Amount:            event.Amount,
+		Currency:          event.Currency,
+		Payload:           string(payload),
+	})
+
+	c.Redirect(http.StatusFound, webappPaymentStatusPath+"?status=success&message=Your gift is on its way")
+}
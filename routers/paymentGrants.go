@@ -0,0 +1,65 @@
+package routers
+
+import (
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IssuePaymentGrant creates a single use token that lets one test skip payment.
+// The token is only returned here.
+func IssuePaymentGrant(c *gin.Context) {
+	var request response.PaymentGrant
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment grant data"})
+		return
+	}
+	if request.TestName == "" {
+		request.TestName = "BIG_5"
+	}
+
+	validity := time.Duration(request.ValidHours) * time.Hour
+	grant, token, myErr := controller.IssuePaymentGrant(middlewares.CurrentPrincipal(c).Subject, request.Reason, request.TestName, request.Email, validity)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"grant": grant, "token": token})
+}
+
+// ListPaymentGrants returns a page of grants, newest first, filtered with ?status=
+func ListPaymentGrants(c *gin.Context) {
+	page, limit := parsePagination(c)
+
+	grants, total, err := models.FetchPaymentGrants(c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment grants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"grants": grants, "page": page, "limit": limit, "total": total})
+}
+
+// RevokePaymentGrant withdraws a grant that hasn't been used
+func RevokePaymentGrant(c *gin.Context) {
+	grantId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment grant ID"})
+		return
+	}
+
+	grant, myErr := controller.RevokePaymentGrant(grantId, middlewares.CurrentPrincipal(c).Subject)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, grant)
+}
@@ -0,0 +1,28 @@
+package routers
+
+import (
+	"myproject/models"
+	"strings"
+	"testing"
+)
+
+// A signed callback replayed after the test was refunded must not settle it again
+func TestCallbackReplayAfterRefund(t *testing.T) {
+	if query := settledCallbackQuery(models.PaymentStatusPending); query != "" {
+		t.Fatalf("pending test treated as settled: %q", query)
+	}
+
+	for _, status := range []string{models.PaymentStatusRefunded, models.PaymentStatusPartRefunded} {
+		query := settledCallbackQuery(status)
+		if query == "" {
+			t.Fatalf("callback for %s test would mark it paid again", status)
+		}
+		if !strings.Contains(query, "refunded") {
+			t.Errorf("callback for %s test redirects to %q, want a refund message", status, query)
+		}
+	}
+
+	if query := settledCallbackQuery(models.PaymentStatusPaid); !strings.Contains(query, "status=success") {
+		t.Errorf("callback for paid test redirects to %q", query)
+	}
+}
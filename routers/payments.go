@@ -0,0 +1,110 @@
+package routers
+
+import (
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// parseDateParam accepts either a date (2006-01-02) or an RFC3339 timestamp
+func parseDateParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// HandleListPayments lets admins search the payment ledger by user, test, status and creation date
+func HandleListPayments(c *gin.Context) {
+	query := models.PaymentQuery{Status: c.Query("status")}
+
+	if userId := c.Query("userId"); userId != "" {
+		oid, err := primitive.ObjectIDFromHex(userId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		query.UserId = oid
+	}
+
+	if testId := c.Query("testId"); testId != "" {
+		oid, err := primitive.ObjectIDFromHex(testId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+			return
+		}
+		query.TestId = oid
+	}
+
+	from, err := parseDateParam(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+		return
+	}
+	to, err := parseDateParam(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+		return
+	}
+	query.From = from
+	query.To = to
+
+	page, limit := parsePagination(c)
+
+	payments, total, err := models.FindPayments(query, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payments": payments, "page": page, "limit": limit, "total": total})
+}
+
+// HandleGetPayment returns a single ledger entry with its full history
+func HandleGetPayment(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	payment, err := models.FetchPaymentById(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
+
+// HandleAdminRefund refunds all or part of a test's payment and optionally revokes its report
+func HandleAdminRefund(c *gin.Context) {
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	var request response.Refund
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund request"})
+		return
+	}
+
+	payment, myErr := controller.RefundTest(testId, request.Amount, request.Reason, request.RevokeReport, middlewares.CurrentPrincipal(c).Subject)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Refund issued", "payment": payment})
+}
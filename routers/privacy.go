@@ -0,0 +1,162 @@
+package routers
+
+import (
+	"log"
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kamva/mgm/v3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// exportUserData streams a user's data as JSON or, with ?format=zip, a ZIP bundle
+func exportUserData(c *gin.Context, userId primitive.ObjectID, actor string) {
+	export, err := controller.BuildUserExport(userId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	models.RecordAudit(actor, "DATA_EXPORTED", userId.Hex(), map[string]interface{}{"format": c.DefaultQuery("format", "json")})
+
+	if c.Query("format") == "zip" {
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", "attachment; filename=mindsarthi-export-"+userId.Hex()+".zip")
+		if err := controller.WriteUserExportZip(c.Writer, export); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// scheduleErasure records an erasure request after the grace period, or now when immediate
+func scheduleErasure(c *gin.Context, userId primitive.ObjectID, actor string, request response.Erasure) {
+	if _, err := models.FetchScheduledErasureRequest(userId); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "An erasure request is already scheduled"})
+		return
+	}
+
+	mode := request.Mode
+	if mode == "" {
+		mode = models.ErasureModeAnonymise
+	}
+	if mode != models.ErasureModeAnonymise && mode != models.ErasureModeDelete {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid erasure mode"})
+		return
+	}
+
+	scheduledFor := time.Now().Add(controller.ErasureGracePeriod())
+	if request.Immediate {
+		scheduledFor = time.Now()
+	}
+
+	erasure := models.NewErasureRequest(userId, actor, request.Reason, mode, scheduledFor)
+	if err := mgm.Coll(erasure).Create(erasure); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule erasure"})
+		return
+	}
+
+	models.RecordAudit(actor, "ERASURE_REQUESTED", userId.Hex(), map[string]interface{}{
+		"requestId":    erasure.ID.Hex(),
+		"mode":         mode,
+		"reason":       request.Reason,
+		"scheduledFor": scheduledFor,
+	})
+
+	if request.Immediate {
+		if err := controller.ProcessDueErasureRequests(); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+	}
+
+	c.JSON(http.StatusOK, erasure)
+}
+
+// HandleExportMyData lets a user download everything we hold about them
+func HandleExportMyData(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can export their data"})
+		return
+	}
+
+	exportUserData(c, userId, userId.Hex())
+}
+
+// HandleRequestMyErasure schedules deletion of the signed in user's data
+func HandleRequestMyErasure(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can request erasure"})
+		return
+	}
+
+	var request response.Erasure
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid erasure request"})
+		return
+	}
+	request.Immediate = false
+
+	scheduleErasure(c, userId, userId.Hex(), request)
+}
+
+// HandleCancelMyErasure cancels a scheduled erasure during the grace period
+func HandleCancelMyErasure(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users can cancel erasure"})
+		return
+	}
+
+	erasure, err := models.FetchScheduledErasureRequest(userId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.UpdateErasureRequestStatus(erasure.ID, "CANCELLED"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel erasure"})
+		return
+	}
+
+	models.RecordAudit(userId.Hex(), "ERASURE_CANCELLED", userId.Hex(), map[string]interface{}{"requestId": erasure.ID.Hex()})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Erasure request cancelled"})
+}
+
+// HandleAdminExportUser exports a user's data on their behalf
+func HandleAdminExportUser(c *gin.Context) {
+	userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	exportUserData(c, userId, middlewares.CurrentPrincipal(c).Subject)
+}
+
+// HandleAdminErasure schedules, or with "immediate" performs, erasure of a user's data
+func HandleAdminErasure(c *gin.Context) {
+	userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var request response.Erasure
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid erasure request"})
+		return
+	}
+
+	scheduleErasure(c, userId, middlewares.CurrentPrincipal(c).Subject, request)
+}
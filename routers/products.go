@@ -0,0 +1,133 @@
+package routers
+
+import (
+	"log"
+	apis "myproject/apis"
+	"myproject/controller"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pricingCountry is the country a request is priced for: the billing country
+// when given, otherwise the one the CDN reports in GEOIP_COUNTRY_HEADER, and
+// last the country resolver's guess from the client IP
+func pricingCountry(c *gin.Context, billingCountry string) string {
+	if country := controller.NormalizeCountry(billingCountry); country != "" {
+		return country
+	}
+
+	if header := os.Getenv("GEOIP_COUNTRY_HEADER"); header != "" {
+		// CDNs send XX for unknown and T1 for Tor, neither is a country
+		if country := controller.NormalizeCountry(c.GetHeader(header)); country != "" && country != "XX" && country != "T1" {
+			return country
+		}
+	}
+
+	country, err := apis.GetCountryResolver().CountryForIP(c.ClientIP())
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return ""
+	}
+	return controller.NormalizeCountry(country)
+}
+
+// HandleGetPrice quotes a report in the visitor's currency, so prices shown
+// before the test match what the payment link charges
+func HandleGetPrice(c *gin.Context) {
+	country := pricingCountry(c, c.Query("country"))
+
+	quote, err := controller.QuoteTest("BIG_5", c.Query("tier"), c.Query("currency"), country)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This report is not available for purchase"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sku":      quote.Sku,
+		"amount":   quote.Amount,
+		"currency": quote.Currency,
+		"country":  country,
+	})
+}
+
+// HandleListProducts is the public catalog the webapp shows prices from
+func HandleListProducts(c *gin.Context) {
+	products, err := models.FetchProducts(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, products)
+}
+
+// ListAllProducts includes retired products for admins
+func ListAllProducts(c *gin.Context) {
+	products, err := models.FetchProducts(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, products)
+}
+
+// UpsertProduct creates a product or replaces the one with the same sku
+func UpsertProduct(c *gin.Context) {
+	var request response.Product
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product data"})
+		return
+	}
+
+	if request.Tier == "" {
+		request.Tier = models.TierStandard
+	}
+
+	prices := map[string]int64{}
+	for currency, amount := range request.Prices {
+		if amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Price for " + currency + " must be positive"})
+			return
+		}
+		prices[strings.ToUpper(currency)] = amount
+	}
+	if len(prices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one price is required"})
+		return
+	}
+
+	if request.Credits < 0 || request.CreditValidityDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Credits and validity can't be negative"})
+		return
+	}
+
+	product := models.NewProduct(request.Sku, request.Name, request.Description, request.TestName, request.Tier, prices, request.TaxClass, request.HsnSac)
+	product.Credits = request.Credits
+	product.CreditValidityDays = request.CreditValidityDays
+	saved, err := models.UpsertProduct(product)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// SetProductActive retires a product with active=false, or brings it back
+func SetProductActive(c *gin.Context) {
+	active := c.DefaultQuery("active", "false") == "true"
+
+	product, err := models.SetProductActive(c.Param("sku"), active)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
+}
@@ -0,0 +1,59 @@
+package routers
+
+import (
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func toProfile(profile response.Profile) models.Profile {
+	return models.Profile{
+		EducationLevel: profile.EducationLevel,
+		Stream:         profile.Stream,
+		Occupation:     profile.Occupation,
+		Goals:          profile.Goals,
+		City:           profile.City,
+	}
+}
+
+// HandleGetMyProfile returns the signed in user's onboarding profile
+func HandleGetMyProfile(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a profile"})
+		return
+	}
+
+	user := models.FetchUserUsingId(userId)
+	if user.ID.IsZero() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"onboarding_status": user.OnBoardingStatus, "profile": user.Profile})
+}
+
+// HandleUpdateMyProfile saves the onboarding questionnaire and completes onboarding
+func HandleUpdateMyProfile(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a profile"})
+		return
+	}
+
+	var request response.Profile
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile data"})
+		return
+	}
+
+	user, err := models.UpdateUserProfile(userId, toProfile(request))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"onboarding_status": user.OnBoardingStatus, "profile": user.Profile})
+}
@@ -0,0 +1,48 @@
+package routers
+
+import (
+	"log"
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetReconciliationReport returns the reconciliation report of ?date=2006-01-02, today by default
+func HandleGetReconciliationReport(c *gin.Context) {
+	date := c.DefaultQuery("date", controller.ReconciliationDate(time.Now()))
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date"})
+		return
+	}
+
+	report, err := models.FetchReconciliationReport(date)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// HandleRunReconciliation reconciles now instead of waiting for the next scheduled run
+func HandleRunReconciliation(c *gin.Context) {
+	if err := controller.ReconcilePayments(); err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reconciliation failed"})
+		return
+	}
+
+	models.RecordAudit(middlewares.CurrentPrincipal(c).Subject, "RECONCILIATION_RUN", "payments", nil)
+
+	report, err := models.FetchReconciliationReport(controller.ReconciliationDate(time.Now()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,89 @@
+package routers
+
+import (
+	"log"
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// HandleGetMyReferrals returns the signed in user's referral code, link and stats
+func HandleGetMyReferrals(c *gin.Context) {
+	userId, ok := currentUserId(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only users have a dashboard"})
+		return
+	}
+
+	code, err := controller.MyReferralCode(userId)
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral code"})
+		return
+	}
+
+	stats, err := models.FetchReferralStats(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":            code.Code,
+		"link":            controller.ReferralLink(code.Code),
+		"discountPercent": controller.ReferralDiscountPercent(),
+		"rewardCredits":   controller.ReferralRewardCredits(),
+		"stats":           stats,
+	})
+}
+
+// ListReferrals returns a page of referrals, newest first, filtered with ?status=
+func ListReferrals(c *gin.Context) {
+	page, limit := parsePagination(c)
+
+	referrals, total, err := models.FetchReferrals(c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referrals": referrals, "page": page, "limit": limit, "total": total})
+}
+
+// ApproveReferral clears a flagged referral so its referrer is rewarded
+func ApproveReferral(c *gin.Context) {
+	referralId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid referral ID"})
+		return
+	}
+
+	referral, myErr := controller.ApproveReferral(referralId, middlewares.CurrentPrincipal(c).Subject)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, referral)
+}
+
+// RejectReferral refuses a referral's reward
+func RejectReferral(c *gin.Context) {
+	referralId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid referral ID"})
+		return
+	}
+
+	referral, myErr := controller.RejectReferral(referralId, middlewares.CurrentPrincipal(c).Subject)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, referral)
+}
@@ -1,10 +1,12 @@
 package routers
 
 import (
+	"errors"
 	controller "myproject/controller"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func HandleBig5Report(c *gin.Context) {
@@ -12,6 +14,14 @@ func HandleBig5Report(c *gin.Context) {
 	testId := c.Param("testId")
 
 	reports, err := controller.GetCompleteReportByTestId(testId)
+	if errors.Is(err, controller.ErrReportRevoked) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, controller.ErrReportPending) {
+		c.JSON(http.StatusAccepted, gin.H{"message": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reports", "message": err.Error()})
 		return
@@ -19,3 +29,23 @@ func HandleBig5Report(c *gin.Context) {
 
 	c.JSON(http.StatusOK, reports)
 }
+
+// HandleUpgradeReport returns the payment link that unlocks the full report
+// of a test on the free summary. Like the report itself, it is reached
+// through the test's link, so it never issues a new one: signed in owners do
+// that through /me/tests/:testId/payment-link.
+func HandleUpgradeReport(c *gin.Context) {
+	testId, err := primitive.ObjectIDFromHex(c.Param("testId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test ID"})
+		return
+	}
+
+	paymentLink, myErr := controller.UpgradeReport(testId)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Pay to unlock the full report", "paymentLink": paymentLink})
+}
@@ -0,0 +1,199 @@
+package routers
+
+import (
+	"log"
+	"myproject/controller"
+	"myproject/middlewares"
+	"myproject/models"
+	"myproject/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// voucherBatchFromParam loads the batch in :id. With a non zero
+// organizationId, batches of other organizations are reported as not found.
+func voucherBatchFromParam(c *gin.Context, organizationId primitive.ObjectID) (*models.VoucherBatch, bool) {
+	batchId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid voucher batch ID"})
+		return nil, false
+	}
+
+	batch, err := models.FetchVoucherBatchById(batchId)
+	if err != nil || (!organizationId.IsZero() && batch.OrganizationId != organizationId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "voucher batch with ID " + batchId.Hex() + " not found"})
+		return nil, false
+	}
+	return batch, true
+}
+
+// respondVoucherCSV streams a batch's codes as a CSV download
+func respondVoucherCSV(c *gin.Context, batch *models.VoucherBatch) {
+	vouchers, err := models.FetchVouchersByBatch(batch.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vouchers"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=\"vouchers-"+batch.ID.Hex()+".csv\"")
+	if err := controller.WriteVoucherCSV(c.Writer, vouchers); err != nil {
+		log.Println(":: Error : failed to write vouchers: " + err.Error())
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// respondVoucherUsage writes how much of a batch has been used
+func respondVoucherUsage(c *gin.Context, batch *models.VoucherBatch) {
+	usage, err := models.FetchVoucherBatchUsage(batch)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch voucher usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"batch": batch, "usage": usage})
+}
+
+// partnerOrganizationId is the organization behind the calling API key
+func partnerOrganizationId(c *gin.Context) (primitive.ObjectID, bool) {
+	organizationId, err := primitive.ObjectIDFromHex(middlewares.CurrentPrincipal(c).OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key is not tied to an organization"})
+		return primitive.NilObjectID, false
+	}
+	return organizationId, true
+}
+
+// CreateVoucherBatch generates single use voucher codes an organization paid for
+func CreateVoucherBatch(c *gin.Context) {
+	var request response.VoucherBatch
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid voucher batch data"})
+		return
+	}
+
+	organizationId, err := primitive.ObjectIDFromHex(request.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+	if request.TestName == "" {
+		request.TestName = "BIG_5"
+	}
+
+	batch, myErr := controller.CreateVoucherBatch(organizationId, request.Name, request.TestName, request.Quantity, request.ValidUntil, middlewares.CurrentPrincipal(c).Subject)
+	if myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
+
+// ListVoucherBatches returns every batch, newest first, or one organization's with ?organizationId=
+func ListVoucherBatches(c *gin.Context) {
+	organizationId := primitive.NilObjectID
+	if id := c.Query("organizationId"); id != "" {
+		parsed, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		organizationId = parsed
+	}
+
+	batches, err := models.FetchVoucherBatches(organizationId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch voucher batches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, batches)
+}
+
+// ExportVoucherBatch downloads a batch's codes as CSV
+func ExportVoucherBatch(c *gin.Context) {
+	batch, ok := voucherBatchFromParam(c, primitive.NilObjectID)
+	if !ok {
+		return
+	}
+
+	models.RecordAudit(middlewares.CurrentPrincipal(c).Subject, "VOUCHER_BATCH_EXPORTED", batch.ID.Hex(), nil)
+	respondVoucherCSV(c, batch)
+}
+
+// HandleVoucherBatchUsage reports how a batch has been used
+func HandleVoucherBatchUsage(c *gin.Context) {
+	batch, ok := voucherBatchFromParam(c, primitive.NilObjectID)
+	if !ok {
+		return
+	}
+
+	respondVoucherUsage(c, batch)
+}
+
+// DeactivateVoucherBatch stops a batch and voids its unused codes
+func DeactivateVoucherBatch(c *gin.Context) {
+	batchId, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid voucher batch ID"})
+		return
+	}
+
+	batch, voided, err := models.DeactivateVoucherBatch(batchId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.RecordAudit(middlewares.CurrentPrincipal(c).Subject, "VOUCHER_BATCH_DEACTIVATED", batch.ID.Hex(), map[string]interface{}{"voided": voided})
+
+	c.JSON(http.StatusOK, gin.H{"batch": batch, "voided": voided})
+}
+
+// HandleListPartnerVoucherBatches returns the partner organization's batches
+func HandleListPartnerVoucherBatches(c *gin.Context) {
+	organizationId, ok := partnerOrganizationId(c)
+	if !ok {
+		return
+	}
+
+	batches, err := models.FetchVoucherBatches(organizationId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch voucher batches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, batches)
+}
+
+// HandleExportPartnerVoucherBatch downloads one of the partner's batches as CSV
+func HandleExportPartnerVoucherBatch(c *gin.Context) {
+	organizationId, ok := partnerOrganizationId(c)
+	if !ok {
+		return
+	}
+	batch, ok := voucherBatchFromParam(c, organizationId)
+	if !ok {
+		return
+	}
+
+	models.RecordAudit(middlewares.CurrentPrincipal(c).Subject, "VOUCHER_BATCH_EXPORTED", batch.ID.Hex(), nil)
+	respondVoucherCSV(c, batch)
+}
+
+// HandlePartnerVoucherBatchUsage reports how one of the partner's batches has been used
+func HandlePartnerVoucherBatchUsage(c *gin.Context) {
+	organizationId, ok := partnerOrganizationId(c)
+	if !ok {
+		return
+	}
+	batch, ok := voucherBatchFromParam(c, organizationId)
+	if !ok {
+		return
+	}
+
+	respondVoucherUsage(c, batch)
+}
@@ -0,0 +1,101 @@
+package routers
+
+import (
+	"log"
+	apis "myproject/apis"
+	"myproject/controller"
+	"myproject/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// processPaymentEvent claims a verified event so retried deliveries are
+// applied once, then applies it. The bool reports a duplicate delivery.
+func processPaymentEvent(c *gin.Context, provider apis.PaymentProvider, event *apis.PaymentEvent, body []byte) (bool, *controller.MyError) {
+	webhookEvent, pending, err := models.ClaimWebhookEvent(provider.Name(), event.Id, event.Type, controller.RedactWebhookPayload(body))
+	if err != nil {
+		log.Println(":: Error : " + err.Error())
+		return false, &controller.MyError{Code: http.StatusInternalServerError, Message: "Failed to record event"}
+	}
+	if !pending {
+		return true, nil
+	}
+
+	if myErr := controller.HandlePaymentEvent(c, provider.Name(), *event, string(body)); myErr != nil {
+		if err := models.UpdateWebhookEventStatus(webhookEvent.ID, "FAILED", myErr.Message); err != nil {
+			log.Println(":: Error : " + err.Error())
+		}
+		return false, myErr
+	}
+
+	if err := models.UpdateWebhookEventStatus(webhookEvent.ID, "PROCESSED", ""); err != nil {
+		log.Println(":: Error : " + err.Error())
+	}
+	return false, nil
+}
+
+// HandlePaymentWebhook confirms payments server to server, so a test is
+// settled even when the browser never follows the payment redirect
+func HandlePaymentWebhook(c *gin.Context) {
+	provider, err := apis.GetPaymentProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+
+	event, err := provider.VerifyWebhook(c.Request.Header, body)
+	if err != nil {
+		log.Println(":: Error : " + provider.Name() + " webhook rejected: " + err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook"})
+		return
+	}
+
+	duplicate, myErr := processPaymentEvent(c, provider, event, body)
+	if myErr != nil {
+		// A non 2xx response makes the provider retry the delivery
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+	if duplicate {
+		c.JSON(http.StatusOK, gin.H{"message": "Event already processed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Event processed"})
+}
+
+// HandleFakeCheckout is the fake provider's payment page. It settles the link
+// with ?outcome=paid|failed|expired (paid by default), delivers the webhook
+// and sends the browser back through the payment callback.
+func HandleFakeCheckout(c *gin.Context) {
+	provider := apis.GetFakeProvider()
+
+	body, signature, returnURL, err := provider.Simulate(c.Param("id"), c.DefaultQuery("outcome", apis.FakeOutcomePaid))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Verified like any delivery so the fake exercises the real path
+	header := http.Header{}
+	header.Set("X-Fake-Signature", signature)
+	event, err := provider.VerifyWebhook(header, body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, myErr := processPaymentEvent(c, provider, event, body); myErr != nil {
+		c.JSON(myErr.Code, gin.H{"error": myErr.Message})
+		return
+	}
+
+	c.Redirect(http.StatusFound, returnURL)
+}